@@ -1,16 +1,14 @@
 package main
 
 import (
-	"bytes"
-	"crypto/sha256"
-	"fmt"
-	"httpfromtcp/internal/headers"
+	"crypto/tls"
+	"httpfromtcp/internal/fileserver"
+	"httpfromtcp/internal/middleware"
+	"httpfromtcp/internal/proxy"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
 	"httpfromtcp/internal/server"
-	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"strings"
@@ -19,6 +17,14 @@ import (
 
 const port = 8080
 
+var httpbinProxy = proxy.NewReverseProxy(proxy.Route{
+	Prefix:      "/httpbin/",
+	Upstream:    "httpbin.org:443",
+	StripPrefix: true,
+	Host:        "httpbin.org",
+	TLS:         &tls.Config{},
+})
+
 func main() {
 	// ser, err := server.Serve(port, handlerFunc)
 	// if err != nil {
@@ -27,7 +33,7 @@ func main() {
 	// defer ser.Close()
 	// log.Println("Server started on port", port)
 
-	ser1, err := server.Serve(port, videoHandler)
+	ser1, err := server.Serve(port, server.Chain(videoHandler, middleware.Compress))
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
@@ -43,44 +49,7 @@ func main() {
 
 func handlerFunc(writer *response.Writer, req *request.Request) {
 	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-		resp, err := http.Get(fmt.Sprintf("https://httpbin.org/%s", strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")))
-		if err != nil {
-			writer.WriteResponse(400, "Failed to fetch from httpbin")
-			return
-		}
-
-		header := headers.NewHeaders()
-		for key, values := range resp.Header {
-			for _, value := range values {
-				header.Put(key, value)
-			}
-		}
-		header.Remove("content-length")
-		header.Put("Transfer-Encoding", "chunked")
-		header.Put("Trailer", "X-Content-SHA256")
-		header.Put("Trailer", "X-Content-Length")
-
-		writer.WriteStatusLine(response.StatusCode(resp.StatusCode))
-		writer.WriteHeaders(header)
-
-		var body []byte
-		for {
-			buffer := make([]byte, 128)
-			n, err := resp.Body.Read(buffer)
-			body = append(body, buffer[:n]...)
-			if err == io.EOF {
-				writer.WriteChunkedBodyDone()
-				break
-			} else if err == nil {
-				writer.WriteChunkedBody(buffer[:n])
-			}
-		}
-		hash := sha256.Sum256(body)
-
-		trailer := headers.NewHeaders()
-		trailer.Put("X-Content-SHA256", fmt.Sprintf("%x", hash))
-		trailer.Put("X-Content-Length", fmt.Sprintf("%d",len(body)))
-		writer.WriteTrailers(trailer)
+		httpbinProxy.Handle(writer, req)
 		return
 	}
 
@@ -139,38 +108,9 @@ func handlerFunc(writer *response.Writer, req *request.Request) {
 }
 
 func videoHandler(writer *response.Writer, req *request.Request) {
-	if req.RequestLine.Method == "GET" && req.RequestLine.RequestTarget == "/video" {
-		video, err := os.ReadFile("assets/vim.mp4")
-		if err != nil {
-			fmt.Printf("Failed to read video. %s", err.Error())
-		}
-
-		writer.WriteStatusLine(200)
-
-		header := response.GetDefaultHeader(0)
-		header.Remove("content-length")
-		header.Replace("content-type", "video/mp4")
-		header.Put("Transfer-Encoding", "chunked")
-		header.Put("Trailer", "X-Content-SHA256")
-		header.Put("Trailer", "X-Content-Length")
-		writer.WriteHeaders(header)
-
-		data := bytes.NewBuffer(video)
-		for {
-			buffer := make([]byte, 128)
-			n, err := data.Read(buffer)
-			if err != nil {
-				break
-			}
-			writer.WriteChunkedBody(buffer[:n])
-		}
-		writer.WriteChunkedBodyDone()
-		hash := sha256.Sum256(video)
-
-		trailer := headers.NewHeaders()
-		trailer.Put("X-Content-SHA256", fmt.Sprintf("%x", hash))
-		trailer.Put("X-Content-Length", fmt.Sprintf("%d",len(video)))
-		writer.WriteTrailers(trailer)
-		
+	if req.RequestLine.RequestTarget == "/video" {
+		fileserver.ServeFile(writer, req, "assets/vim.mp4")
+		return
 	}
-}
\ No newline at end of file
+	writer.WriteResponse(response.StatusNotFound, "Not Found")
+}
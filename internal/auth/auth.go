@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+var (
+	ErrNoCredentials      = errors.New("No credentials")
+	ErrInvalidCredentials = errors.New("Invalid credentials")
+)
+
+// Principal is the client authenticated by one of the middlewares
+type Principal struct {
+	Name string
+	// Scheme is the authentication scheme the client used, such as "Basic"
+	Scheme string
+	// Claims holds the claims of the token for principals authenticated with
+	// a JWT, see jwt.ClaimsOf
+	Claims map[string]any
+}
+
+// principalKey is the context key of the principal of a request
+type principalKey struct{}
+
+// PrincipalOf returns the principal of a request authenticated by one of the
+// middlewares
+func PrincipalOf(req *request.Request) (Principal, bool) {
+	return PrincipalFromContext(req.Context())
+}
+
+// PrincipalFromContext returns the principal stored in the context of an
+// authenticated request
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, found := ctx.Value(principalKey{}).(Principal)
+	return p, found
+}
+
+// authenticator checks the credentials of the request for one scheme. The
+// returned challenge is sent in WWW-Authenticate when it fails
+type authenticator func(req *request.Request, credentials string) (Principal, error)
+
+// middleware wraps the handler with the authentication of one scheme
+func middleware(scheme string, challenge func(err error) string, authenticate authenticator) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			credentials, err := credentials(req, scheme)
+			var p Principal
+			if err == nil {
+				p, err = authenticate(req, credentials)
+			}
+			if err != nil {
+				unauthorized(w, challenge(err))
+				return
+			}
+
+			p.Scheme = scheme
+			next(w, req.WithContext(context.WithValue(req.Context(), principalKey{}, p)))
+		}
+	}
+}
+
+// credentials returns the part of the Authorization header after the scheme
+func credentials(req *request.Request, scheme string) (string, error) {
+	value, isPresent := req.Headers.Get("Authorization")
+	if !isPresent {
+		return "", ErrNoCredentials
+	}
+	requestScheme, credentials, _ := strings.Cut(strings.TrimSpace(value), " ")
+	// scheme names are case-insensitive (RFC 9110 section 11.1)
+	if !strings.EqualFold(requestScheme, scheme) {
+		return "", ErrNoCredentials
+	}
+	credentials = strings.TrimSpace(credentials)
+	if credentials == "" {
+		return "", ErrInvalidCredentials
+	}
+	return credentials, nil
+}
+
+func unauthorized(w *response.Writer, challenge string) {
+	msg := "Unauthorized"
+	header := response.GetDefaultHeader(len(msg))
+	header.Put("WWW-Authenticate", challenge)
+	w.WriteStatusLine(response.StatusUnauthorized)
+	w.WriteHeaders(header)
+	w.WriteBody(msg)
+}
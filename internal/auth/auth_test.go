@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// whoami answers with the name of the principal
+func whoami(w *response.Writer, req *request.Request) {
+	p, found := PrincipalOf(req)
+	if !found {
+		w.WriteResponse(response.StatusInternalServerError, "no principal")
+		return
+	}
+	w.WriteResponse(response.StatusOk, p.Scheme+" "+p.Name)
+}
+
+func parseRequest(t *testing.T, raw string) *request.Request {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return req
+}
+
+func serve(t *testing.T, handler server.Handler, req *request.Request) (*http.Response, string) {
+	t.Helper()
+	w := response.NewWriter()
+	handler(&w, req)
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(w.ReadBuffer())), nil)
+	require.NoError(t, err)
+	var body strings.Builder
+	_, err = bufio.NewReader(resp.Body).WriteTo(&body)
+	require.NoError(t, err)
+	return resp, body.String()
+}
+
+func withAuthorization(t *testing.T, value string) *request.Request {
+	raw := "GET /private HTTP/1.1\r\nHost: localhost\r\n"
+	if value != "" {
+		raw += "Authorization: " + value + "\r\n"
+	}
+	return parseRequest(t, raw+"\r\n")
+}
+
+func basicCredentials(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func TestBasic(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	users, err := ParseHtpasswd(strings.NewReader("# users\n\nalice:" + string(hash) + "\n"))
+	require.NoError(t, err)
+	handler := Basic("admin", users)(whoami)
+
+	resp, body := serve(t, handler, withAuthorization(t, basicCredentials("alice", "s3cret")))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "Basic alice", body)
+
+	for _, value := range []string{
+		"",
+		basicCredentials("alice", "wrong"),
+		basicCredentials("bob", "s3cret"),
+		"basic !!!",
+		"Bearer abc",
+	} {
+		resp, _ := serve(t, handler, withAuthorization(t, value))
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, value)
+		assert.Equal(t, `Basic realm="admin", charset="UTF-8"`, resp.Header.Get("WWW-Authenticate"))
+	}
+
+	_, err = ParseHtpasswd(strings.NewReader("alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+	assert.Error(t, err)
+	_, err = ParseHtpasswd(strings.NewReader("no separator\n"))
+	assert.Error(t, err)
+}
+
+func TestBearer(t *testing.T) {
+	handler := Bearer("api", func(token string) (Principal, error) {
+		if token != "valid-token" {
+			return Principal{}, errors.New("unknown token")
+		}
+		return Principal{Name: "service"}, nil
+	})(whoami)
+
+	resp, body := serve(t, handler, withAuthorization(t, "bearer valid-token"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "Bearer service", body)
+
+	resp, _ = serve(t, handler, withAuthorization(t, ""))
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, `Bearer realm="api"`, resp.Header.Get("WWW-Authenticate"))
+
+	resp, _ = serve(t, handler, withAuthorization(t, "Bearer other"))
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, `Bearer realm="api", error="invalid_token"`, resp.Header.Get("WWW-Authenticate"))
+}
+
+func TestHMAC(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	handler := HMAC("api", map[string][]byte{"client-1": key}, time.Minute)(whoami)
+	body := `{"amount":10}`
+	newRequest := func() *request.Request {
+		return parseRequest(t, fmt.Sprintf(
+			"POST /transfer HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n%s", len(body), body))
+	}
+
+	req := newRequest()
+	SignRequest(req, "client-1", key, time.Now())
+	resp, got := serve(t, handler, req)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "HMAC client-1", got)
+
+	// Test: Digest of a compressed body covers the body as sent
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err := io.WriteString(zw, body)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	req = parseRequest(t, fmt.Sprintf(
+		"POST /transfer HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", compressed.Len(), compressed.String()))
+	SignRequest(req, "client-1", key, time.Now())
+	var sent strings.Builder
+	require.NoError(t, req.Write(&sent))
+	req = parseRequest(t, sent.String())
+	require.NoError(t, req.DecodeBody(0))
+	require.Equal(t, body, string(req.Body))
+	resp, got = serve(t, handler, req)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "HMAC client-1", got)
+
+	tests := map[string]func(req *request.Request){
+		"unsigned": func(req *request.Request) {},
+		"unknown key": func(req *request.Request) {
+			SignRequest(req, "client-2", key, time.Now())
+		},
+		"wrong key": func(req *request.Request) {
+			SignRequest(req, "client-1", []byte("another key"), time.Now())
+		},
+		"stale date": func(req *request.Request) {
+			SignRequest(req, "client-1", key, time.Now().Add(-2*time.Minute))
+		},
+		"tampered body": func(req *request.Request) {
+			SignRequest(req, "client-1", key, time.Now())
+			req.Body = []byte(`{"amount":99}`)
+		},
+		"tampered target": func(req *request.Request) {
+			SignRequest(req, "client-1", key, time.Now())
+			req.RequestLine.RequestTarget = "/admin"
+		},
+	}
+	for name, prepare := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := newRequest()
+			prepare(req)
+			resp, _ := serve(t, handler, req)
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+			assert.True(t, strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), `HMAC realm="api"`))
+		})
+	}
+}
+
+func TestHMACStreamedBody(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	echo := func(w *response.Writer, req *request.Request) {
+		body, err := io.ReadAll(req.BodyReader())
+		if err != nil {
+			w.WriteResponse(response.StatusBadRequest, err.Error())
+			return
+		}
+		w.WriteResponse(response.StatusOk, string(body))
+	}
+	s, err := server.ServeWithOptions(0, HMAC("api", map[string][]byte{"client-1": key}, time.Minute)(echo),
+		server.Options{StreamRequestBodies: true})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	body := `{"amount":10}`
+	req := parseRequest(t, fmt.Sprintf(
+		"POST /transfer HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n%s", len(body), body))
+	SignRequest(req, "client-1", key, time.Now())
+	var signed strings.Builder
+	require.NoError(t, req.Write(&signed))
+
+	send := func(raw string) (*http.Response, string) {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = io.WriteString(conn, raw)
+		require.NoError(t, err)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(got)
+	}
+
+	// Test: The streamed body is verified and still reaches the handler
+	resp, got := send(signed.String())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, body, got)
+
+	// Test: A tampered streamed body is rejected
+	resp, _ = send(strings.Replace(signed.String(), body, `{"amount":99}`, 1))
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestPrincipalOf(t *testing.T) {
+	req := withAuthorization(t, basicCredentials("alice", "pw"))
+	handler := Basic("admin", Credentials{"alice": "pw"})(whoami)
+	serve(t, handler, req)
+
+	// the principal is only available while the request is handled
+	_, found := PrincipalOf(req)
+	assert.False(t, found)
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/server"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordVerifier checks the user name and password sent with Basic
+// authentication
+type PasswordVerifier interface {
+	Verify(user, password string) bool
+}
+
+// Basic requires Basic authentication (RFC 7617) with credentials accepted
+// by users
+func Basic(realm string, users PasswordVerifier) server.Middleware {
+	challenge := func(error) string {
+		return fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm)
+	}
+	return middleware("Basic", challenge, func(_ *request.Request, credentials string) (Principal, error) {
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err != nil {
+			return Principal{}, ErrInvalidCredentials
+		}
+		user, password, found := strings.Cut(string(decoded), ":")
+		if !found || !users.Verify(user, password) {
+			return Principal{}, ErrInvalidCredentials
+		}
+		return Principal{Name: user}, nil
+	})
+}
+
+// Htpasswd holds the bcrypt password hashes of an htpasswd file, as created
+// by `htpasswd -B`
+type Htpasswd struct {
+	hashes map[string][]byte
+}
+
+// dummyHash is compared against for unknown users so that they take as long
+// to reject as wrong passwords
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseHtpasswd(file)
+}
+
+// ParseHtpasswd reads "user:hash" lines. Blank lines and lines starting with
+// # are skipped, hashes other than bcrypt are rejected
+func ParseHtpasswd(r io.Reader) (*Htpasswd, error) {
+	h := &Htpasswd{hashes: make(map[string][]byte)}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, found := strings.Cut(line, ":")
+		if !found || user == "" {
+			return nil, fmt.Errorf("htpasswd line %d: expected user:hash", lineNumber)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("htpasswd line %d: %s does not have a bcrypt hash: %w", lineNumber, user, err)
+		}
+		h.hashes[user] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *Htpasswd) Verify(user, password string) bool {
+	hash, isPresent := h.hashes[user]
+	if !isPresent {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+	// bcrypt compares the hashes in constant time
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// Credentials is a PasswordVerifier over plain text passwords, meant for
+// tests and development
+type Credentials map[string]string
+
+func (c Credentials) Verify(user, password string) bool {
+	expected, isPresent := c[user]
+	if !isPresent {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/server"
+)
+
+// TokenValidator returns the principal a bearer token was issued to, or an
+// error when the token is not valid
+type TokenValidator func(token string) (Principal, error)
+
+// Bearer requires a bearer token (RFC 6750) accepted by validate
+func Bearer(realm string, validate TokenValidator) server.Middleware {
+	challenge := func(err error) string {
+		if errors.Is(err, ErrNoCredentials) {
+			return fmt.Sprintf("Bearer realm=%q", realm)
+		}
+		return fmt.Sprintf("Bearer realm=%q, error=\"invalid_token\"", realm)
+	}
+	return middleware("Bearer", challenge, func(_ *request.Request, token string) (Principal, error) {
+		p, err := validate(token)
+		if err != nil {
+			return Principal{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+		}
+		return p, nil
+	})
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/server"
+)
+
+// DefaultMaxSkew is how far the Date of a signed request may be from the
+// server clock
+const DefaultMaxSkew = 5 * time.Minute
+
+// HMAC requires requests signed with SignRequest by a client holding one of
+// the keys, which are looked up by ID. The signature covers the method, the
+// request target, the Date header and the SHA-256 digest of the body sent in
+// Content-Digest (RFC 9530), and the Date must be within maxSkew of the
+// server clock to limit replays. Streamed bodies are buffered to be checked,
+// up to request.DefaultMaxDecodedBodySize
+func HMAC(realm string, keys map[string][]byte, maxSkew time.Duration) server.Middleware {
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	challenge := func(error) string {
+		return fmt.Sprintf("HMAC realm=%q, headers=\"date content-digest\"", realm)
+	}
+	return middleware("HMAC", challenge, func(req *request.Request, credentials string) (Principal, error) {
+		params := parseParams(credentials)
+		key, isPresent := keys[params["keyId"]]
+		if !isPresent {
+			return Principal{}, fmt.Errorf("%w: unknown key", ErrInvalidCredentials)
+		}
+		signature, err := base64.StdEncoding.DecodeString(params["signature"])
+		if err != nil {
+			return Principal{}, fmt.Errorf("%w: signature encoding", ErrInvalidCredentials)
+		}
+
+		date, _ := req.Headers.Get("Date")
+		sent, err := http.ParseTime(date)
+		if err != nil {
+			return Principal{}, fmt.Errorf("%w: missing or invalid Date", ErrInvalidCredentials)
+		}
+		if skew := time.Since(sent); skew > maxSkew || skew < -maxSkew {
+			return Principal{}, fmt.Errorf("%w: Date is too far from the server clock", ErrInvalidCredentials)
+		}
+
+		body := sentBody(req)
+		if req.BodyStreamed() {
+			// streamed bodies are not decoded, and the handler reads the
+			// buffered copy
+			body, err = req.BufferBody(0)
+			if err != nil {
+				return Principal{}, fmt.Errorf("%w: reading the body: %v", ErrInvalidCredentials, err)
+			}
+		}
+		digest, _ := req.Headers.Get("Content-Digest")
+		if !hmac.Equal([]byte(digest), []byte(contentDigest(body))) {
+			return Principal{}, fmt.Errorf("%w: Content-Digest does not match the body", ErrInvalidCredentials)
+		}
+
+		expected := signature256(key, req.RequestLine.Method, req.RequestLine.RequestTarget, date, digest)
+		if !hmac.Equal(signature, expected) {
+			return Principal{}, fmt.Errorf("%w: bad signature", ErrInvalidCredentials)
+		}
+		return Principal{Name: params["keyId"]}, nil
+	})
+}
+
+// SignRequest sets the Date, Content-Digest and Authorization headers of a
+// request for the HMAC middleware
+func SignRequest(req *request.Request, keyID string, key []byte, now time.Time) {
+	date := now.UTC().Format(http.TimeFormat)
+	digest := contentDigest(sentBody(req))
+	signature := signature256(key, req.RequestLine.Method, req.RequestLine.RequestTarget, date, digest)
+
+	for name, value := range map[string]string{
+		"Date":           date,
+		"Content-Digest": digest,
+		"Authorization": fmt.Sprintf("HMAC keyId=%q, signature=%q",
+			keyID, base64.StdEncoding.EncodeToString(signature)),
+	} {
+		req.Headers.Remove(name)
+		req.Headers.Put(name, value)
+	}
+}
+
+// sentBody returns the body as it was sent, with its content codings, which
+// is what Content-Digest covers (RFC 9530 section 2)
+func sentBody(req *request.Request) []byte {
+	if req.RawBody != nil {
+		return req.RawBody
+	}
+	return req.Body
+}
+
+func contentDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+func signature256(key []byte, method, target, date, digest string) []byte {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, target, date, digest)
+	return mac.Sum(nil)
+}
+
+// parseParams parses the comma separated name="value" parameters of the
+// credentials
+func parseParams(credentials string) map[string]string {
+	params := make(map[string]string)
+	for _, param := range strings.Split(credentials, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(param), "=")
+		if !found {
+			continue
+		}
+		params[strings.TrimSpace(name)] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return params
+}
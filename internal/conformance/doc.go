@@ -0,0 +1,38 @@
+// Package conformance holds the protocol conformance suite of the request
+// parser and the response writer, checked against RFC 9110 and RFC 9112.
+//
+// Cases are files under testdata, request cases in testdata/request and
+// response cases in testdata/response. A case starts with comment lines
+// starting with #, which say what is checked, followed by sections:
+//
+//	# Field names are tokens (RFC 9110 section 5.1)
+//	-- input --
+//	GET / HTTP/1.1\r\n
+//	Bad Name: x\r\n
+//	\r\n
+//	-- want --
+//	error
+//
+// The input of request cases is the raw request. Line breaks of the file are
+// ignored, so CR and LF are written \r and \n; \t, \\ and \xHH escape the
+// other bytes, and \x20 a trailing space. Requests are parsed with several
+// read sizes, which must all give the same result.
+//
+// The input of response cases is the script of a handler, one call to the
+// response.Writer per line, whose arguments use the same escapes:
+//
+//	method HEAD              method of the request answered, GET by default
+//	status 200 [reason]      WriteStatusLine or WriteStatusLineReason
+//	header Name: value       adds a field to the next WriteHeaders
+//	default-headers 5        adds the fields of response.GetDefaultHeader(5)
+//	write-headers [nil]      WriteHeaders, with nil headers when asked
+//	body text                WriteBody
+//	chunk text               WriteChunkedBody
+//	end-chunks               WriteChunkedBodyDone
+//	trailer Name: value      adds a field to the next WriteTrailers
+//	write-trailers           WriteTrailers
+//
+// The want section is the result written back in a canonical form. Run
+// the tests with -update to rewrite it from the current behavior, then
+// review the diff.
+package conformance
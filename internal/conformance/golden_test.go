@@ -0,0 +1,148 @@
+package conformance
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"httpfromtcp/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite the want sections of the cases")
+
+// testCase is a case file, see the package documentation
+type testCase struct {
+	path    string
+	comment string
+	input   []string
+	want    string
+}
+
+func loadCases(t *testing.T, dir string) []*testCase {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join("testdata", dir, "*.txt"))
+	require.NoError(t, err)
+	require.NotEmpty(t, paths)
+
+	var cases []*testCase
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		c, err := parseCase(path, string(data))
+		require.NoError(t, err, path)
+		cases = append(cases, c)
+	}
+	return cases
+}
+
+func parseCase(path, data string) (*testCase, error) {
+	c := &testCase{path: path}
+	var comment, want []string
+	section := ""
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		if name, found := strings.CutPrefix(line, "-- "); found && strings.HasSuffix(name, " --") {
+			section = strings.TrimSuffix(name, " --")
+			continue
+		}
+		switch section {
+		case "":
+			comment = append(comment, line)
+		case "input":
+			c.input = append(c.input, line)
+		case "want":
+			want = append(want, line)
+		default:
+			return nil, fmt.Errorf("unknown section %q", section)
+		}
+	}
+	if c.input == nil {
+		return nil, fmt.Errorf("missing input section")
+	}
+	c.comment = strings.Join(comment, "\n")
+	if len(want) > 0 {
+		c.want = strings.Join(want, "\n") + "\n"
+	}
+	return c, nil
+}
+
+// check compares the result of a case to its want section, or rewrites the
+// section with -update
+func (c *testCase) check(t *testing.T, got string) {
+	t.Helper()
+	if !*update {
+		assert.Equal(t, c.want, got, "%s\n%s", c.path, c.comment)
+		return
+	}
+	if got == c.want {
+		return
+	}
+	var b strings.Builder
+	if c.comment != "" {
+		b.WriteString(c.comment + "\n")
+	}
+	b.WriteString("-- input --\n")
+	b.WriteString(strings.Join(c.input, "\n") + "\n")
+	b.WriteString("-- want --\n")
+	b.WriteString(got)
+	require.NoError(t, os.WriteFile(c.path, []byte(b.String()), 0o644))
+}
+
+// unescape decodes the escapes of case inputs: \r, \n, \t, \\ and \xHH
+func unescape(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 == len(s) {
+			return "", fmt.Errorf("trailing backslash in %q", s)
+		}
+		i++
+		switch s[i] {
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case '\\':
+			b.WriteByte('\\')
+		case 'x':
+			if i+2 >= len(s) {
+				return "", fmt.Errorf("short \\x escape in %q", s)
+			}
+			value, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid \\x escape in %q", s)
+			}
+			b.WriteByte(byte(value))
+			i += 2
+		default:
+			return "", fmt.Errorf("unknown escape \\%c in %q", s[i], s)
+		}
+	}
+	return b.String(), nil
+}
+
+// dumpFields writes the fields sorted by name, one line per value
+func dumpFields(b *strings.Builder, prefix string, h headers.Headers) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		for _, value := range h.Values(name) {
+			fmt.Fprintf(b, "%s %s: %q\n", prefix, strings.ToLower(name), value)
+		}
+	}
+}
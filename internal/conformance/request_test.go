@@ -0,0 +1,73 @@
+package conformance
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"httpfromtcp/internal/request"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readSizes are the sizes of the reads requests are parsed with, 0 reads
+// the whole request at once
+var readSizes = []int{0, 1, 2, 3, 7, 64}
+
+// sizedReader returns at most size bytes per read
+type sizedReader struct {
+	data string
+	size int
+}
+
+func (r *sizedReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[:min(r.size, len(r.data))])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestRequests(t *testing.T) {
+	for _, c := range loadCases(t, "request") {
+		t.Run(strings.TrimSuffix(filepath.Base(c.path), ".txt"), func(t *testing.T) {
+			raw, err := unescape(strings.Join(c.input, ""))
+			require.NoError(t, err)
+
+			var got string
+			for _, size := range readSizes {
+				var reader io.Reader = strings.NewReader(raw)
+				if size > 0 {
+					reader = &sizedReader{data: raw, size: size}
+				}
+				dump := dumpRequest(request.RequestFromReader(reader))
+				if size == 0 {
+					got = dump
+					continue
+				}
+				assert.Equal(t, got, dump, "reads of %d bytes", size)
+			}
+			c.check(t, got)
+		})
+	}
+}
+
+// dumpRequest writes what a handler sees of a parsed request, or only
+// "error" when parsing failed
+func dumpRequest(req *request.Request, err error) string {
+	if err != nil {
+		return "error\n"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "method %s\n", req.RequestLine.Method)
+	fmt.Fprintf(&b, "target %s\n", req.RequestLine.RequestTarget)
+	fmt.Fprintf(&b, "version %s\n", req.RequestLine.HttpVersion)
+	dumpFields(&b, "header", req.Headers)
+	fmt.Fprintf(&b, "body %q\n", req.Body)
+	dumpFields(&b, "trailer", req.Trailers)
+	return b.String()
+}
@@ -0,0 +1,128 @@
+package conformance
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/servertest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponses(t *testing.T) {
+	for _, c := range loadCases(t, "response") {
+		t.Run(strings.TrimSuffix(filepath.Base(c.path), ".txt"), func(t *testing.T) {
+			s := newScript()
+			var errs []string
+			for _, line := range c.input {
+				op, arg, _ := strings.Cut(line, " ")
+				arg, err := unescape(arg)
+				require.NoError(t, err)
+				if err := s.run(op, arg); err != nil {
+					errs = append(errs, "error "+op)
+				}
+			}
+			c.check(t, dumpResponse(s.recorder, errs))
+		})
+	}
+}
+
+// script runs the lines of a response case against a recorder
+type script struct {
+	recorder *servertest.Recorder
+	// headers and trailers hold the fields of the next WriteHeaders and
+	// WriteTrailers calls
+	headers  headers.Headers
+	trailers headers.Headers
+}
+
+func newScript() *script {
+	return &script{
+		recorder: servertest.NewRecorder(),
+		headers:  headers.NewHeaders(),
+		trailers: headers.NewHeaders(),
+	}
+}
+
+// run runs a line of the script, see the package documentation
+func (s *script) run(op, arg string) error {
+	w := &s.recorder.Writer
+	switch op {
+	case "method":
+		s.recorder.Method = arg
+	case "status":
+		code, reason, hasReason := strings.Cut(arg, " ")
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			return err
+		}
+		if hasReason {
+			return w.WriteStatusLineReason(response.StatusCode(status), reason)
+		}
+		return w.WriteStatusLine(response.StatusCode(status))
+	case "header":
+		name, value, _ := strings.Cut(arg, ": ")
+		s.headers.Put(name, value)
+	case "default-headers":
+		length, err := strconv.Atoi(arg)
+		if err != nil {
+			return err
+		}
+		maps.Copy(s.headers, response.GetDefaultHeader(length))
+	case "write-headers":
+		h := s.headers
+		s.headers = headers.NewHeaders()
+		if arg == "nil" {
+			h = nil
+		}
+		return w.WriteHeaders(h)
+	case "body":
+		_, err := w.WriteBody(arg)
+		return err
+	case "chunk":
+		_, err := w.WriteChunkedBody([]byte(arg))
+		return err
+	case "end-chunks":
+		_, err := w.WriteChunkedBodyDone()
+		return err
+	case "trailer":
+		name, value, _ := strings.Cut(arg, ": ")
+		s.trailers.Put(name, value)
+	case "write-trailers":
+		h := s.trailers
+		s.trailers = headers.NewHeaders()
+		return w.WriteTrailers(h)
+	default:
+		return fmt.Errorf("unknown operation %q", op)
+	}
+	return nil
+}
+
+// dumpResponse writes the errors of the script and the response parsed back
+// from what the writer produced
+func dumpResponse(r *servertest.Recorder, errs []string) string {
+	var b strings.Builder
+	for _, err := range errs {
+		b.WriteString(err + "\n")
+	}
+	result, err := r.Result()
+	if err != nil {
+		fmt.Fprintf(&b, "unparsable %q\n", r.Writer.ReadBuffer())
+		return b.String()
+	}
+	for _, interim := range result.Interim {
+		fmt.Fprintln(&b, strings.TrimSpace(fmt.Sprintf("interim %d %s", interim.StatusCode, interim.Reason)))
+		dumpFields(&b, "interim-header", interim.Headers)
+	}
+	fmt.Fprintln(&b, strings.TrimSpace(fmt.Sprintf("status %d %s", result.StatusCode, result.Reason)))
+	dumpFields(&b, "header", result.Headers)
+	fmt.Fprintf(&b, "body %q\n", result.Body)
+	dumpFields(&b, "trailer", result.Trailers)
+	return b.String()
+}
@@ -0,0 +1,238 @@
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/headers"
+)
+
+// SameSite controls whether a cookie is sent with cross-site requests
+type SameSite int
+
+const (
+	// SameSiteDefault omits the attribute and leaves the choice to the
+	// browser
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	}
+	return ""
+}
+
+var ErrInvalidCookie = errors.New("Invalid cookie")
+
+// Cookie is a cookie received in a Cookie header or sent with Set-Cookie
+// (RFC 6265). Only Name and Value are set on received cookies
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path   string
+	Domain string
+	// Expires is omitted when zero
+	Expires time.Time
+	// MaxAge is omitted when zero, a negative value deletes the cookie
+	// with Max-Age=0
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// Valid checks that the cookie can be serialized
+func (c *Cookie) Valid() error {
+	if !isToken(c.Name) {
+		return fmt.Errorf("%w: name %q", ErrInvalidCookie, c.Name)
+	}
+	for i := 0; i < len(c.Value); i++ {
+		if !isCookieOctet(c.Value[i]) {
+			return fmt.Errorf("%w: value of %s contains %q", ErrInvalidCookie, c.Name, c.Value[i])
+		}
+	}
+	if !isAttributeValue(c.Path) {
+		return fmt.Errorf("%w: path %q", ErrInvalidCookie, c.Path)
+	}
+	if !isAttributeValue(c.Domain) || strings.ContainsAny(c.Domain, " /") {
+		return fmt.Errorf("%w: domain %q", ErrInvalidCookie, c.Domain)
+	}
+	// browsers only accept partitioned cookies over secure connections
+	if c.Partitioned && !c.Secure {
+		return fmt.Errorf("%w: partitioned cookie %s must be secure", ErrInvalidCookie, c.Name)
+	}
+	return nil
+}
+
+// String returns the value of the Set-Cookie header for the cookie, or an
+// empty string when it is not valid
+func (c *Cookie) String() string {
+	if c.Valid() != nil {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	b.WriteString(c.Value)
+
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(http.TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=" + c.SameSite.String())
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// Parse parses the value of a Cookie request header into its name/value
+// pairs, in order. Malformed pairs are skipped
+func Parse(header string) []*Cookie {
+	var cookies []*Cookie
+	for _, pair := range strings.Split(header, ";") {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || !isToken(name) {
+			continue
+		}
+		value, valid := parseValue(value)
+		if !valid {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// ParseSetCookie parses the value of a Set-Cookie header. Unknown attributes
+// are ignored as required by RFC 6265 section 5.2
+func ParseSetCookie(line string) (*Cookie, error) {
+	parts := strings.Split(line, ";")
+	name, value, found := strings.Cut(strings.TrimSpace(parts[0]), "=")
+	if !found || !isToken(name) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidCookie, parts[0])
+	}
+	value, valid := parseValue(value)
+	if !valid {
+		return nil, fmt.Errorf("%w: value of %s", ErrInvalidCookie, name)
+	}
+
+	c := &Cookie{Name: name, Value: value}
+	for _, part := range parts[1:] {
+		attribute, attributeValue, _ := strings.Cut(strings.TrimSpace(part), "=")
+		attributeValue = strings.TrimSpace(attributeValue)
+		switch strings.ToLower(strings.TrimSpace(attribute)) {
+		case "path":
+			c.Path = attributeValue
+		case "domain":
+			c.Domain = strings.TrimPrefix(attributeValue, ".")
+		case "expires":
+			expires, err := http.ParseTime(attributeValue)
+			if err == nil {
+				c.Expires = expires
+			}
+		case "max-age":
+			maxAge, err := strconv.Atoi(attributeValue)
+			if err != nil {
+				continue
+			}
+			if maxAge <= 0 {
+				maxAge = -1
+			}
+			c.MaxAge = maxAge
+		case "secure":
+			c.Secure = true
+		case "httponly":
+			c.HttpOnly = true
+		case "samesite":
+			switch strings.ToLower(attributeValue) {
+			case "lax":
+				c.SameSite = SameSiteLax
+			case "strict":
+				c.SameSite = SameSiteStrict
+			case "none":
+				c.SameSite = SameSiteNone
+			}
+		case "partitioned":
+			c.Partitioned = true
+		}
+	}
+	return c, nil
+}
+
+// parseValue strips the optional double quotes around a cookie value and
+// checks its characters
+func parseValue(value string) (string, bool) {
+	if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	for i := 0; i < len(value); i++ {
+		if !isCookieOctet(value[i]) {
+			return "", false
+		}
+	}
+	return value, true
+}
+
+// isCookieOctet excludes controls, whitespace, DQUOTE, comma, semicolon and
+// backslash (RFC 6265 section 4.1.1)
+func isCookieOctet(b byte) bool {
+	return b >= 0x21 && b <= 0x7E && b != '"' && b != ',' && b != ';' && b != '\\'
+}
+
+func isAttributeValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x20 || value[i] == 0x7F || value[i] == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		isAlphaNum := (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
+		if !isAlphaNum && !strings.ContainsRune(headers.VALID_HEADER_KEY_SPECIAL_CHARS, rune(b)) {
+			return false
+		}
+	}
+	return true
+}
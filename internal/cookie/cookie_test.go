@@ -0,0 +1,83 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	cookies := Parse(`session=abc123; theme="dark"; bad name=1; novalue; empty=; x=a,b; lang=en-US`)
+	require.Len(t, cookies, 4)
+	assert.Equal(t, &Cookie{Name: "session", Value: "abc123"}, cookies[0])
+	assert.Equal(t, &Cookie{Name: "theme", Value: "dark"}, cookies[1])
+	assert.Equal(t, &Cookie{Name: "empty", Value: ""}, cookies[2])
+	assert.Equal(t, &Cookie{Name: "lang", Value: "en-US"}, cookies[3])
+
+	assert.Empty(t, Parse(""))
+}
+
+func TestString(t *testing.T) {
+	expires := time.Date(2026, time.March, 1, 10, 30, 0, 0, time.FixedZone("CET", 3600))
+	c := &Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Path:        "/",
+		Domain:      ".example.com",
+		Expires:     expires,
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteLax,
+		Partitioned: true,
+	}
+	assert.Equal(t, "session=abc123; Path=/; Domain=example.com; Expires=Sun, 01 Mar 2026 09:30:00 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=Lax; Partitioned", c.String())
+
+	// Test: Minimal cookie
+	assert.Equal(t, "a=b", (&Cookie{Name: "a", Value: "b"}).String())
+
+	// Test: Deleting a cookie
+	assert.Equal(t, "a=; Max-Age=0", (&Cookie{Name: "a", MaxAge: -1}).String())
+
+	// Test: Invalid cookies
+	invalid := []*Cookie{
+		{Name: "", Value: "b"},
+		{Name: "a b", Value: "b"},
+		{Name: "a", Value: "b;c"},
+		{Name: "a", Value: "b c"},
+		{Name: "a", Value: "b", Path: "/x;Secure"},
+		{Name: "a", Value: "b", Domain: "example.com/x"},
+		{Name: "a", Value: "b", Partitioned: true},
+	}
+	for _, c := range invalid {
+		assert.ErrorIs(t, c.Valid(), ErrInvalidCookie, c)
+		assert.Empty(t, c.String(), c)
+	}
+}
+
+func TestParseSetCookie(t *testing.T) {
+	c, err := ParseSetCookie("id=a3fWa; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Max-Age=0; Domain=.example.com; Path=/docs; Secure; HttpOnly; SameSite=Strict; Partitioned; Unknown=1")
+	require.NoError(t, err)
+	assert.Equal(t, &Cookie{
+		Name:        "id",
+		Value:       "a3fWa",
+		Path:        "/docs",
+		Domain:      "example.com",
+		Expires:     time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC),
+		MaxAge:      -1,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteStrict,
+		Partitioned: true,
+	}, c)
+
+	// Test: Round trip
+	parsed, err := ParseSetCookie(c.String())
+	require.NoError(t, err)
+	assert.Equal(t, c, parsed)
+
+	_, err = ParseSetCookie("no-equals-sign")
+	assert.ErrorIs(t, err, ErrInvalidCookie)
+}
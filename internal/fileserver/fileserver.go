@@ -0,0 +1,311 @@
+package fileserver
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+const indexPage = "index.html"
+
+type FileServer struct {
+	root *os.Root
+	// Prefix is removed from the request target before it is mapped to a
+	// file under the root
+	Prefix string
+}
+
+// New returns a file server for the directory dir. Files are opened through
+// an os.Root so requests cannot reach anything outside of it, including
+// through symlinks
+func New(dir string) (*FileServer, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &FileServer{root: root}, nil
+}
+
+func (fsrv *FileServer) Close() error {
+	return fsrv.root.Close()
+}
+
+// Handle serves the file the request target points to. It can be passed to
+// server.Serve as a server.Handler
+func (fsrv *FileServer) Handle(w *response.Writer, req *request.Request) {
+	if !allowMethod(w, req) {
+		return
+	}
+
+	target, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	if !strings.HasPrefix(target, fsrv.Prefix) {
+		writeError(w, response.StatusNotFound)
+		return
+	}
+	urlPath, err := url.PathUnescape(strings.TrimPrefix(target, fsrv.Prefix))
+	if err != nil || strings.Contains(urlPath, "\x00") {
+		writeError(w, response.StatusBadRequest)
+		return
+	}
+	for _, segment := range strings.Split(urlPath, "/") {
+		if segment == ".." {
+			writeError(w, response.StatusForbidden)
+			return
+		}
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		name = "."
+	}
+
+	file, err := fsrv.root.Open(name)
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		writeError(w, response.StatusInternalServerError)
+		return
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(target, "/") {
+			header := response.GetDefaultHeader(0)
+			header.Put("Location", target+"/")
+			w.WriteStatusLine(response.StatusMovedPermanently)
+			w.WriteHeaders(header)
+			w.WriteBody("")
+			return
+		}
+
+		index, err := fsrv.root.Open(path.Join(name, indexPage))
+		if err != nil {
+			serveDirectory(w, req, file, target)
+			return
+		}
+		defer index.Close()
+		indexInfo, err := index.Stat()
+		if err != nil || indexInfo.IsDir() {
+			serveDirectory(w, req, file, target)
+			return
+		}
+		serveContent(w, req, index, indexInfo)
+		return
+	}
+
+	serveContent(w, req, file, info)
+}
+
+// ServeFile serves a single file from the file system, with the same
+// content type, conditional request and range handling as FileServer
+func ServeFile(w *response.Writer, req *request.Request, name string) {
+	if !allowMethod(w, req) {
+		return
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		writeError(w, response.StatusNotFound)
+		return
+	}
+	serveContent(w, req, file, info)
+}
+
+func allowMethod(w *response.Writer, req *request.Request) bool {
+	method := req.RequestLine.Method
+	if method == "GET" || method == "HEAD" {
+		return true
+	}
+	msg := response.StatusText(response.StatusMethodNotAllowed)
+	header := response.GetDefaultHeader(len(msg))
+	header.Put("Allow", "GET, HEAD")
+	w.WriteStatusLine(response.StatusMethodNotAllowed)
+	w.WriteHeaders(header)
+	w.WriteBody(msg)
+	return false
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode) {
+	w.WriteResponse(statusCode, response.StatusText(statusCode))
+}
+
+func writeOpenError(w *response.Writer, err error) {
+	switch {
+	case os.IsNotExist(err):
+		writeError(w, response.StatusNotFound)
+	case os.IsPermission(err):
+		writeError(w, response.StatusForbidden)
+	default:
+		// os.Root reports paths escaping the root as a plain error
+		writeError(w, response.StatusNotFound)
+	}
+}
+
+type file interface {
+	io.Reader
+	io.Seeker
+}
+
+func serveContent(w *response.Writer, req *request.Request, f file, info fs.FileInfo) {
+	size := info.Size()
+	modTime := info.ModTime().UTC().Truncate(time.Second)
+	etag := fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), size)
+
+	header := headers.NewHeaders()
+	header.Put("Connection", "close")
+	header.Put("ETag", etag)
+	header.Put("Last-Modified", modTime.Format(http.TimeFormat))
+	header.Put("Accept-Ranges", "bytes")
+
+	switch checkPreconditions(req, etag, modTime) {
+	case response.StatusNotModified:
+		w.WriteStatusLine(response.StatusNotModified)
+		w.WriteHeaders(header)
+		w.WriteBody("")
+		return
+	case response.StatusPreconditionFailed:
+		writeError(w, response.StatusPreconditionFailed)
+		return
+	}
+
+	contentType, err := detectContentType(info.Name(), f)
+	if err != nil {
+		writeError(w, response.StatusInternalServerError)
+		return
+	}
+	header.Put("Content-Type", contentType)
+
+	var ranges []byteRange
+	rangeHeader, hasRange := req.Headers.Get("Range")
+	if hasRange && checkIfRange(req, etag, modTime) {
+		ranges, err = parseRange(rangeHeader, size)
+		if err == errUnsatisfiableRange {
+			msg := response.StatusText(response.StatusRangeNotSatisfiable)
+			rangeErrHeader := response.GetDefaultHeader(len(msg))
+			rangeErrHeader.Put("Content-Range", fmt.Sprintf("bytes */%d", size))
+			w.WriteStatusLine(response.StatusRangeNotSatisfiable)
+			w.WriteHeaders(rangeErrHeader)
+			w.WriteBody(msg)
+			return
+		}
+		// an invalid Range header is ignored and the whole file is sent
+	}
+
+	isHead := req.RequestLine.Method == "HEAD"
+	switch {
+	case len(ranges) == 1:
+		r := ranges[0]
+		header.Put("Content-Range", r.contentRange(size))
+		header.Put("Content-Length", strconv.FormatInt(r.length, 10))
+		w.WriteStatusLine(response.StatusPartialContent)
+		w.WriteHeaders(header)
+		if isHead {
+			w.WriteBody("")
+			return
+		}
+		if _, err := f.Seek(r.start, io.SeekStart); err != nil {
+			return
+		}
+		w.CopyBody(io.LimitReader(f, r.length))
+
+	case len(ranges) > 1:
+		boundary := newBoundary()
+		parts := multipartHeaders(ranges, contentType, size, boundary)
+		header.Replace("content-type", "multipart/byteranges; boundary="+boundary)
+		header.Put("Content-Length", strconv.FormatInt(multipartLength(ranges, parts, boundary), 10))
+		w.WriteStatusLine(response.StatusPartialContent)
+		w.WriteHeaders(header)
+		if isHead {
+			w.WriteBody("")
+			return
+		}
+		w.CopyBody(multipartReader(f, ranges, parts, boundary))
+
+	default:
+		header.Put("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteStatusLine(response.StatusOk)
+		w.WriteHeaders(header)
+		if isHead {
+			w.WriteBody("")
+			return
+		}
+		w.CopyBody(f)
+	}
+}
+
+// detectContentType guesses the content type from the file extension and
+// falls back to sniffing the first bytes of the file
+func detectContentType(name string, f file) (string, error) {
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		return contentType, nil
+	}
+
+	buffer := make([]byte, 512)
+	n, err := io.ReadFull(f, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buffer[:n]), nil
+}
+
+func serveDirectory(w *response.Writer, req *request.Request, dir *os.File, target string) {
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		writeError(w, response.StatusInternalServerError)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var listing strings.Builder
+	title := html.EscapeString(target)
+	fmt.Fprintf(&listing, "<html>\n  <head>\n    <title>Index of %s</title>\n  </head>\n  <body>\n    <h1>Index of %s</h1>\n    <ul>\n", title, title)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		link := (&url.URL{Path: name}).String()
+		fmt.Fprintf(&listing, "      <li><a href=\"%s\">%s</a></li>\n", html.EscapeString(link), html.EscapeString(name))
+	}
+	listing.WriteString("    </ul>\n  </body>\n</html>\n")
+
+	body := listing.String()
+	header := response.GetDefaultHeader(len(body))
+	header.Replace("content-type", "text/html; charset=utf-8")
+	w.WriteStatusLine(response.StatusOk)
+	w.WriteHeaders(header)
+	if req.RequestLine.Method == "HEAD" {
+		w.WriteBody("")
+		return
+	}
+	w.WriteBody(body)
+}
@@ -0,0 +1,247 @@
+package fileserver
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const content = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+func setupRoot(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte(content), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "noext"), []byte("<html><body>hi</body></html>"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "site"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "site", "index.html"), []byte("<h1>home</h1>"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "list"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "list", "b <b>.txt"), []byte("b"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "list", "a.txt"), []byte("a"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(dir), "secret.txt"), []byte("secret"), 0o644))
+	return dir
+}
+
+func serve(t *testing.T, handler func(*response.Writer, *request.Request), raw string) (*http.Response, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	writer := response.NewWriter()
+	handler(&writer, req)
+	require.NoError(t, writer.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(writer.ReadBuffer())), &http.Request{Method: req.RequestLine.Method})
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestFileServer(t *testing.T) {
+	fsrv, err := New(setupRoot(t))
+	require.NoError(t, err)
+	defer fsrv.Close()
+
+	// Test: Whole file with guessed content type
+	resp, body := serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, content, body)
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+	assert.NotEmpty(t, resp.Header.Get("ETag"))
+	assert.NotEmpty(t, resp.Header.Get("Last-Modified"))
+
+	// Test: Content type is sniffed without an extension
+	resp, _ = serve(t, fsrv.Handle, "GET /noext HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	// Test: HEAD sends the headers only
+	resp, body = serve(t, fsrv.Handle, "HEAD /file.txt HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, int64(len(content)), resp.ContentLength)
+	assert.Empty(t, body)
+
+	// Test: Missing file
+	resp, _ = serve(t, fsrv.Handle, "GET /missing.txt HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// Test: Unsupported method
+	resp, _ = serve(t, fsrv.Handle, "POST /file.txt HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 405, resp.StatusCode)
+	assert.Equal(t, "GET, HEAD", resp.Header.Get("Allow"))
+}
+
+func TestFileServerPathTraversal(t *testing.T) {
+	fsrv, err := New(setupRoot(t))
+	require.NoError(t, err)
+	defer fsrv.Close()
+
+	for _, target := range []string{"/../secret.txt", "/site/../../secret.txt", "/%2e%2e/secret.txt", "/..%2fsecret.txt"} {
+		resp, body := serve(t, fsrv.Handle, "GET "+target+" HTTP/1.1\r\n\r\n")
+		assert.NotEqual(t, 200, resp.StatusCode, target)
+		assert.NotContains(t, body, "secret", target)
+	}
+
+	// Test: Symlinks cannot leave the root
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(root), "outside.txt"), []byte("outside"), 0o644))
+	require.NoError(t, os.Symlink(filepath.Join(filepath.Dir(root), "outside.txt"), filepath.Join(root, "link.txt")))
+	linked, err := New(root)
+	require.NoError(t, err)
+	defer linked.Close()
+	resp, _ := serve(t, linked.Handle, "GET /link.txt HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 404, resp.StatusCode)
+}
+
+func TestFileServerDirectories(t *testing.T) {
+	fsrv, err := New(setupRoot(t))
+	require.NoError(t, err)
+	defer fsrv.Close()
+
+	// Test: Directory without trailing slash is redirected
+	resp, _ := serve(t, fsrv.Handle, "GET /site HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 301, resp.StatusCode)
+	assert.Equal(t, "/site/", resp.Header.Get("Location"))
+
+	// Test: Index page
+	resp, body := serve(t, fsrv.Handle, "GET /site/ HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "<h1>home</h1>", body)
+
+	// Test: Directory listing is sorted and escaped
+	resp, body = serve(t, fsrv.Handle, "GET /list/ HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Contains(t, body, `<a href="a.txt">a.txt</a>`)
+	assert.Contains(t, body, `<a href="b%20%3Cb%3E.txt">b &lt;b&gt;.txt</a>`)
+	assert.Less(t, strings.Index(body, "a.txt"), strings.Index(body, "b%20"))
+
+	// Test: Prefix is stripped before mapping to the root
+	fsrv.Prefix = "/static"
+	resp, body = serve(t, fsrv.Handle, "GET /static/file.txt HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, content, body)
+}
+
+func TestFileServerRange(t *testing.T) {
+	fsrv, err := New(setupRoot(t))
+	require.NoError(t, err)
+	defer fsrv.Close()
+
+	// Test: Single range
+	resp, body := serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\nRange: bytes=2-5\r\n\r\n")
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "2345", body)
+	assert.Equal(t, "bytes 2-5/36", resp.Header.Get("Content-Range"))
+
+	// Test: Open ended and suffix ranges
+	_, body = serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\nRange: bytes=30-\r\n\r\n")
+	assert.Equal(t, "uvwxyz", body)
+	_, body = serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\nRange: bytes=-3\r\n\r\n")
+	assert.Equal(t, "xyz", body)
+
+	// Test: Multiple ranges
+	resp, body = serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\nRange: bytes=0-1, 10-12\r\n\r\n")
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	reader := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	var parts []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		parts = append(parts, part.Header.Get("Content-Range")+" "+string(data))
+	}
+	assert.Equal(t, []string{"bytes 0-1/36 01", "bytes 10-12/36 abc"}, parts)
+
+	// Test: Unsatisfiable range
+	resp, _ = serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\nRange: bytes=100-200\r\n\r\n")
+	assert.Equal(t, 416, resp.StatusCode)
+	assert.Equal(t, "bytes */36", resp.Header.Get("Content-Range"))
+
+	// Test: Malformed range is ignored
+	resp, body = serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\nRange: lines=1-2\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, content, body)
+
+	// Test: If-Range with the current ETag honors the range, a stale one sends the whole file
+	resp, _ = serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\n\r\n")
+	etag := resp.Header.Get("ETag")
+	resp, body = serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\nRange: bytes=0-0\r\nIf-Range: "+etag+"\r\n\r\n")
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "0", body)
+	resp, body = serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\nRange: bytes=0-0\r\nIf-Range: \"stale\"\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, content, body)
+}
+
+func TestFileServerConditional(t *testing.T) {
+	fsrv, err := New(setupRoot(t))
+	require.NoError(t, err)
+	defer fsrv.Close()
+
+	resp, _ := serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\n\r\n")
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+
+	// Test: If-None-Match
+	resp, body := serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\nIf-None-Match: \"other\", "+etag+"\r\n\r\n")
+	assert.Equal(t, 304, resp.StatusCode)
+	assert.Empty(t, body)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+
+	resp, _ = serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\nIf-None-Match: W/"+etag+"\r\n\r\n")
+	assert.Equal(t, 304, resp.StatusCode)
+
+	resp, _ = serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\nIf-None-Match: \"other\"\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// Test: If-Modified-Since
+	resp, _ = serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\nIf-Modified-Since: "+lastModified+"\r\n\r\n")
+	assert.Equal(t, 304, resp.StatusCode)
+
+	past := time.Now().Add(-48 * time.Hour).UTC().Format(http.TimeFormat)
+	resp, _ = serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\nIf-Modified-Since: "+past+"\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// Test: If-None-Match takes precedence over If-Modified-Since
+	resp, _ = serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\nIf-None-Match: \"other\"\r\nIf-Modified-Since: "+lastModified+"\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// Test: If-Match failure
+	resp, _ = serve(t, fsrv.Handle, "GET /file.txt HTTP/1.1\r\nIf-Match: \"other\"\r\n\r\n")
+	assert.Equal(t, 412, resp.StatusCode)
+}
+
+func TestServeFile(t *testing.T) {
+	dir := setupRoot(t)
+	handler := func(w *response.Writer, req *request.Request) {
+		ServeFile(w, req, filepath.Join(dir, "file.txt"))
+	}
+
+	resp, body := serve(t, handler, "GET /video HTTP/1.1\r\nRange: bytes=10-\r\n\r\n")
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, content[10:], body)
+}
@@ -0,0 +1,238 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+// maxRanges bounds the number of ranges served in one multipart response,
+// requests asking for more get the whole file instead
+const maxRanges = 64
+
+var (
+	errInvalidRange       = errors.New("Invalid Range header")
+	errUnsatisfiableRange = errors.New("Range not satisfiable")
+)
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a bytes Range header value (RFC 9110 section 14.1.2)
+// against a representation of the given size. Ranges that fall outside the
+// representation are dropped, and errUnsatisfiableRange is returned if none
+// is left
+func parseRange(value string, size int64) ([]byteRange, error) {
+	unit, set, found := strings.Cut(value, "=")
+	if !found || strings.TrimSpace(unit) != "bytes" {
+		return nil, errInvalidRange
+	}
+
+	var ranges []byteRange
+	specs := strings.Split(set, ",")
+	if len(specs) > maxRanges {
+		return nil, errInvalidRange
+	}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, errInvalidRange
+		}
+		first = strings.TrimSpace(first)
+		last = strings.TrimSpace(last)
+
+		var r byteRange
+		if first == "" {
+			// suffix range: the last N bytes
+			suffix, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || suffix < 0 {
+				return nil, errInvalidRange
+			}
+			if suffix == 0 || size == 0 {
+				continue
+			}
+			suffix = min(suffix, size)
+			r = byteRange{start: size - suffix, length: suffix}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errInvalidRange
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errInvalidRange
+				}
+				end = min(end, size-1)
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+// checkPreconditions evaluates the conditional request headers in the
+// order of RFC 9110 section 13.2.2. It returns 304 or 412 when the request
+// must not be served normally, and 200 otherwise
+func checkPreconditions(req *request.Request, etag string, modTime time.Time) response.StatusCode {
+	isGetOrHead := req.RequestLine.Method == "GET" || req.RequestLine.Method == "HEAD"
+
+	if ifMatch, isPresent := req.Headers.Get("If-Match"); isPresent {
+		if !matchETag(ifMatch, etag, false) {
+			return response.StatusPreconditionFailed
+		}
+	} else if ifUnmodified, isPresent := req.Headers.Get("If-Unmodified-Since"); isPresent {
+		if t, err := http.ParseTime(ifUnmodified); err == nil && modTime.After(t) {
+			return response.StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch, isPresent := req.Headers.Get("If-None-Match"); isPresent {
+		if matchETag(ifNoneMatch, etag, true) {
+			if isGetOrHead {
+				return response.StatusNotModified
+			}
+			return response.StatusPreconditionFailed
+		}
+	} else if ifModified, isPresent := req.Headers.Get("If-Modified-Since"); isPresent && isGetOrHead {
+		if t, err := http.ParseTime(ifModified); err == nil && !modTime.After(t) {
+			return response.StatusNotModified
+		}
+	}
+
+	return response.StatusOk
+}
+
+// checkIfRange reports whether the Range header should be honored: either
+// there is no If-Range, or it still matches the current representation
+func checkIfRange(req *request.Request, etag string, modTime time.Time) bool {
+	ifRange, isPresent := req.Headers.Get("If-Range")
+	if !isPresent {
+		return true
+	}
+	ifRange = strings.TrimSpace(ifRange)
+	if strings.HasPrefix(ifRange, "\"") || strings.HasPrefix(ifRange, "W/") {
+		return matchETag(ifRange, etag, false)
+	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && modTime.Equal(t)
+}
+
+// matchETag reports whether etag is in the comma separated list of entity
+// tags, using the weak comparison for If-None-Match and the strong one for
+// If-Match and If-Range
+func matchETag(list, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func newBoundary() string {
+	buffer := make([]byte, 16)
+	rand.Read(buffer)
+	return hex.EncodeToString(buffer)
+}
+
+// multipartHeaders returns the delimiter and header block written before
+// each part of a multipart/byteranges body
+func multipartHeaders(ranges []byteRange, contentType string, size int64, boundary string) []string {
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n", boundary, contentType, r.contentRange(size))
+	}
+	return parts
+}
+
+func multipartLength(ranges []byteRange, parts []string, boundary string) int64 {
+	var length int64
+	for i, r := range ranges {
+		length += int64(len(parts[i])) + r.length
+	}
+	return length + int64(len(closingDelimiter(boundary)))
+}
+
+func closingDelimiter(boundary string) string {
+	return "\r\n--" + boundary + "--\r\n"
+}
+
+// multipartReader streams the multipart/byteranges body, reading each range
+// from the file only when it is reached
+func multipartReader(f file, ranges []byteRange, parts []string, boundary string) io.Reader {
+	readers := make([]io.Reader, 0, 2*len(ranges)+1)
+	for i, r := range ranges {
+		readers = append(readers, strings.NewReader(parts[i]), &sectionReader{f: f, start: r.start, remaining: r.length})
+	}
+	readers = append(readers, strings.NewReader(closingDelimiter(boundary)))
+	return io.MultiReader(readers...)
+}
+
+// sectionReader reads a range of a seekable file, seeking to its start on
+// the first read so several sections can share the same file
+type sectionReader struct {
+	f         file
+	start     int64
+	remaining int64
+	seeked    bool
+}
+
+func (s *sectionReader) Read(p []byte) (int, error) {
+	if s.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if !s.seeked {
+		if _, err := s.f.Seek(s.start, io.SeekStart); err != nil {
+			return 0, err
+		}
+		s.seeked = true
+	}
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.f.Read(p)
+	s.remaining -= int64(n)
+	if err == io.EOF && s.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,73 @@
+package headers
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzHeadersParse(f *testing.F) {
+	seeds := []string{
+		"Host: localhost:8080\r\n\r\n",
+		"       Host : localhost:8080       \r\n\r\n",
+		"Host: localhost:8080\r\nUser-Agent: curl/7.81.0\r\n",
+		"X-A: \t spaced \t\r\n",
+		"X-Empty:\r\n",
+		"Set-Cookie: a=1\r\n",
+		"X-Text: caf\xc3\xa9\r\n",
+		"X-A: a\x00b\r\n",
+		"X-A: a\rb\r\n",
+		"H©st: localhost:8080\r\n",
+		"Bad Name: x\r\n",
+		": x\r\n",
+		"NoColon\r\n",
+		"\r\n",
+		"Host: localhost",
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h := NewHeaders()
+		n, done, err := h.Parse(data)
+		if n < 0 || n > len(data) {
+			t.Fatalf("parsed %d bytes of %d", n, len(data))
+		}
+		if err != nil || done || n == 0 {
+			if len(h) > 0 {
+				t.Fatalf("no field line parsed from %q, got %v", data, h)
+			}
+			return
+		}
+		if len(h) != 1 {
+			t.Fatalf("one field line parsed from %q, got %v", data[:n], h)
+		}
+
+		for key, value := range h {
+			if key != strings.ToLower(key) || !IsToken(key) {
+				t.Fatalf("field name %q is not a lowercase token", key)
+			}
+			if !ValidFieldValue([]byte(value)) || value != strings.Trim(value, " \t") {
+				t.Fatalf("field value %q is invalid or not trimmed", value)
+			}
+
+			// the field line written back parses the same
+			line := key + ": " + value + "\r\n"
+			again := NewHeaders()
+			an, adone, err := again.Parse([]byte(line))
+			if err != nil || adone || an != len(line) {
+				t.Fatalf("written field line %q parses %d bytes: %v", line, an, err)
+			}
+			if got, _ := again.Get(key); len(again) != 1 || got != value {
+				t.Fatalf("written field line %q parses as %v", line, again)
+			}
+		}
+
+		// the line parses the same once more data is received
+		more := NewHeaders()
+		mn, _, err := more.Parse(append(data[:n:n], "X-Next: 1\r\n"...))
+		if err != nil || mn != n || len(more) != 1 {
+			t.Fatalf("%q followed by another line parses %d bytes: %v", data[:n], mn, err)
+		}
+	})
+}
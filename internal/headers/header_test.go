@@ -73,4 +73,38 @@ func TestHeaders(t *testing.T) {
 	require.Error(t, err)
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
-}
\ No newline at end of file
+}
+
+func TestValues(t *testing.T) {
+	h := NewHeaders()
+	h.Put("Set-Cookie", "a=1; Expires=Sun, 01 Mar 2026 09:30:00 GMT")
+	h.Put("Set-Cookie", "b=2")
+	h.Put("Cookie", "a=1")
+	h.Put("Cookie", "b=2")
+	h.Put("Accept", "text/html")
+	h.Put("Accept", "*/*")
+
+	assert.Equal(t, []string{"a=1; Expires=Sun, 01 Mar 2026 09:30:00 GMT", "b=2"}, h.Values("set-cookie"))
+	assert.Equal(t, []string{"a=1; b=2"}, h.Values("Cookie"))
+	assert.Equal(t, []string{"text/html, */*"}, h.Values("Accept"))
+	assert.Nil(t, h.Values("Missing"))
+
+	// Test: Set-Cookie lines are separate HPACK fields
+	fields := h.HeaderFields()
+	assert.Equal(t, HeaderField{Name: "set-cookie", Value: "b=2"}, fields[len(fields)-1])
+}
+
+func TestPutEmptyElements(t *testing.T) {
+	h := NewHeaders()
+	h.Put("Accept", "text/html")
+	h.Put("Accept", "")
+	h.Put("X-Empty", "")
+	h.Put("X-Empty", "value")
+	h.Put("Cookie", "a=1")
+	h.Put("Cookie", "")
+
+	// Test: Empty list elements are dropped, so the joined values stay trimmed
+	assert.Equal(t, "text/html", h["accept"])
+	assert.Equal(t, "value", h["x-empty"])
+	assert.Equal(t, "a=1", h["cookie"])
+}
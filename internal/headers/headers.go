@@ -10,6 +10,11 @@ import (
 const CRLF = "\r\n"
 const VALID_HEADER_KEY_SPECIAL_CHARS = "!#$%&'*+-.^_`|~"
 
+// setCookieSeparator joins repeated Set-Cookie values, which cannot be
+// combined with commas since their Expires dates contain one
+// (RFC 9110 section 5.3)
+const setCookieSeparator = "\n"
+
 type Headers map[string]string
 
 func NewHeaders() Headers {
@@ -26,13 +31,39 @@ func (h Headers) Get(key string) (string, bool) {
 func (h Headers) Put(key, value string) {
 	finalKey := strings.ToLower(key)
 	_, keyExists := h[finalKey]
-	if keyExists {
+	if keyExists && finalKey == "set-cookie" {
+		h[finalKey] = h[finalKey] + setCookieSeparator + value
+	} else if keyExists && (value == "" || h[finalKey] == "") {
+		// empty list elements are ignored (RFC 9110 section 5.6.1)
+		h[finalKey] = h[finalKey] + value
+	} else if keyExists && finalKey == "cookie" {
+		// cookie pairs are separated by semicolons (RFC 6265 section 5.4)
+		h[finalKey] = h[finalKey] + "; " + value
+	} else if keyExists {
 		h[finalKey] = h[finalKey] + ", " + value
 	} else {
 		h[finalKey] = value
 	}
 }
 
+// Values returns the values of a field that must be written on separate
+// lines, which is only the case for Set-Cookie. Other fields have a single
+// comma-joined value
+func (h Headers) Values(key string) []string {
+	// keys set with Replace keep their case
+	value, isPresent := h[key]
+	if !isPresent {
+		value, isPresent = h[strings.ToLower(key)]
+	}
+	if !isPresent {
+		return nil
+	}
+	if strings.EqualFold(key, "set-cookie") {
+		return strings.Split(value, setCookieSeparator)
+	}
+	return []string{value}
+}
+
 func (h Headers) Replace(key, value string) {
 	h[key] = value
 }
@@ -42,6 +73,14 @@ func (h Headers) Remove(key string) {
 	delete(h, key)
 }
 
+func (h Headers) Clone() Headers {
+	clone := make(Headers, len(h))
+	for key, value := range h {
+		clone[key] = value
+	}
+	return clone
+}
+
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	n = 0
 	done = false
@@ -66,7 +105,8 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	}
 
 	key := bytes.TrimLeftFunc(headerArray[0], unicode.IsSpace)
-	value := bytes.TrimFunc(headerArray[1], unicode.IsSpace) 
+	// only SP and HTAB surround a field value (RFC 9110 section 5.5)
+	value := bytes.Trim(headerArray[1], " \t")
 
 	if len(key) == 0 {
 		err = fmt.Errorf("Header Key is of 0 length")
@@ -84,12 +124,38 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		return
 	}
 
+	if !ValidFieldValue(value) {
+		err = fmt.Errorf("Header Value contains a control character")
+		return
+	}
+
 	h.Put(string(key), string(value))
 	
 	n = len(header) + len(CRLF)
 	return
 }
 
+// isInvalidHeaderKeyRune rejects runes outside the tchar set of field names,
+// which is ASCII only (RFC 9110 section 5.6.2)
 func isInvalidHeaderKeyRune(r rune) bool {
-	return !unicode.IsLetter(r) && !unicode.IsNumber(r) && !strings.ContainsRune(VALID_HEADER_KEY_SPECIAL_CHARS, r)
+	isAlnum := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+	return !isAlnum && !strings.ContainsRune(VALID_HEADER_KEY_SPECIAL_CHARS, r)
+}
+
+// IsToken reports whether s is a token, the syntax of field names and
+// methods among others
+func IsToken(s string) bool {
+	return s != "" && !strings.ContainsFunc(s, isInvalidHeaderKeyRune)
+}
+
+// ValidFieldValue reports whether value only has visible characters, SP,
+// HTAB and obs-text. CR, LF and NUL must be rejected (RFC 9110 section 5.5),
+// so are the other control characters
+func ValidFieldValue(value []byte) bool {
+	for _, c := range value {
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
 }
\ No newline at end of file
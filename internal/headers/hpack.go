@@ -0,0 +1,404 @@
+package headers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// entryOverhead is added to the length of name and value to compute the size
+// of a table entry (RFC 7541 section 4.1)
+const entryOverhead = 32
+
+// DefaultHeaderTableSize is the initial size of the HPACK dynamic table
+const DefaultHeaderTableSize = 4096
+
+var (
+	ErrInvalidHeaderBlock = errors.New("Invalid HPACK header block")
+	ErrInvalidHuffman     = errors.New("Invalid HPACK Huffman string")
+)
+
+// HeaderField is a single field of an HPACK header list. Names are lower
+// case. Sensitive fields are never added to a dynamic table, by this encoder
+// or by any intermediary (RFC 7541 section 7.1.3)
+type HeaderField struct {
+	Name      string
+	Value     string
+	Sensitive bool
+}
+
+func (f HeaderField) size() uint32 {
+	return uint32(len(f.Name) + len(f.Value) + entryOverhead)
+}
+
+// HeaderFields converts the headers into an HPACK header list sorted by
+// name. Repeated fields were already joined by Put, so every name appears
+// once except Set-Cookie
+func (h Headers) HeaderFields() []HeaderField {
+	fields := make([]HeaderField, 0, len(h))
+	for name := range h {
+		for _, value := range h.Values(name) {
+			fields = append(fields, HeaderField{Name: strings.ToLower(name), Value: value})
+		}
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+	return fields
+}
+
+// FromHeaderFields converts an HPACK header list into headers, joining
+// repeated fields like Put
+func FromHeaderFields(fields []HeaderField) Headers {
+	h := NewHeaders()
+	for _, field := range fields {
+		h.Put(field.Name, field.Value)
+	}
+	return h
+}
+
+// dynamicTable holds the fields added to the table, the most recent one last
+type dynamicTable struct {
+	entries []HeaderField
+	size    uint32
+	maxSize uint32
+}
+
+func (t *dynamicTable) add(field HeaderField) {
+	field.Sensitive = false
+	t.entries = append(t.entries, field)
+	t.size += field.size()
+	t.evict()
+}
+
+func (t *dynamicTable) setMaxSize(maxSize uint32) {
+	t.maxSize = maxSize
+	t.evict()
+}
+
+func (t *dynamicTable) evict() {
+	n := 0
+	for t.size > t.maxSize && n < len(t.entries) {
+		t.size -= t.entries[n].size()
+		n++
+	}
+	if n > 0 {
+		t.entries = append(t.entries[:0], t.entries[n:]...)
+	}
+}
+
+// lookup returns the field at the combined static and dynamic index
+func (t *dynamicTable) lookup(index uint64) (HeaderField, bool) {
+	if index == 0 {
+		return HeaderField{}, false
+	}
+	if index <= uint64(len(staticTable)) {
+		return staticTable[index-1], true
+	}
+	index -= uint64(len(staticTable))
+	if index > uint64(len(t.entries)) {
+		return HeaderField{}, false
+	}
+	return t.entries[len(t.entries)-int(index)], true
+}
+
+// search returns the index of an entry matching the field, preferring a
+// full match over a name match and the static table over the dynamic one
+func (t *dynamicTable) search(field HeaderField) (index uint64, nameMatch bool) {
+	for i, entry := range staticTable {
+		if entry.Name == field.Name && entry.Value == field.Value {
+			return uint64(i + 1), false
+		}
+	}
+	for i := len(t.entries) - 1; i >= 0; i-- {
+		entry := t.entries[i]
+		if entry.Name == field.Name && entry.Value == field.Value {
+			return uint64(len(staticTable) + len(t.entries) - i), false
+		}
+	}
+	for i, entry := range staticTable {
+		if entry.Name == field.Name {
+			return uint64(i + 1), true
+		}
+	}
+	for i := len(t.entries) - 1; i >= 0; i-- {
+		if t.entries[i].Name == field.Name {
+			return uint64(len(staticTable) + len(t.entries) - i), true
+		}
+	}
+	return 0, false
+}
+
+// Decoder decompresses the header blocks of one connection. It keeps the
+// dynamic table between blocks, so blocks must be decoded in order
+type Decoder struct {
+	table dynamicTable
+	// maxTableSize is the limit the peer's table size updates must respect,
+	// announced with SETTINGS_HEADER_TABLE_SIZE in HTTP/2
+	maxTableSize uint32
+}
+
+func NewDecoder(maxTableSize uint32) *Decoder {
+	return &Decoder{
+		table:        dynamicTable{maxSize: maxTableSize},
+		maxTableSize: maxTableSize,
+	}
+}
+
+// SetMaxDynamicTableSize changes the limit of the dynamic table size. The
+// table shrinks right away when it is larger than the new limit
+func (d *Decoder) SetMaxDynamicTableSize(maxTableSize uint32) {
+	d.maxTableSize = maxTableSize
+	if d.table.maxSize > maxTableSize {
+		d.table.setMaxSize(maxTableSize)
+	}
+}
+
+// DynamicTableSize returns the current size of the dynamic table
+func (d *Decoder) DynamicTableSize() uint32 {
+	return d.table.size
+}
+
+// Decode decodes a complete header block
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	var fields []HeaderField
+	fieldSeen := false
+	for len(block) > 0 {
+		b := block[0]
+		switch {
+		case b&0x80 != 0:
+			// indexed header field
+			index, n, err := decodeInteger(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			field, found := d.table.lookup(index)
+			if !found {
+				return nil, fmt.Errorf("%w: index %d out of range", ErrInvalidHeaderBlock, index)
+			}
+			fields = append(fields, field)
+			block = block[n:]
+
+		case b&0xC0 == 0x40:
+			// literal with incremental indexing
+			field, n, err := d.decodeLiteral(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			d.table.add(field)
+			fields = append(fields, field)
+			block = block[n:]
+
+		case b&0xE0 == 0x20:
+			// dynamic table size update, only allowed before the first field
+			if fieldSeen {
+				return nil, fmt.Errorf("%w: table size update after a field", ErrInvalidHeaderBlock)
+			}
+			size, n, err := decodeInteger(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			if size > uint64(d.maxTableSize) {
+				return nil, fmt.Errorf("%w: table size %d exceeds the limit", ErrInvalidHeaderBlock, size)
+			}
+			d.table.setMaxSize(uint32(size))
+			block = block[n:]
+			continue
+
+		default:
+			// literal without indexing, or never indexed when 0x10 is set
+			field, n, err := d.decodeLiteral(block, 4)
+			if err != nil {
+				return nil, err
+			}
+			field.Sensitive = b&0x10 != 0
+			fields = append(fields, field)
+			block = block[n:]
+		}
+		fieldSeen = true
+	}
+	return fields, nil
+}
+
+// decodeLiteral decodes a literal field whose name index uses prefixBits
+func (d *Decoder) decodeLiteral(block []byte, prefixBits uint8) (HeaderField, int, error) {
+	index, n, err := decodeInteger(block, prefixBits)
+	if err != nil {
+		return HeaderField{}, 0, err
+	}
+
+	var field HeaderField
+	if index > 0 {
+		indexed, found := d.table.lookup(index)
+		if !found {
+			return HeaderField{}, 0, fmt.Errorf("%w: index %d out of range", ErrInvalidHeaderBlock, index)
+		}
+		field.Name = indexed.Name
+	} else {
+		name, nameLen, err := decodeString(block[n:])
+		if err != nil {
+			return HeaderField{}, 0, err
+		}
+		field.Name = name
+		n += nameLen
+	}
+
+	value, valueLen, err := decodeString(block[n:])
+	if err != nil {
+		return HeaderField{}, 0, err
+	}
+	field.Value = value
+	return field, n + valueLen, nil
+}
+
+// Encoder compresses the header blocks of one connection. Fields found in
+// the tables are sent as indexes, the others are added to the dynamic table
+// unless they are sensitive
+type Encoder struct {
+	table dynamicTable
+	// Huffman codes string literals when that does not make them longer
+	Huffman bool
+
+	// minSize is the smallest size the table had since the last block and
+	// sizeChanged whether a table size update must start the next block
+	minSize     uint32
+	sizeChanged bool
+}
+
+func NewEncoder(maxTableSize uint32) *Encoder {
+	return &Encoder{
+		table:   dynamicTable{maxSize: maxTableSize},
+		Huffman: true,
+	}
+}
+
+// SetMaxDynamicTableSize changes the size of the dynamic table, which is
+// signaled to the decoder at the start of the next header block
+func (e *Encoder) SetMaxDynamicTableSize(maxTableSize uint32) {
+	if !e.sizeChanged || maxTableSize < e.minSize {
+		e.minSize = maxTableSize
+	}
+	e.sizeChanged = true
+	e.table.setMaxSize(maxTableSize)
+}
+
+// DynamicTableSize returns the current size of the dynamic table
+func (e *Encoder) DynamicTableSize() uint32 {
+	return e.table.size
+}
+
+// Encode encodes a complete header block
+func (e *Encoder) Encode(fields []HeaderField) []byte {
+	var block []byte
+	if e.sizeChanged {
+		// a shrink followed by a growth needs both updates so the
+		// decoder evicts the same entries (RFC 7541 section 4.2)
+		if e.minSize < e.table.maxSize {
+			block = appendInteger(block, 0x20, 5, uint64(e.minSize))
+		}
+		block = appendInteger(block, 0x20, 5, uint64(e.table.maxSize))
+		e.sizeChanged = false
+	}
+
+	for _, field := range fields {
+		index, nameMatch := e.table.search(field)
+		if index > 0 && !nameMatch && !field.Sensitive {
+			block = appendInteger(block, 0x80, 7, index)
+			continue
+		}
+		if !nameMatch {
+			index = 0
+		}
+
+		if field.Sensitive {
+			block = appendInteger(block, 0x10, 4, index)
+		} else {
+			block = appendInteger(block, 0x40, 6, index)
+			e.table.add(field)
+		}
+		if index == 0 {
+			block = e.appendString(block, field.Name)
+		}
+		block = e.appendString(block, field.Value)
+	}
+	return block
+}
+
+func (e *Encoder) appendString(dst []byte, s string) []byte {
+	if e.Huffman && HuffmanEncodedLen(s) <= len(s) {
+		dst = appendInteger(dst, 0x80, 7, uint64(HuffmanEncodedLen(s)))
+		return AppendHuffman(dst, s)
+	}
+	dst = appendInteger(dst, 0x00, 7, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// decodeInteger decodes an integer with an N-bit prefix (RFC 7541 section
+// 5.1) and returns it with the number of bytes it used
+func decodeInteger(data []byte, prefixBits uint8) (uint64, int, error) {
+	if len(data) == 0 {
+		return 0, 0, fmt.Errorf("%w: truncated integer", ErrInvalidHeaderBlock)
+	}
+	mask := uint64(1)<<prefixBits - 1
+	value := uint64(data[0]) & mask
+	if value < mask {
+		return value, 1, nil
+	}
+
+	var shift uint
+	for i := 1; i < len(data); i++ {
+		b := data[i]
+		value += uint64(b&0x7F) << shift
+		if b&0x80 == 0 {
+			return value, i + 1, nil
+		}
+		shift += 7
+		// more than 32 bits is never needed and risks an overflow
+		if shift > 28 {
+			return 0, 0, fmt.Errorf("%w: integer too large", ErrInvalidHeaderBlock)
+		}
+	}
+	return 0, 0, fmt.Errorf("%w: truncated integer", ErrInvalidHeaderBlock)
+}
+
+// appendInteger appends value with an N-bit prefix, first holding the bits
+// that precede the prefix
+func appendInteger(dst []byte, first byte, prefixBits uint8, value uint64) []byte {
+	mask := uint64(1)<<prefixBits - 1
+	if value < mask {
+		return append(dst, first|byte(value))
+	}
+	dst = append(dst, first|byte(mask))
+	value -= mask
+	for value >= 0x80 {
+		dst = append(dst, byte(value&0x7F)|0x80)
+		value >>= 7
+	}
+	return append(dst, byte(value))
+}
+
+// decodeString decodes a string literal (RFC 7541 section 5.2) and returns it
+// with the number of bytes it used
+func decodeString(data []byte) (string, int, error) {
+	if len(data) == 0 {
+		return "", 0, fmt.Errorf("%w: truncated string", ErrInvalidHeaderBlock)
+	}
+	huffman := data[0]&0x80 != 0
+	length, n, err := decodeInteger(data, 7)
+	if err != nil {
+		return "", 0, err
+	}
+	if uint64(len(data)-n) < length {
+		return "", 0, fmt.Errorf("%w: truncated string", ErrInvalidHeaderBlock)
+	}
+	raw := data[n : n+int(length)]
+	if !huffman {
+		return string(raw), n + int(length), nil
+	}
+	decoded, err := HuffmanDecode(raw)
+	if err != nil {
+		return "", 0, err
+	}
+	return decoded, n + int(length), nil
+}
@@ -0,0 +1,280 @@
+package headers
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// example vectors from RFC 7541 Appendix C
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	require.NoError(t, err)
+	return data
+}
+
+type hpackExample struct {
+	block  string
+	fields []HeaderField
+	size   uint32
+}
+
+var requestFields = [][]HeaderField{
+	{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/"},
+		{Name: ":authority", Value: "www.example.com"},
+	},
+	{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/"},
+		{Name: ":authority", Value: "www.example.com"},
+		{Name: "cache-control", Value: "no-cache"},
+	},
+	{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "https"},
+		{Name: ":path", Value: "/index.html"},
+		{Name: ":authority", Value: "www.example.com"},
+		{Name: "custom-key", Value: "custom-value"},
+	},
+}
+
+var responseFields = [][]HeaderField{
+	{
+		{Name: ":status", Value: "302"},
+		{Name: "cache-control", Value: "private"},
+		{Name: "date", Value: "Mon, 21 Oct 2013 20:13:21 GMT"},
+		{Name: "location", Value: "https://www.example.com"},
+	},
+	{
+		{Name: ":status", Value: "307"},
+		{Name: "cache-control", Value: "private"},
+		{Name: "date", Value: "Mon, 21 Oct 2013 20:13:21 GMT"},
+		{Name: "location", Value: "https://www.example.com"},
+	},
+	{
+		{Name: ":status", Value: "200"},
+		{Name: "cache-control", Value: "private"},
+		{Name: "date", Value: "Mon, 21 Oct 2013 20:13:22 GMT"},
+		{Name: "location", Value: "https://www.example.com"},
+		{Name: "content-encoding", Value: "gzip"},
+		{Name: "set-cookie", Value: "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"},
+	},
+}
+
+func TestHPACKExamples(t *testing.T) {
+	cases := []struct {
+		name      string
+		tableSize uint32
+		huffman   bool
+		examples  []hpackExample
+	}{
+		{
+			name:      "C.3 requests without Huffman coding",
+			tableSize: 4096,
+			examples: []hpackExample{
+				{"8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d", requestFields[0], 57},
+				{"8286 84be 5808 6e6f 2d63 6163 6865", requestFields[1], 110},
+				{"8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65", requestFields[2], 164},
+			},
+		},
+		{
+			name:      "C.4 requests with Huffman coding",
+			tableSize: 4096,
+			huffman:   true,
+			examples: []hpackExample{
+				{"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff", requestFields[0], 57},
+				{"8286 84be 5886 a8eb 1064 9cbf", requestFields[1], 110},
+				{"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf", requestFields[2], 164},
+			},
+		},
+		{
+			name:      "C.5 responses without Huffman coding",
+			tableSize: 256,
+			examples: []hpackExample{
+				{"4803 3330 3258 0770 7269 7661 7465 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3120 474d 546e 1768 7474 7073 3a2f 2f77 7777 2e65 7861 6d70 6c65 2e63 6f6d", responseFields[0], 222},
+				{"4803 3330 37c1 c0bf", responseFields[1], 222},
+				{"88c1 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3220 474d 54c0 5a04 677a 6970 7738 666f 6f3d 4153 444a 4b48 514b 425a 584f 5157 454f 5049 5541 5851 5745 4f49 553b 206d 6178 2d61 6765 3d33 3630 303b 2076 6572 7369 6f6e 3d31", responseFields[2], 215},
+			},
+		},
+		{
+			name:      "C.6 responses with Huffman coding",
+			tableSize: 256,
+			huffman:   true,
+			examples: []hpackExample{
+				{"4882 6402 5885 aec3 771a 4b61 96d0 7abe 9410 54d4 44a8 2005 9504 0b81 66e0 82a6 2d1b ff6e 919d 29ad 1718 63c7 8f0b 97c8 e9ae 82ae 43d3", responseFields[0], 222},
+				{"4883 640e ffc1 c0bf", responseFields[1], 222},
+				{"88c1 6196 d07a be94 1054 d444 a820 0595 040b 8166 e084 a62d 1bff c05a 839b d9ab 77ad 94e7 821d d7f2 e6c7 b335 dfdf cd5b 3960 d5af 2708 7f36 72c1 ab27 0fb5 291f 9587 3160 65c0 03ed 4ee5 b106 3d50 07", responseFields[2], 215},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		decoder := NewDecoder(c.tableSize)
+		encoder := NewEncoder(c.tableSize)
+		encoder.Huffman = c.huffman
+		for i, example := range c.examples {
+			block := unhex(t, example.block)
+
+			fields, err := decoder.Decode(block)
+			require.NoError(t, err, c.name, i)
+			assert.Equal(t, example.fields, fields, c.name, i)
+			assert.Equal(t, example.size, decoder.DynamicTableSize(), c.name, i)
+
+			assert.Equal(t, block, encoder.Encode(example.fields), c.name, i)
+			assert.Equal(t, example.size, encoder.DynamicTableSize(), c.name, i)
+		}
+	}
+}
+
+func TestHPACKLiterals(t *testing.T) {
+	// Test: C.2.1 literal with indexing
+	decoder := NewDecoder(DefaultHeaderTableSize)
+	fields, err := decoder.Decode(unhex(t, "400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572"))
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{{Name: "custom-key", Value: "custom-header"}}, fields)
+	assert.Equal(t, uint32(55), decoder.DynamicTableSize())
+
+	// Test: C.2.2 literal without indexing
+	decoder = NewDecoder(DefaultHeaderTableSize)
+	fields, err = decoder.Decode(unhex(t, "040c 2f73 616d 706c 652f 7061 7468"))
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{{Name: ":path", Value: "/sample/path"}}, fields)
+	assert.Zero(t, decoder.DynamicTableSize())
+
+	// Test: C.2.3 never indexed literal, produced for sensitive fields
+	sensitive := unhex(t, "1008 7061 7373 776f 7264 0673 6563 7265 74")
+	fields, err = decoder.Decode(sensitive)
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{{Name: "password", Value: "secret", Sensitive: true}}, fields)
+	assert.Zero(t, decoder.DynamicTableSize())
+	encoder := NewEncoder(DefaultHeaderTableSize)
+	encoder.Huffman = false
+	assert.Equal(t, sensitive, encoder.Encode(fields))
+	assert.Zero(t, encoder.DynamicTableSize())
+
+	// Test: C.2.4 indexed field
+	fields, err = decoder.Decode([]byte{0x82})
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{{Name: ":method", Value: "GET"}}, fields)
+}
+
+func TestHPACKIntegers(t *testing.T) {
+	cases := []struct {
+		value      uint64
+		prefixBits uint8
+		encoded    []byte
+	}{
+		// C.1.1 to C.1.3
+		{value: 10, prefixBits: 5, encoded: []byte{0x0a}},
+		{value: 1337, prefixBits: 5, encoded: []byte{0x1f, 0x9a, 0x0a}},
+		{value: 42, prefixBits: 8, encoded: []byte{0x2a}},
+		{value: 31, prefixBits: 5, encoded: []byte{0x1f, 0x00}},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.encoded, appendInteger(nil, 0, c.prefixBits, c.value))
+		value, n, err := decodeInteger(c.encoded, c.prefixBits)
+		require.NoError(t, err)
+		assert.Equal(t, c.value, value)
+		assert.Equal(t, len(c.encoded), n)
+	}
+
+	// Test: Truncated integer
+	_, _, err := decodeInteger([]byte{0x1f, 0x9a}, 5)
+	assert.ErrorIs(t, err, ErrInvalidHeaderBlock)
+
+	// Test: Integer overflow
+	_, _, err = decodeInteger([]byte{0x1f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, 5)
+	assert.ErrorIs(t, err, ErrInvalidHeaderBlock)
+}
+
+func TestHuffman(t *testing.T) {
+	// Test: Round trip of every byte value
+	var all []byte
+	for i := range 256 {
+		all = append(all, byte(i))
+	}
+	encoded := AppendHuffman(nil, string(all))
+	assert.Len(t, encoded, HuffmanEncodedLen(string(all)))
+	decoded, err := HuffmanDecode(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, string(all), decoded)
+
+	// Test: "a" is 00011, padded with ones
+	assert.Equal(t, []byte{0x1F}, AppendHuffman(nil, "a"))
+
+	// Test: Padding with a zero bit
+	_, err = HuffmanDecode([]byte{0x1E})
+	assert.ErrorIs(t, err, ErrInvalidHuffman)
+
+	// Test: Padding longer than 7 bits
+	_, err = HuffmanDecode([]byte{0x1F, 0xFF})
+	assert.ErrorIs(t, err, ErrInvalidHuffman)
+
+	// Test: EOS inside the string
+	_, err = HuffmanDecode([]byte{0xFF, 0xFF, 0xFF, 0xFF})
+	assert.ErrorIs(t, err, ErrInvalidHuffman)
+}
+
+func TestHPACKTableSizeUpdate(t *testing.T) {
+	encoder := NewEncoder(DefaultHeaderTableSize)
+	decoder := NewDecoder(DefaultHeaderTableSize)
+	fields := []HeaderField{{Name: "custom-key", Value: "custom-value"}}
+
+	_, err := decoder.Decode(encoder.Encode(fields))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(54), decoder.DynamicTableSize())
+
+	// Test: Shrinking then growing the table signals both sizes
+	encoder.SetMaxDynamicTableSize(0)
+	encoder.SetMaxDynamicTableSize(1024)
+	block := encoder.Encode(fields)
+	assert.Equal(t, []byte{0x20, 0x3f, 0xe1, 0x07}, block[:4])
+	decoded, err := decoder.Decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, fields, decoded)
+	// the entry was evicted by the zero size and added again
+	assert.Equal(t, uint32(54), decoder.DynamicTableSize())
+
+	// Test: Size update above the decoder's limit
+	_, err = NewDecoder(100).Decode([]byte{0x3f, 0xe1, 0x1f})
+	assert.ErrorIs(t, err, ErrInvalidHeaderBlock)
+
+	// Test: Size update after a field
+	_, err = NewDecoder(DefaultHeaderTableSize).Decode([]byte{0x82, 0x20})
+	assert.ErrorIs(t, err, ErrInvalidHeaderBlock)
+
+	// Test: Index past the end of the tables
+	_, err = NewDecoder(DefaultHeaderTableSize).Decode([]byte{0xff, 0x00})
+	assert.ErrorIs(t, err, ErrInvalidHeaderBlock)
+}
+
+func TestHeaderFieldsConversion(t *testing.T) {
+	h := NewHeaders()
+	h.Put("Content-Type", "text/plain")
+	h.Put("Accept", "text/html")
+	h.Put("Accept", "*/*")
+
+	fields := h.HeaderFields()
+	assert.Equal(t, []HeaderField{
+		{Name: "accept", Value: "text/html, */*"},
+		{Name: "content-type", Value: "text/plain"},
+	}, fields)
+
+	decoded, err := NewDecoder(DefaultHeaderTableSize).Decode(NewEncoder(DefaultHeaderTableSize).Encode(fields))
+	require.NoError(t, err)
+	assert.Equal(t, h, FromHeaderFields(decoded))
+
+	// Test: Repeated fields are joined
+	joined := FromHeaderFields([]HeaderField{{Name: "x-a", Value: "1"}, {Name: "x-a", Value: "2"}})
+	value, _ := joined.Get("X-A")
+	assert.Equal(t, "1, 2", value)
+}
@@ -0,0 +1,108 @@
+package headers
+
+import "sync"
+
+// eosSymbol is the index of the end-of-string symbol in huffmanCodes
+const eosSymbol = 256
+
+type huffmanNode struct {
+	children [2]*huffmanNode
+	// symbol is only meaningful on leaves
+	symbol int
+}
+
+func (n *huffmanNode) isLeaf() bool {
+	return n.children[0] == nil && n.children[1] == nil
+}
+
+var (
+	huffmanTreeOnce sync.Once
+	huffmanTree     *huffmanNode
+)
+
+// huffmanRoot returns the decoding tree, built on first use
+func huffmanRoot() *huffmanNode {
+	huffmanTreeOnce.Do(func() {
+		huffmanTree = &huffmanNode{}
+		for symbol, entry := range huffmanCodes {
+			node := huffmanTree
+			for i := int(entry.length) - 1; i >= 0; i-- {
+				bit := (entry.code >> uint(i)) & 1
+				if node.children[bit] == nil {
+					node.children[bit] = &huffmanNode{}
+				}
+				node = node.children[bit]
+			}
+			node.symbol = symbol
+		}
+	})
+	return huffmanTree
+}
+
+// HuffmanEncodedLen returns the length of s once Huffman coded
+func HuffmanEncodedLen(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodes[s[i]].length)
+	}
+	return (bits + 7) / 8
+}
+
+// AppendHuffman appends the Huffman coding of s to dst, padded with the most
+// significant bits of EOS
+func AppendHuffman(dst []byte, s string) []byte {
+	var pending uint64
+	pendingBits := 0
+	for i := 0; i < len(s); i++ {
+		entry := huffmanCodes[s[i]]
+		pending = pending<<entry.length | uint64(entry.code)
+		pendingBits += int(entry.length)
+		for pendingBits >= 8 {
+			pendingBits -= 8
+			dst = append(dst, byte(pending>>uint(pendingBits)))
+		}
+	}
+	if pendingBits > 0 {
+		padding := 8 - pendingBits
+		dst = append(dst, byte(pending<<uint(padding))|byte(1<<uint(padding)-1))
+	}
+	return dst
+}
+
+// HuffmanDecode decodes a Huffman coded string. The padding must be shorter
+// than a byte and made of the most significant bits of EOS, which are all
+// ones (RFC 7541 section 5.2)
+func HuffmanDecode(data []byte) (string, error) {
+	root := huffmanRoot()
+	decoded := make([]byte, 0, len(data)*8/5)
+	node := root
+	// pending counts the bits read since the last symbol and allOnes
+	// whether they could be padding
+	pending := 0
+	allOnes := true
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> uint(i)) & 1
+			node = node.children[bit]
+			if node == nil {
+				return "", ErrInvalidHuffman
+			}
+			pending++
+			allOnes = allOnes && bit == 1
+			if !node.isLeaf() {
+				continue
+			}
+			if node.symbol == eosSymbol {
+				return "", ErrInvalidHuffman
+			}
+			decoded = append(decoded, byte(node.symbol))
+			node = root
+			pending = 0
+			allOnes = true
+		}
+	}
+	if pending > 7 || !allOnes {
+		return "", ErrInvalidHuffman
+	}
+	return string(decoded), nil
+}
@@ -0,0 +1,203 @@
+package http2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type FrameType uint8
+
+const (
+	FrameData         FrameType = 0x0
+	FrameHeaders      FrameType = 0x1
+	FramePriority     FrameType = 0x2
+	FrameRSTStream    FrameType = 0x3
+	FrameSettings     FrameType = 0x4
+	FramePushPromise  FrameType = 0x5
+	FramePing         FrameType = 0x6
+	FrameGoAway       FrameType = 0x7
+	FrameWindowUpdate FrameType = 0x8
+	FrameContinuation FrameType = 0x9
+)
+
+// Frame flags. A flag's meaning depends on the frame type it is set on
+const (
+	FlagEndStream  uint8 = 0x1
+	FlagAck        uint8 = 0x1
+	FlagEndHeaders uint8 = 0x4
+	FlagPadded     uint8 = 0x8
+	FlagPriority   uint8 = 0x20
+)
+
+type ErrCode uint32
+
+// Error codes from RFC 9113 section 7
+const (
+	ErrCodeNo                 ErrCode = 0x0
+	ErrCodeProtocol           ErrCode = 0x1
+	ErrCodeInternal           ErrCode = 0x2
+	ErrCodeFlowControl        ErrCode = 0x3
+	ErrCodeSettingsTimeout    ErrCode = 0x4
+	ErrCodeStreamClosed       ErrCode = 0x5
+	ErrCodeFrameSize          ErrCode = 0x6
+	ErrCodeRefusedStream      ErrCode = 0x7
+	ErrCodeCancel             ErrCode = 0x8
+	ErrCodeCompression        ErrCode = 0x9
+	ErrCodeConnect            ErrCode = 0xA
+	ErrCodeEnhanceYourCalm    ErrCode = 0xB
+	ErrCodeInadequateSecurity ErrCode = 0xC
+	ErrCodeHTTP11Required     ErrCode = 0xD
+)
+
+type SettingID uint16
+
+const (
+	SettingHeaderTableSize      SettingID = 0x1
+	SettingEnablePush           SettingID = 0x2
+	SettingMaxConcurrentStreams SettingID = 0x3
+	SettingInitialWindowSize    SettingID = 0x4
+	SettingMaxFrameSize         SettingID = 0x5
+	SettingMaxHeaderListSize    SettingID = 0x6
+)
+
+const (
+	frameHeaderLen = 9
+	// DefaultMaxFrameSize is the largest frame payload every endpoint must
+	// accept
+	DefaultMaxFrameSize = 16384
+	maxAllowedFrameSize = 1<<24 - 1
+	// DefaultWindowSize is the initial flow-control window of the
+	// connection and of new streams
+	DefaultWindowSize = 65535
+	maxWindowSize     = 1<<31 - 1
+)
+
+var ErrFrameTooLarge = errors.New("HTTP/2 frame is too large")
+
+// Frame is a single HTTP/2 frame (RFC 9113 section 4.1). Padding is kept in
+// the payload, see dataPayload and headerBlockFragment
+type Frame struct {
+	Type     FrameType
+	Flags    uint8
+	StreamID uint32
+	Payload  []byte
+}
+
+func (f Frame) Has(flag uint8) bool {
+	return f.Flags&flag != 0
+}
+
+// ReadFrame reads one frame from r. Frames with a payload longer than
+// maxPayload are rejected with ErrFrameTooLarge before the payload is read
+func ReadFrame(r io.Reader, maxPayload uint32) (Frame, error) {
+	var frame Frame
+	var head [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return frame, err
+	}
+
+	length := uint32(head[0])<<16 | uint32(head[1])<<8 | uint32(head[2])
+	frame.Type = FrameType(head[3])
+	frame.Flags = head[4]
+	// the most significant bit is reserved and ignored
+	frame.StreamID = binary.BigEndian.Uint32(head[5:]) & 0x7FFFFFFF
+	if length > maxPayload {
+		return frame, ErrFrameTooLarge
+	}
+
+	frame.Payload = make([]byte, length)
+	if _, err := io.ReadFull(r, frame.Payload); err != nil {
+		return frame, err
+	}
+	return frame, nil
+}
+
+func WriteFrame(w io.Writer, frame Frame) error {
+	length := len(frame.Payload)
+	if length > maxAllowedFrameSize {
+		return ErrFrameTooLarge
+	}
+	head := [frameHeaderLen]byte{byte(length >> 16), byte(length >> 8), byte(length), byte(frame.Type), frame.Flags}
+	binary.BigEndian.PutUint32(head[5:], frame.StreamID&0x7FFFFFFF)
+	if _, err := w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(frame.Payload)
+	return err
+}
+
+// stripPadding removes the pad length byte and the padding of a PADDED frame
+func stripPadding(frame Frame) ([]byte, error) {
+	payload := frame.Payload
+	if !frame.Has(FlagPadded) {
+		return payload, nil
+	}
+	if len(payload) < 1 {
+		return nil, fmt.Errorf("Padded frame without pad length")
+	}
+	padLength := int(payload[0])
+	if padLength >= len(payload) {
+		return nil, fmt.Errorf("Padding exceeds the frame payload")
+	}
+	return payload[1 : len(payload)-padLength], nil
+}
+
+// headerBlockFragment returns the header block fragment of a HEADERS frame,
+// without padding and priority fields
+func headerBlockFragment(frame Frame) ([]byte, error) {
+	payload, err := stripPadding(frame)
+	if err != nil {
+		return nil, err
+	}
+	if frame.Has(FlagPriority) {
+		if len(payload) < 5 {
+			return nil, fmt.Errorf("HEADERS frame too short for priority fields")
+		}
+		payload = payload[5:]
+	}
+	return payload, nil
+}
+
+type Setting struct {
+	ID    SettingID
+	Value uint32
+}
+
+func parseSettings(payload []byte) ([]Setting, error) {
+	if len(payload)%6 != 0 {
+		return nil, fmt.Errorf("SETTINGS payload length %d is not a multiple of 6", len(payload))
+	}
+	settings := make([]Setting, 0, len(payload)/6)
+	for i := 0; i < len(payload); i += 6 {
+		settings = append(settings, Setting{
+			ID:    SettingID(binary.BigEndian.Uint16(payload[i:])),
+			Value: binary.BigEndian.Uint32(payload[i+2:]),
+		})
+	}
+	return settings, nil
+}
+
+func settingsPayload(settings ...Setting) []byte {
+	payload := make([]byte, 0, 6*len(settings))
+	for _, setting := range settings {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(setting.ID))
+		payload = binary.BigEndian.AppendUint32(payload, setting.Value)
+	}
+	return payload
+}
+
+func windowUpdateFrame(streamID, increment uint32) Frame {
+	return Frame{Type: FrameWindowUpdate, StreamID: streamID, Payload: binary.BigEndian.AppendUint32(nil, increment)}
+}
+
+func rstStreamFrame(streamID uint32, code ErrCode) Frame {
+	return Frame{Type: FrameRSTStream, StreamID: streamID, Payload: binary.BigEndian.AppendUint32(nil, uint32(code))}
+}
+
+func goAwayFrame(lastStreamID uint32, code ErrCode) Frame {
+	payload := binary.BigEndian.AppendUint32(nil, lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	return Frame{Type: FrameGoAway, Payload: payload}
+}
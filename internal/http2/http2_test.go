@@ -0,0 +1,102 @@
+package http2
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"httpfromtcp/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	frame := Frame{Type: FrameHeaders, Flags: FlagEndHeaders | FlagEndStream, StreamID: 3, Payload: []byte("block")}
+	require.NoError(t, WriteFrame(&buf, frame))
+	assert.Equal(t, []byte{0, 0, 5, 1, 5, 0, 0, 0, 3}, buf.Bytes()[:frameHeaderLen])
+
+	read, err := ReadFrame(&buf, DefaultMaxFrameSize)
+	require.NoError(t, err)
+	assert.Equal(t, frame, read)
+
+	// Test: Payload over the limit
+	require.NoError(t, WriteFrame(&buf, Frame{Type: FrameData, StreamID: 1, Payload: make([]byte, 100)}))
+	_, err = ReadFrame(&buf, 50)
+	assert.ErrorIs(t, err, ErrFrameTooLarge)
+
+	// Test: Padding is stripped
+	padded := Frame{Type: FrameData, Flags: FlagPadded, Payload: []byte{2, 'h', 'i', 0, 0}}
+	data, err := stripPadding(padded)
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(data))
+	_, err = stripPadding(Frame{Type: FrameData, Flags: FlagPadded, Payload: []byte{5, 'h'}})
+	assert.Error(t, err)
+}
+
+func TestSniffPreface(t *testing.T) {
+	// Test: Preface split over several reads
+	prefix, isHTTP2, err := SniffPreface(iotest.OneByteReader(strings.NewReader(ClientPreface + "frames")))
+	require.NoError(t, err)
+	assert.True(t, isHTTP2)
+	assert.Equal(t, ClientPreface, string(prefix))
+
+	// Test: HTTP/1.1 request stops at the first mismatch
+	prefix, isHTTP2, err = SniffPreface(iotest.OneByteReader(strings.NewReader("POST / HTTP/1.1\r\n")))
+	require.NoError(t, err)
+	assert.False(t, isHTTP2)
+	assert.Equal(t, "PO", string(prefix))
+
+	// Test: Short request
+	prefix, isHTTP2, err = SniffPreface(strings.NewReader("PRI"))
+	require.NoError(t, err)
+	assert.False(t, isHTTP2)
+	assert.Equal(t, "PRI", string(prefix))
+}
+
+func TestRequestHeaders(t *testing.T) {
+	line, h, err := requestHeaders([]headers.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/a"},
+		{Name: ":authority", Value: "example.com"},
+		{Name: "cookie", Value: "a=1"},
+		{Name: "cookie", Value: "b=2"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "GET", line.Method)
+	assert.Equal(t, "/a", line.RequestTarget)
+	host, _ := h.Get("Host")
+	assert.Equal(t, "example.com", host)
+	cookie, _ := h.Get("Cookie")
+	assert.Equal(t, "a=1; b=2", cookie)
+
+	malformed := [][]headers.HeaderField{
+		{{Name: ":method", Value: "GET"}, {Name: ":path", Value: "/"}},
+		{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: "accept", Value: "*/*"}, {Name: ":path", Value: "/"}},
+		{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}, {Name: "connection", Value: "close"}},
+		{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}, {Name: "Accept", Value: "*/*"}},
+		{{Name: ":method", Value: "GET"}, {Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}},
+		// names that are not tokens
+		{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}, {Name: "bad name", Value: "1"}},
+		{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}, {Name: "x-a:b", Value: "1"}},
+		// values injecting field lines or with control characters
+		{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}, {Name: "x-a", Value: "1\r\nTransfer-Encoding: chunked"}},
+		{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}, {Name: "x-a", Value: "1\n2"}},
+		{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}, {Name: "x-a", Value: "1\x002"}},
+		{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}, {Name: "x-a", Value: " 1"}},
+		{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}, {Name: ":authority", Value: "a\r\nb"}},
+		// request lines that could not be written over HTTP/1.1
+		{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/ HTTP/1.1\r\nX-Injected: 1\r\n"}},
+		{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/a b"}},
+		{{Name: ":method", Value: "get"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}},
+		{{Name: ":method", Value: "G(T"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}},
+		{{Name: ":method", Value: "CONNECT"}, {Name: ":authority", Value: "a b:443"}},
+	}
+	for _, fields := range malformed {
+		_, _, err := requestHeaders(fields)
+		assert.Error(t, err, fields)
+	}
+}
@@ -0,0 +1,1077 @@
+package http2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+// ClientPreface starts every HTTP/2 connection (RFC 9113 section 3.4)
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const (
+	// MaxConcurrentStreams is announced to clients, streams opened past it
+	// are refused
+	MaxConcurrentStreams = 100
+	// headerTableSize is the dynamic table size of both the encoder and the
+	// decoder
+	headerTableSize = headers.DefaultHeaderTableSize
+	// maxHeaderBlockSize bounds a header block split over CONTINUATION
+	// frames
+	maxHeaderBlockSize = 1 << 20
+	// DefaultMaxBodySize bounds the request bodies buffered for the handlers
+	// when Server.MaxBodySize is not set
+	DefaultMaxBodySize = 10 << 20
+)
+
+// connectionHeaders are specific to HTTP/1.1 connections and must not
+// appear in HTTP/2 messages (RFC 9113 section 8.2.2)
+var connectionHeaders = []string{"connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade"}
+
+var errStreamClosed = errors.New("HTTP/2 stream is closed")
+
+// Handler has the signature of server.Handler, which can be converted to it
+type Handler func(w *response.Writer, req *request.Request)
+
+// Server serves HTTP/2 connections handed over by the HTTP/1.1 server
+type Server struct {
+	Handler Handler
+	// ErrorStatus maps an error from building a request to the status sent
+	// to the client, 400 Bad Request is used when nil
+	ErrorStatus func(error) response.StatusCode
+	// BaseContext is the parent of the request contexts, which are also
+	// canceled when their stream is reset or the connection ends.
+	// context.Background is used when nil
+	BaseContext context.Context
+	// RequestTimeout sets the deadline of the request contexts when positive
+	RequestTimeout time.Duration
+	// MaxDecodedBodySize bounds the request bodies once their content
+	// codings are removed, request.DefaultMaxDecodedBodySize is used when
+	// zero
+	MaxDecodedBodySize int64
+	// MaxBodySize bounds the body of a request, which is buffered before the
+	// handler runs. Larger ones are answered 413 Content Too Large.
+	// DefaultMaxBodySize is used when zero
+	MaxBodySize int
+}
+
+// connError is a connection error (RFC 9113 section 5.4.1), answered with
+// GOAWAY
+type connError struct {
+	code   ErrCode
+	reason string
+}
+
+func (e connError) Error() string {
+	return fmt.Sprintf("HTTP/2 connection error %d: %s", e.code, e.reason)
+}
+
+// streamError is a stream error (RFC 9113 section 5.4.2), answered with
+// RST_STREAM
+type streamError struct {
+	streamID uint32
+	code     ErrCode
+}
+
+func (e streamError) Error() string {
+	return fmt.Sprintf("HTTP/2 stream %d error %d", e.streamID, e.code)
+}
+
+// SniffPreface reads from r for as long as the bytes match the client
+// preface, never past its end. It returns the bytes read and whether the
+// whole preface was received
+func SniffPreface(r io.Reader) ([]byte, bool, error) {
+	data := make([]byte, len(ClientPreface))
+	n := 0
+	for n < len(data) {
+		read, err := r.Read(data[n:])
+		n += read
+		if !strings.HasPrefix(ClientPreface, string(data[:n])) {
+			return data[:n], false, nil
+		}
+		if err == io.EOF {
+			return data[:n], false, nil
+		}
+		if err != nil {
+			return data[:n], false, err
+		}
+	}
+	return data, true, nil
+}
+
+// IsUpgradeRequest reports whether an HTTP/1.1 request asks to switch to
+// HTTP/2 over cleartext (RFC 7540 section 3.2)
+func IsUpgradeRequest(req *request.Request) bool {
+	_, hasSettings := req.Headers.Get("HTTP2-Settings")
+	return hasSettings &&
+		headerHasToken(req.Headers, "Upgrade", "h2c") &&
+		headerHasToken(req.Headers, "Connection", "upgrade") &&
+		headerHasToken(req.Headers, "Connection", "http2-settings")
+}
+
+// ServeConn serves a connection whose client sent the preface with prior
+// knowledge. prefix holds the bytes already read from the connection, which
+// is closed once all streams are done
+func (s *Server) ServeConn(conn net.Conn, prefix []byte) {
+	sc := newServerConn(s, conn, io.MultiReader(bytes.NewReader(prefix), conn))
+	sc.serve(nil)
+}
+
+// ServeUpgrade switches the connection of an h2c upgrade request to HTTP/2
+// and answers the request on stream 1. The connection is hijacked and closed
+// once all streams are done. On failure an error response has already been
+// written to the client
+func (s *Server) ServeUpgrade(w *response.Writer, req *request.Request) error {
+	value, _ := req.Headers.Get("HTTP2-Settings")
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(value), "="))
+	if err != nil {
+		w.WriteResponse(response.StatusBadRequest, "Invalid HTTP2-Settings")
+		return err
+	}
+	settings, err := parseSettings(payload)
+	if err != nil {
+		w.WriteResponse(response.StatusBadRequest, "Invalid HTTP2-Settings")
+		return err
+	}
+
+	header := headers.NewHeaders()
+	header.Put("Connection", "Upgrade")
+	header.Put("Upgrade", "h2c")
+	if err := w.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(header); err != nil {
+		return err
+	}
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return err
+	}
+
+	sc := newServerConn(s, conn, io.MultiReader(bytes.NewReader(buffered), conn))
+	if err := sc.applySettings(settings); err != nil {
+		conn.Close()
+		return err
+	}
+
+	upgraded := *req
+	upgraded.Headers = req.Headers.Clone()
+	upgraded.Headers.Remove("HTTP2-Settings")
+	for _, name := range connectionHeaders {
+		upgraded.Headers.Remove(name)
+	}
+	upgraded.RequestLine.HttpVersion = "2.0"
+	sc.serve(&upgraded)
+	return nil
+}
+
+type streamState int
+
+const (
+	stateOpen streamState = iota
+	stateHalfClosedRemote
+)
+
+type stream struct {
+	id    uint32
+	state streamState
+
+	fields   []headers.HeaderField
+	body     []byte
+	trailers []headers.HeaderField
+	// recvWindow and discard are only used by the read loop
+	recvWindow int64
+	// discard drops the body of a request already answered
+	discard bool
+
+	// sendWindow, reset and cancel are guarded by serverConn.mu
+	sendWindow int64
+	reset      bool
+	// cancel cancels the context of the request once it is dispatched
+	cancel context.CancelFunc
+}
+
+type serverConn struct {
+	server  *Server
+	conn    net.Conn
+	reader  *bufio.Reader
+	decoder *headers.Decoder
+	// ctx is canceled once the read loop ends
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// fields only used by the read loop
+	lastStreamID uint32
+	recvWindow   int64
+	// headerStream and headerBlock hold a header block that continues in
+	// CONTINUATION frames
+	headerStream    uint32
+	headerBlock     []byte
+	headerEndStream bool
+
+	// mu guards the writer and the fields below. cond is signaled when a
+	// send window grows or a stream stops
+	mu                sync.Mutex
+	cond              *sync.Cond
+	writer            *bufio.Writer
+	encoder           *headers.Encoder
+	streams           map[uint32]*stream
+	sendWindow        int64
+	peerInitialWindow int64
+	peerMaxFrameSize  uint32
+	// readDone is set once the client stopped sending, so no window update
+	// can arrive anymore
+	readDone bool
+
+	handlers sync.WaitGroup
+}
+
+func newServerConn(s *Server, conn net.Conn, r io.Reader) *serverConn {
+	sc := &serverConn{
+		server:            s,
+		conn:              conn,
+		reader:            bufio.NewReader(r),
+		decoder:           headers.NewDecoder(headerTableSize),
+		recvWindow:        DefaultWindowSize,
+		writer:            bufio.NewWriter(conn),
+		encoder:           headers.NewEncoder(headerTableSize),
+		streams:           make(map[uint32]*stream),
+		sendWindow:        DefaultWindowSize,
+		peerInitialWindow: DefaultWindowSize,
+		peerMaxFrameSize:  DefaultMaxFrameSize,
+	}
+	sc.cond = sync.NewCond(&sc.mu)
+	ctx := s.BaseContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	sc.ctx, sc.cancel = context.WithCancel(ctx)
+	return sc
+}
+
+// serve runs the read loop of the connection. upgraded is the request of an
+// h2c upgrade, which becomes stream 1
+func (sc *serverConn) serve(upgraded *request.Request) {
+	defer sc.conn.Close()
+
+	err := sc.writeFrame(Frame{Type: FrameSettings, Payload: settingsPayload(
+		Setting{ID: SettingMaxConcurrentStreams, Value: MaxConcurrentStreams},
+		Setting{ID: SettingHeaderTableSize, Value: headerTableSize},
+	)})
+	if err != nil {
+		return
+	}
+
+	preface := make([]byte, len(ClientPreface))
+	if _, err := io.ReadFull(sc.reader, preface); err != nil || string(preface) != ClientPreface {
+		return
+	}
+
+	if upgraded != nil {
+		st := sc.openStream(1)
+		st.state = stateHalfClosedRemote
+		sc.lastStreamID = 1
+		sc.dispatch(st, upgraded)
+	}
+
+	err = sc.readFrames()
+	var ce connError
+	if errors.As(err, &ce) {
+		sc.writeFrame(goAwayFrame(sc.lastStreamID, ce.code))
+	}
+
+	sc.mu.Lock()
+	sc.readDone = true
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+	// the handlers can no longer be answered
+	sc.cancel()
+	sc.handlers.Wait()
+}
+
+func (sc *serverConn) readFrames() error {
+	first := true
+	for {
+		frame, err := ReadFrame(sc.reader, DefaultMaxFrameSize)
+		if errors.Is(err, ErrFrameTooLarge) {
+			return connError{ErrCodeFrameSize, "Frame exceeds SETTINGS_MAX_FRAME_SIZE"}
+		}
+		if err != nil {
+			return err
+		}
+		// the preface is followed by the client's SETTINGS
+		if first && frame.Type != FrameSettings {
+			return connError{ErrCodeProtocol, "Expected SETTINGS after the preface"}
+		}
+		first = false
+
+		err = sc.processFrame(frame)
+		var se streamError
+		if errors.As(err, &se) {
+			sc.resetStream(se.streamID, se.code)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (sc *serverConn) processFrame(frame Frame) error {
+	if sc.headerBlock != nil && (frame.Type != FrameContinuation || frame.StreamID != sc.headerStream) {
+		return connError{ErrCodeProtocol, "Header block interrupted"}
+	}
+
+	switch frame.Type {
+	case FrameData:
+		return sc.processData(frame)
+	case FrameHeaders:
+		return sc.processHeaders(frame)
+	case FrameContinuation:
+		return sc.processContinuation(frame)
+	case FramePriority:
+		if frame.StreamID == 0 {
+			return connError{ErrCodeProtocol, "PRIORITY on stream 0"}
+		}
+		if len(frame.Payload) != 5 {
+			return streamError{frame.StreamID, ErrCodeFrameSize}
+		}
+		return nil
+	case FrameRSTStream:
+		return sc.processRSTStream(frame)
+	case FrameSettings:
+		return sc.processSettings(frame)
+	case FramePushPromise:
+		return connError{ErrCodeProtocol, "Clients cannot push"}
+	case FramePing:
+		if frame.StreamID != 0 {
+			return connError{ErrCodeProtocol, "PING on a stream"}
+		}
+		if len(frame.Payload) != 8 {
+			return connError{ErrCodeFrameSize, "PING payload must be 8 bytes"}
+		}
+		if frame.Has(FlagAck) {
+			return nil
+		}
+		return sc.writeFrame(Frame{Type: FramePing, Flags: FlagAck, Payload: frame.Payload})
+	case FrameGoAway:
+		if frame.StreamID != 0 {
+			return connError{ErrCodeProtocol, "GOAWAY on a stream"}
+		}
+		// running streams are completed, the client opens no new ones
+		return nil
+	case FrameWindowUpdate:
+		return sc.processWindowUpdate(frame)
+	}
+	// unknown frame types are ignored
+	return nil
+}
+
+func (sc *serverConn) processData(frame Frame) error {
+	if frame.StreamID == 0 {
+		return connError{ErrCodeProtocol, "DATA on stream 0"}
+	}
+
+	// the whole payload, padding included, counts against flow control
+	length := int64(len(frame.Payload))
+	sc.recvWindow -= length
+	if sc.recvWindow < 0 {
+		return connError{ErrCodeFlowControl, "Connection flow-control window exceeded"}
+	}
+	if length > 0 {
+		// the body is buffered in full up to MaxBodySize, so the window is
+		// replenished right away
+		sc.recvWindow += length
+		if err := sc.writeFrame(windowUpdateFrame(0, uint32(length))); err != nil {
+			return err
+		}
+	}
+
+	st := sc.stream(frame.StreamID)
+	if st == nil {
+		if frame.StreamID > sc.lastStreamID {
+			return connError{ErrCodeProtocol, "DATA on an idle stream"}
+		}
+		// the stream was reset or refused, the client may not have
+		// noticed yet
+		return nil
+	}
+	if st.state != stateOpen {
+		return streamError{frame.StreamID, ErrCodeStreamClosed}
+	}
+
+	st.recvWindow -= length
+	if st.recvWindow < 0 {
+		return streamError{frame.StreamID, ErrCodeFlowControl}
+	}
+	data, err := stripPadding(frame)
+	if err != nil {
+		return connError{ErrCodeProtocol, err.Error()}
+	}
+	if !st.discard && len(st.body)+len(data) > sc.maxBodySize() {
+		sc.rejectBody(st)
+	}
+	if st.discard {
+		if frame.Has(FlagEndStream) {
+			st.state = stateHalfClosedRemote
+			return nil
+		}
+		st.recvWindow += length
+		return sc.writeFrame(windowUpdateFrame(st.id, uint32(length)))
+	}
+	st.body = append(st.body, data...)
+
+	if frame.Has(FlagEndStream) {
+		return sc.endStream(st)
+	}
+	if length > 0 {
+		st.recvWindow += length
+		return sc.writeFrame(windowUpdateFrame(st.id, uint32(length)))
+	}
+	return nil
+}
+
+func (sc *serverConn) processHeaders(frame Frame) error {
+	if frame.StreamID == 0 {
+		return connError{ErrCodeProtocol, "HEADERS on stream 0"}
+	}
+	fragment, err := headerBlockFragment(frame)
+	if err != nil {
+		return connError{ErrCodeProtocol, err.Error()}
+	}
+
+	sc.headerStream = frame.StreamID
+	sc.headerEndStream = frame.Has(FlagEndStream)
+	sc.headerBlock = append([]byte{}, fragment...)
+	if frame.Has(FlagEndHeaders) {
+		return sc.processHeaderBlock()
+	}
+	return nil
+}
+
+func (sc *serverConn) processContinuation(frame Frame) error {
+	if sc.headerBlock == nil {
+		return connError{ErrCodeProtocol, "CONTINUATION without HEADERS"}
+	}
+	sc.headerBlock = append(sc.headerBlock, frame.Payload...)
+	if len(sc.headerBlock) > maxHeaderBlockSize {
+		return connError{ErrCodeEnhanceYourCalm, "Header block too large"}
+	}
+	if frame.Has(FlagEndHeaders) {
+		return sc.processHeaderBlock()
+	}
+	return nil
+}
+
+// processHeaderBlock handles a complete header block, which opens a stream
+// or carries its trailers
+func (sc *serverConn) processHeaderBlock() error {
+	id, block, endStream := sc.headerStream, sc.headerBlock, sc.headerEndStream
+	sc.headerBlock = nil
+
+	// the block is decoded even for refused streams to keep the dynamic
+	// table in sync with the client
+	fields, err := sc.decoder.Decode(block)
+	if err != nil {
+		return connError{ErrCodeCompression, err.Error()}
+	}
+
+	st := sc.stream(id)
+	switch {
+	case st == nil:
+		if id%2 == 0 || id <= sc.lastStreamID {
+			return connError{ErrCodeProtocol, fmt.Sprintf("Invalid stream id %d", id)}
+		}
+		sc.lastStreamID = id
+		if sc.activeStreams() >= MaxConcurrentStreams {
+			return streamError{id, ErrCodeRefusedStream}
+		}
+		st = sc.openStream(id)
+		st.fields = fields
+
+	case st.state == stateOpen:
+		// trailers end the stream
+		if !endStream {
+			return streamError{id, ErrCodeProtocol}
+		}
+		st.trailers = fields
+
+	default:
+		return streamError{id, ErrCodeStreamClosed}
+	}
+
+	if endStream {
+		return sc.endStream(st)
+	}
+	return nil
+}
+
+func (sc *serverConn) processRSTStream(frame Frame) error {
+	if frame.StreamID == 0 {
+		return connError{ErrCodeProtocol, "RST_STREAM on stream 0"}
+	}
+	if len(frame.Payload) != 4 {
+		return connError{ErrCodeFrameSize, "RST_STREAM payload must be 4 bytes"}
+	}
+	if frame.StreamID > sc.lastStreamID {
+		return connError{ErrCodeProtocol, "RST_STREAM on an idle stream"}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if st, found := sc.streams[frame.StreamID]; found {
+		st.reset = true
+		if st.cancel != nil {
+			st.cancel()
+		}
+		delete(sc.streams, frame.StreamID)
+		sc.cond.Broadcast()
+	}
+	return nil
+}
+
+func (sc *serverConn) processSettings(frame Frame) error {
+	if frame.StreamID != 0 {
+		return connError{ErrCodeProtocol, "SETTINGS on a stream"}
+	}
+	if frame.Has(FlagAck) {
+		if len(frame.Payload) != 0 {
+			return connError{ErrCodeFrameSize, "SETTINGS acknowledgement with a payload"}
+		}
+		return nil
+	}
+
+	settings, err := parseSettings(frame.Payload)
+	if err != nil {
+		return connError{ErrCodeFrameSize, err.Error()}
+	}
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+	return sc.writeFrame(Frame{Type: FrameSettings, Flags: FlagAck})
+}
+
+func (sc *serverConn) applySettings(settings []Setting) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, setting := range settings {
+		switch setting.ID {
+		case SettingEnablePush:
+			if setting.Value > 1 {
+				return connError{ErrCodeProtocol, "Invalid SETTINGS_ENABLE_PUSH"}
+			}
+		case SettingInitialWindowSize:
+			if setting.Value > maxWindowSize {
+				return connError{ErrCodeFlowControl, "Invalid SETTINGS_INITIAL_WINDOW_SIZE"}
+			}
+			// the change applies to the windows of all open streams
+			delta := int64(setting.Value) - sc.peerInitialWindow
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindowSize {
+					return connError{ErrCodeFlowControl, "Stream window overflow"}
+				}
+			}
+			sc.peerInitialWindow = int64(setting.Value)
+			sc.cond.Broadcast()
+		case SettingHeaderTableSize:
+			// the encoder never uses more than the default size
+			sc.encoder.SetMaxDynamicTableSize(min(setting.Value, headerTableSize))
+		case SettingMaxFrameSize:
+			if setting.Value < DefaultMaxFrameSize || setting.Value > maxAllowedFrameSize {
+				return connError{ErrCodeProtocol, "Invalid SETTINGS_MAX_FRAME_SIZE"}
+			}
+			sc.peerMaxFrameSize = setting.Value
+		}
+	}
+	return nil
+}
+
+func (sc *serverConn) processWindowUpdate(frame Frame) error {
+	if len(frame.Payload) != 4 {
+		return connError{ErrCodeFrameSize, "WINDOW_UPDATE payload must be 4 bytes"}
+	}
+	increment := int64(uint32(frame.Payload[0]&0x7F)<<24 | uint32(frame.Payload[1])<<16 | uint32(frame.Payload[2])<<8 | uint32(frame.Payload[3]))
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if frame.StreamID == 0 {
+		if increment == 0 {
+			return connError{ErrCodeProtocol, "WINDOW_UPDATE with a zero increment"}
+		}
+		sc.sendWindow += increment
+		if sc.sendWindow > maxWindowSize {
+			return connError{ErrCodeFlowControl, "Connection window overflow"}
+		}
+		sc.cond.Broadcast()
+		return nil
+	}
+
+	st, found := sc.streams[frame.StreamID]
+	if !found {
+		if frame.StreamID > sc.lastStreamID {
+			return connError{ErrCodeProtocol, "WINDOW_UPDATE on an idle stream"}
+		}
+		return nil
+	}
+	if increment == 0 {
+		return streamError{frame.StreamID, ErrCodeProtocol}
+	}
+	st.sendWindow += increment
+	if st.sendWindow > maxWindowSize {
+		return streamError{frame.StreamID, ErrCodeFlowControl}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) stream(id uint32) *stream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.streams[id]
+}
+
+func (sc *serverConn) activeStreams() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return len(sc.streams)
+}
+
+func (sc *serverConn) openStream(id uint32) *stream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	st := &stream{
+		id:         id,
+		state:      stateOpen,
+		recvWindow: DefaultWindowSize,
+		sendWindow: sc.peerInitialWindow,
+	}
+	sc.streams[id] = st
+	return st
+}
+
+func (sc *serverConn) closeStream(st *stream) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	delete(sc.streams, st.id)
+}
+
+func (sc *serverConn) resetStream(id uint32, code ErrCode) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if st, found := sc.streams[id]; found {
+		st.reset = true
+		if st.cancel != nil {
+			st.cancel()
+		}
+		delete(sc.streams, id)
+		sc.cond.Broadcast()
+	}
+	sc.writeFrameLocked(rstStreamFrame(id, code))
+}
+
+func (sc *serverConn) maxBodySize() int {
+	if sc.server.MaxBodySize > 0 {
+		return sc.server.MaxBodySize
+	}
+	return DefaultMaxBodySize
+}
+
+// rejectBody answers 413 to a request whose body is over MaxBodySize, then
+// asks the client to stop sending it (RFC 9113 section 8.1). What it still
+// sends is discarded
+func (sc *serverConn) rejectBody(st *stream) {
+	st.discard = true
+	st.body = nil
+	sc.handlers.Add(1)
+	go func() {
+		defer sc.handlers.Done()
+		sc.respond(st, "", func(w *response.Writer) {
+			w.WriteResponse(response.StatusContentTooLarge, request.ErrBodyTooLarge.Error())
+		})
+		sc.resetStream(st.id, ErrCodeNo)
+	}()
+}
+
+// endStream builds the request once the client has sent all of it and
+// hands it to the handler
+func (sc *serverConn) endStream(st *stream) error {
+	st.state = stateHalfClosedRemote
+
+	line, fields, err := requestHeaders(st.fields)
+	if err != nil {
+		return streamError{st.id, ErrCodeProtocol}
+	}
+	if cl, isPresent := fields.Get("Content-Length"); isPresent && cl != strconv.Itoa(len(st.body)) {
+		return streamError{st.id, ErrCodeProtocol}
+	}
+	trailers := headers.NewHeaders()
+	for _, field := range st.trailers {
+		if checkField(field) != nil {
+			return streamError{st.id, ErrCodeProtocol}
+		}
+		trailers.Put(field.Name, field.Value)
+	}
+
+	req, err := request.RequestFromFields(line, fields, st.body, trailers)
+	if err == nil {
+		err = req.DecodeBody(sc.server.MaxDecodedBodySize)
+	}
+	if err != nil {
+		sc.handlers.Add(1)
+		go func() {
+			defer sc.handlers.Done()
+			sc.respond(st, "", func(w *response.Writer) {
+				w.WriteResponse(sc.errorStatus(err), err.Error())
+			})
+		}()
+		return nil
+	}
+	sc.dispatch(st, req)
+	return nil
+}
+
+func (sc *serverConn) errorStatus(err error) response.StatusCode {
+	if sc.server.ErrorStatus == nil {
+		return response.StatusBadRequest
+	}
+	return sc.server.ErrorStatus(err)
+}
+
+func (sc *serverConn) dispatch(st *stream, req *request.Request) {
+	req.RemoteAddr = sc.conn.RemoteAddr().String()
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if sc.server.RequestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(sc.ctx, sc.server.RequestTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(sc.ctx)
+	}
+	sc.mu.Lock()
+	st.cancel = cancel
+	sc.mu.Unlock()
+	req = req.WithContext(ctx)
+
+	sc.handlers.Add(1)
+	go func() {
+		defer sc.handlers.Done()
+		defer cancel()
+		sc.respond(st, req.RequestLine.Method, func(w *response.Writer) {
+			sc.server.Handler(w, req)
+		})
+	}()
+}
+
+// respond runs handle with a writer whose HTTP/1.1 output is parsed back and
+// sent as HTTP/2 frames on the stream, so handlers work unchanged
+func (sc *serverConn) respond(st *stream, method string, handle func(w *response.Writer)) {
+	defer sc.closeStream(st)
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sc.writeResponse(st, method, pr)
+		// unblock a handler that keeps writing after the response
+		pr.Close()
+	}()
+
+	w := response.NewStreamWriter(pw)
+	handle(&w)
+	w.Finish()
+	pw.Close()
+	<-done
+}
+
+func (sc *serverConn) writeResponse(st *stream, method string, src io.Reader) {
+	reader := bufio.NewReader(src)
+	var resp *http.Response
+	for {
+		var err error
+		resp, err = http.ReadResponse(reader, &http.Request{Method: method})
+		// there is no switching protocols on an HTTP/2 stream
+		if err != nil || resp.StatusCode == http.StatusSwitchingProtocols {
+			sc.resetStream(st.id, ErrCodeInternal)
+			return
+		}
+		if resp.StatusCode/100 != 1 {
+			break
+		}
+		// interim responses are header blocks of their own before the
+		// final one (RFC 9113 section 8.1)
+		fields := []headers.HeaderField{{Name: ":status", Value: strconv.Itoa(resp.StatusCode)}}
+		fields = append(fields, responseFields(resp.Header)...)
+		if err := sc.writeHeaders(st, fields, false); err != nil {
+			return
+		}
+	}
+	defer resp.Body.Close()
+
+	fields := []headers.HeaderField{{Name: ":status", Value: strconv.Itoa(resp.StatusCode)}}
+	fields = append(fields, responseFields(resp.Header)...)
+
+	bodyAllowed := method != "HEAD" && resp.StatusCode != 204 && resp.StatusCode != 304
+	if err := sc.writeHeaders(st, fields, !bodyAllowed); err != nil || !bodyAllowed {
+		return
+	}
+
+	buffer := make([]byte, DefaultMaxFrameSize)
+	for {
+		n, err := resp.Body.Read(buffer)
+		if n > 0 {
+			if writeErr := sc.writeData(st, buffer[:n], false); writeErr != nil {
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			sc.resetStream(st.id, ErrCodeInternal)
+			return
+		}
+	}
+
+	if trailers := responseFields(resp.Trailer); len(trailers) > 0 {
+		sc.writeHeaders(st, trailers, true)
+		return
+	}
+	sc.writeData(st, nil, true)
+}
+
+// writeHeaders sends a header block, split into HEADERS and CONTINUATION
+// frames that no other frame can come between
+func (sc *serverConn) writeHeaders(st *stream, fields []headers.HeaderField, endStream bool) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if st.reset {
+		return errStreamClosed
+	}
+
+	block := sc.encoder.Encode(fields)
+	frameType := FrameHeaders
+	for first := true; first || len(block) > 0; first = false {
+		n := min(len(block), int(sc.peerMaxFrameSize))
+		frame := Frame{Type: frameType, StreamID: st.id, Payload: block[:n]}
+		if first && endStream {
+			frame.Flags |= FlagEndStream
+		}
+		if n == len(block) {
+			frame.Flags |= FlagEndHeaders
+		}
+		if err := sc.writeFrameLocked(frame); err != nil {
+			return err
+		}
+		block = block[n:]
+		frameType = FrameContinuation
+	}
+	return nil
+}
+
+// writeData sends data within the flow-control windows of the connection and
+// the stream, waiting for the client to open them when they are exhausted
+func (sc *serverConn) writeData(st *stream, data []byte, endStream bool) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for {
+		if st.reset {
+			return errStreamClosed
+		}
+		if len(data) == 0 {
+			if !endStream {
+				return nil
+			}
+			return sc.writeFrameLocked(Frame{Type: FrameData, Flags: FlagEndStream, StreamID: st.id})
+		}
+
+		window := min(sc.sendWindow, st.sendWindow)
+		if window <= 0 {
+			if sc.readDone {
+				return errStreamClosed
+			}
+			sc.cond.Wait()
+			continue
+		}
+
+		n := int(min(int64(len(data)), window, int64(sc.peerMaxFrameSize)))
+		frame := Frame{Type: FrameData, StreamID: st.id, Payload: data[:n]}
+		if n == len(data) && endStream {
+			frame.Flags = FlagEndStream
+		}
+		if err := sc.writeFrameLocked(frame); err != nil {
+			return err
+		}
+		sc.sendWindow -= int64(n)
+		st.sendWindow -= int64(n)
+		data = data[n:]
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+func (sc *serverConn) writeFrame(frame Frame) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.writeFrameLocked(frame)
+}
+
+func (sc *serverConn) writeFrameLocked(frame Frame) error {
+	if err := WriteFrame(sc.writer, frame); err != nil {
+		return err
+	}
+	return sc.writer.Flush()
+}
+
+// requestHeaders validates the fields of a request header block (RFC 9113
+// section 8.3.1) and splits them into the request line and regular headers
+func requestHeaders(fields []headers.HeaderField) (request.RequestLine, headers.Headers, error) {
+	line := request.RequestLine{HttpVersion: "2.0"}
+	h := headers.NewHeaders()
+	var scheme, authority string
+	regularSeen := false
+
+	for _, field := range fields {
+		if strings.HasPrefix(field.Name, ":") {
+			if regularSeen {
+				return line, nil, fmt.Errorf("Pseudo-header %s after regular headers", field.Name)
+			}
+			var target *string
+			switch field.Name {
+			case ":method":
+				target = &line.Method
+			case ":path":
+				target = &line.RequestTarget
+			case ":scheme":
+				target = &scheme
+			case ":authority":
+				target = &authority
+			default:
+				return line, nil, fmt.Errorf("Unknown pseudo-header %s", field.Name)
+			}
+			if *target != "" {
+				return line, nil, fmt.Errorf("Repeated pseudo-header %s", field.Name)
+			}
+			if !validFieldValue(field.Value) {
+				return line, nil, fmt.Errorf("Invalid value of %s", field.Name)
+			}
+			*target = field.Value
+			continue
+		}
+
+		regularSeen = true
+		if err := checkField(field); err != nil {
+			return line, nil, err
+		}
+		if isConnectionHeader(field.Name) {
+			return line, nil, fmt.Errorf("Connection-specific header %s", field.Name)
+		}
+		if field.Name == "te" && field.Value != "trailers" {
+			return line, nil, fmt.Errorf("TE header other than trailers")
+		}
+		// Put joins cookie crumbs with "; " rather than commas
+		h.Put(field.Name, field.Value)
+	}
+
+	if line.Method == "" {
+		return line, nil, fmt.Errorf("Missing :method")
+	}
+	// the request line is written as is when the request is proxied over
+	// HTTP/1.1
+	if !request.ValidMethod(line.Method) {
+		return line, nil, fmt.Errorf("Invalid :method %q", line.Method)
+	}
+	if line.Method == "CONNECT" {
+		if authority == "" || scheme != "" || line.RequestTarget != "" {
+			return line, nil, fmt.Errorf("Malformed CONNECT request")
+		}
+		line.RequestTarget = authority
+	} else if scheme == "" || line.RequestTarget == "" {
+		return line, nil, fmt.Errorf("Missing :scheme or :path")
+	}
+	if !request.ValidTarget(line.RequestTarget) {
+		return line, nil, fmt.Errorf("Invalid request target %q", line.RequestTarget)
+	}
+
+	if _, hasHost := h.Get("Host"); !hasHost && authority != "" {
+		h.Put("Host", authority)
+	}
+	return line, h, nil
+}
+
+// checkField rejects the malformed fields of a request (RFC 9113 section
+// 8.2.1): names must be lowercase tokens, and values must neither contain
+// CR, LF, NUL or other control characters nor start or end with whitespace.
+// Such fields could inject field lines once written over HTTP/1.1
+func checkField(field headers.HeaderField) error {
+	if !headers.IsToken(field.Name) || field.Name != strings.ToLower(field.Name) {
+		return fmt.Errorf("Header name %q is not a lowercase token", field.Name)
+	}
+	if !validFieldValue(field.Value) {
+		return fmt.Errorf("Invalid value of %s", field.Name)
+	}
+	return nil
+}
+
+func validFieldValue(value string) bool {
+	return headers.ValidFieldValue([]byte(value)) && strings.Trim(value, " \t") == value
+}
+
+// responseFields converts response headers to HTTP/2 fields, dropping the
+// connection-specific ones
+func responseFields(header http.Header) []headers.HeaderField {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fields []headers.HeaderField
+	for _, name := range names {
+		lower := strings.ToLower(name)
+		if isConnectionHeader(lower) {
+			continue
+		}
+		for _, value := range header[name] {
+			fields = append(fields, headers.HeaderField{Name: lower, Value: value})
+		}
+	}
+	return fields
+}
+
+func isConnectionHeader(name string) bool {
+	for _, candidate := range connectionHeaders {
+		if name == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+func headerHasToken(h headers.Headers, name, token string) bool {
+	value, isPresent := h.Get(name)
+	if !isPresent {
+		return false
+	}
+	for _, candidate := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(candidate), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrInvalidJWKS = errors.New("Invalid JWKS")
+
+// Key is a verification key from a JWK Set (RFC 7517)
+type Key struct {
+	ID string
+	// Algorithm restricts the key to one algorithm when set
+	Algorithm string
+	// Public is a []byte for HS256, or a *rsa.PublicKey, *ecdsa.PublicKey or
+	// ed25519.PublicKey
+	Public any
+}
+
+// KeySet is a parsed JWK Set
+type KeySet struct {
+	Keys []Key
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS parses a JWK Set document. Keys whose use is not "sig" are
+// skipped
+func ParseJWKS(data []byte) (*KeySet, error) {
+	var document struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJWKS, err)
+	}
+
+	set := &KeySet{}
+	for i, k := range document.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		public, err := k.public()
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %d (%q): %v", ErrInvalidJWKS, i, k.Kid, err)
+		}
+		set.Keys = append(set.Keys, Key{ID: k.Kid, Algorithm: k.Alg, Public: public})
+	}
+	return set, nil
+}
+
+func (k jwk) public() (any, error) {
+	switch k.Kty {
+	case "oct":
+		secret, err := decodeSegment(k.K)
+		if err != nil || len(secret) == 0 {
+			return nil, errors.New("bad k")
+		}
+		return secret, nil
+	case "RSA":
+		n, errN := decodeSegment(k.N)
+		e, errE := decodeSegment(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("bad modulus or exponent")
+		}
+		public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if public.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must have at least 2048 bits")
+		}
+		return public, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, errX := decodeSegment(k.X)
+		y, errY := decodeSegment(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("bad coordinates")
+		}
+		// the uncompressed point encoding validates that it is on the curve
+		point := append([]byte{4}, append(x, y...)...)
+		public, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return nil, err
+		}
+		return public, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("bad x")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// KeyProvider returns the keys tokens may be signed with
+type KeyProvider interface {
+	KeySet() (*KeySet, error)
+}
+
+func (s *KeySet) KeySet() (*KeySet, error) {
+	return s, nil
+}
+
+// DefaultCheckInterval is how often JWKSFile checks whether its file changed
+const DefaultCheckInterval = time.Second
+
+// JWKSFile is a KeyProvider reading a JWK Set from disk. The file is parsed
+// again when its size or modification time changes, so keys can be rotated
+// without a restart. A file that fails to parse keeps the previous keys
+type JWKSFile struct {
+	path string
+
+	mu            sync.Mutex
+	set           *KeySet
+	modTime       time.Time
+	size          int64
+	lastCheck     time.Time
+	checkInterval time.Duration
+}
+
+func NewJWKSFile(path string) (*JWKSFile, error) {
+	f := &JWKSFile{path: path, checkInterval: DefaultCheckInterval}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *JWKSFile) KeySet() (*KeySet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if time.Since(f.lastCheck) >= f.checkInterval {
+		// a broken update must not lock every client out
+		_ = f.reload()
+	}
+	return f.set, nil
+}
+
+// reload parses the file when it changed. It must be called with mu held,
+// or before the JWKSFile is shared
+func (f *JWKSFile) reload() error {
+	f.lastCheck = time.Now()
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if f.set != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return nil
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	set, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+	f.set, f.modTime, f.size = set, info.ModTime(), info.Size()
+	return nil
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,314 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/auth"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/server"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// DefaultClockSkew is the tolerance applied to exp and nbf
+const DefaultClockSkew = time.Minute
+
+var (
+	ErrMalformed       = errors.New("Malformed token")
+	ErrAlgorithm       = errors.New("Unsupported token algorithm")
+	ErrUnknownKey      = errors.New("No key matches the token")
+	ErrSignature       = errors.New("Invalid token signature")
+	ErrExpired         = errors.New("Token is expired")
+	ErrNotYetValid     = errors.New("Token is not valid yet")
+	ErrInvalidIssuer   = errors.New("Invalid token issuer")
+	ErrInvalidAudience = errors.New("Invalid token audience")
+)
+
+// Claims holds the registered claims of a token (RFC 7519 section 4.1).
+// Times are zero when the claim is absent
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+	// Raw holds every claim of the token, private ones included
+	Raw map[string]any
+}
+
+// ClaimsFromMap extracts the registered claims from a decoded claims set
+func ClaimsFromMap(raw map[string]any) (*Claims, error) {
+	c := &Claims{Raw: raw}
+	var err error
+	for name, dst := range map[string]*string{"iss": &c.Issuer, "sub": &c.Subject, "jti": &c.ID} {
+		if err == nil {
+			err = stringClaim(raw, name, dst)
+		}
+	}
+	for name, dst := range map[string]*time.Time{"exp": &c.ExpiresAt, "nbf": &c.NotBefore, "iat": &c.IssuedAt} {
+		if err == nil {
+			err = timeClaim(raw, name, dst)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// aud is either a single string or an array of strings
+	switch aud := raw["aud"].(type) {
+	case nil:
+	case string:
+		c.Audience = []string{aud}
+	case []any:
+		for _, value := range aud {
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: aud", ErrMalformed)
+			}
+			c.Audience = append(c.Audience, s)
+		}
+	default:
+		return nil, fmt.Errorf("%w: aud", ErrMalformed)
+	}
+	return c, nil
+}
+
+func stringClaim(raw map[string]any, name string, dst *string) error {
+	value, isPresent := raw[name]
+	if !isPresent {
+		return nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrMalformed, name)
+	}
+	*dst = s
+	return nil
+}
+
+func timeClaim(raw map[string]any, name string, dst *time.Time) error {
+	value, isPresent := raw[name]
+	if !isPresent {
+		return nil
+	}
+	seconds, ok := value.(float64)
+	if !ok || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return fmt.Errorf("%w: %s", ErrMalformed, name)
+	}
+	whole, fraction := math.Modf(seconds)
+	*dst = time.Unix(int64(whole), int64(fraction*1e9))
+	return nil
+}
+
+// Validator checks the signature and the claims of tokens
+type Validator struct {
+	Keys KeyProvider
+	// Algorithms restricts the accepted algorithms, all supported ones are
+	// accepted when empty
+	Algorithms []string
+	// Issuer and Audience are only checked when set
+	Issuer   string
+	Audience string
+	// ClockSkew is the tolerance applied to exp and nbf, DefaultClockSkew
+	// when zero
+	ClockSkew time.Duration
+
+	now func() time.Time
+}
+
+// Validate verifies a compact serialized JWS token and returns its claims
+func (v *Validator) Validate(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: expected 3 segments", ErrMalformed)
+	}
+	headerJSON, errHeader := decodeSegment(parts[0])
+	claimsJSON, errClaims := decodeSegment(parts[1])
+	signature, errSignature := decodeSegment(parts[2])
+	if errHeader != nil || errClaims != nil || errSignature != nil {
+		return nil, fmt.Errorf("%w: bad base64url segment", ErrMalformed)
+	}
+
+	var header struct {
+		Alg  string   `json:"alg"`
+		Kid  string   `json:"kid"`
+		Crit []string `json:"crit"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMalformed, err)
+	}
+	// no extension is understood (RFC 7515 section 4.1.11)
+	if len(header.Crit) > 0 {
+		return nil, fmt.Errorf("%w: critical header parameters %v", ErrMalformed, header.Crit)
+	}
+	if !v.accepts(header.Alg) {
+		return nil, fmt.Errorf("%w: %q", ErrAlgorithm, header.Alg)
+	}
+
+	err := v.verify(header.Alg, header.Kid, []byte(parts[0]+"."+parts[1]), signature)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(claimsJSON, &raw); err != nil || raw == nil {
+		return nil, fmt.Errorf("%w: claims", ErrMalformed)
+	}
+	claims, err := ClaimsFromMap(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *Validator) accepts(alg string) bool {
+	switch alg {
+	case HS256, RS256, ES256, EdDSA:
+		return len(v.Algorithms) == 0 || slices.Contains(v.Algorithms, alg)
+	}
+	return false
+}
+
+// verify checks the signature with the keys of the token's key ID, or with
+// every key when the token has none. Keys must be of the type of the
+// algorithm, which prevents using a public key as an HMAC secret
+func (v *Validator) verify(alg, kid string, signed, signature []byte) error {
+	set, err := v.Keys.KeySet()
+	if err != nil {
+		return err
+	}
+	candidates := 0
+	for _, key := range set.Keys {
+		if (kid != "" && key.ID != kid) || (key.Algorithm != "" && key.Algorithm != alg) {
+			continue
+		}
+		valid, matches := verifySignature(alg, key.Public, signed, signature)
+		if !matches {
+			continue
+		}
+		if valid {
+			return nil
+		}
+		candidates++
+	}
+	if candidates == 0 {
+		return fmt.Errorf("%w: kid %q, alg %s", ErrUnknownKey, kid, alg)
+	}
+	return ErrSignature
+}
+
+// verifySignature reports whether the signature is valid, and whether the
+// key can be used with alg at all
+func verifySignature(alg string, public any, signed, signature []byte) (valid, matches bool) {
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case HS256:
+		secret, ok := public.([]byte)
+		if !ok {
+			return false, false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(signature, mac.Sum(nil)), true
+	case RS256:
+		key, ok := public.(*rsa.PublicKey)
+		if !ok {
+			return false, false
+		}
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil, true
+	case ES256:
+		key, ok := public.(*ecdsa.PublicKey)
+		if !ok {
+			return false, false
+		}
+		// JWS signatures are the fixed size concatenation of r and s
+		// (RFC 7518 section 3.4)
+		if len(signature) != 64 {
+			return false, true
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest[:], r, s), true
+	case EdDSA:
+		key, ok := public.(ed25519.PublicKey)
+		if !ok {
+			return false, false
+		}
+		return ed25519.Verify(key, signed, signature), true
+	}
+	return false, false
+}
+
+func (v *Validator) checkClaims(c *Claims) error {
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+	skew := v.ClockSkew
+	if skew == 0 {
+		skew = DefaultClockSkew
+	}
+
+	if !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt.Add(skew)) {
+		return fmt.Errorf("%w: expired at %s", ErrExpired, c.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if !c.NotBefore.IsZero() && now.Before(c.NotBefore.Add(-skew)) {
+		return fmt.Errorf("%w: valid from %s", ErrNotYetValid, c.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if v.Issuer != "" && c.Issuer != v.Issuer {
+		return fmt.Errorf("%w: %q", ErrInvalidIssuer, c.Issuer)
+	}
+	if v.Audience != "" && !slices.Contains(c.Audience, v.Audience) {
+		return fmt.Errorf("%w: %v", ErrInvalidAudience, c.Audience)
+	}
+	return nil
+}
+
+// Middleware requires a valid JWT sent as a bearer token. The subject is
+// the name of the auth.Principal, and the claims are available through
+// ClaimsOf
+func Middleware(realm string, v *Validator) server.Middleware {
+	return auth.Bearer(realm, func(token string) (auth.Principal, error) {
+		claims, err := v.Validate(token)
+		if err != nil {
+			return auth.Principal{}, err
+		}
+		return auth.Principal{Name: claims.Subject, Claims: claims.Raw}, nil
+	})
+}
+
+// ClaimsOf returns the claims of a request authenticated by Middleware
+func ClaimsOf(req *request.Request) (*Claims, bool) {
+	p, found := auth.PrincipalOf(req)
+	if !found || p.Claims == nil {
+		return nil, false
+	}
+	claims, err := ClaimsFromMap(p.Claims)
+	if err != nil {
+		return nil, false
+	}
+	return claims, true
+}
@@ -0,0 +1,249 @@
+package jwt
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// testKeys holds a private key per algorithm and the matching JWK
+type testKeys struct {
+	hmacSecret []byte
+	rsa        *rsa.PrivateKey
+	ecdsa      *ecdsa.PrivateKey
+	ed25519    ed25519.PrivateKey
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return &testKeys{
+		hmacSecret: []byte("a shared secret of at least 32 bytes"),
+		rsa:        rsaKey,
+		ecdsa:      ecKey,
+		ed25519:    edKey,
+	}
+}
+
+func (k *testKeys) jwks(t *testing.T) []byte {
+	ecPoint, err := k.ecdsa.PublicKey.Bytes()
+	require.NoError(t, err)
+	keys := []map[string]string{
+		{"kty": "oct", "kid": "hs", "alg": HS256, "k": encode(k.hmacSecret)},
+		{"kty": "RSA", "kid": "rs", "use": "sig", "n": encode(k.rsa.N.Bytes()),
+			"e": encode(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kty": "EC", "kid": "es", "crv": "P-256", "x": encode(ecPoint[1:33]), "y": encode(ecPoint[33:])},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": encode(k.ed25519.Public().(ed25519.PublicKey))},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+	}
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	require.NoError(t, err)
+	return data
+}
+
+func (k *testKeys) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := encode(header) + "." + encode(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case HS256:
+		mac := hmac.New(sha256.New, k.hmacSecret)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case RS256:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case ES256:
+		r, s, err := ecdsa.Sign(rand.Reader, k.ecdsa, digest[:])
+		require.NoError(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	case EdDSA:
+		signature = ed25519.Sign(k.ed25519, []byte(signed))
+	}
+	return signed + "." + encode(signature)
+}
+
+func TestValidate(t *testing.T) {
+	keys := newTestKeys(t)
+	set, err := ParseJWKS(keys.jwks(t))
+	require.NoError(t, err)
+	assert.Len(t, set.Keys, 4, "encryption keys are skipped")
+
+	now := time.Unix(1_800_000_000, 0)
+	v := &Validator{Keys: set, Issuer: "https://idp.example", Audience: "api", now: func() time.Time { return now }}
+	claims := map[string]any{
+		"iss":  "https://idp.example",
+		"sub":  "alice",
+		"aud":  []string{"api", "other"},
+		"exp":  now.Add(time.Hour).Unix(),
+		"nbf":  now.Add(-time.Minute).Unix(),
+		"role": "admin",
+	}
+
+	for alg, kid := range map[string]string{HS256: "hs", RS256: "rs", ES256: "es", EdDSA: "ed"} {
+		t.Run(alg, func(t *testing.T) {
+			got, err := v.Validate(keys.sign(t, alg, kid, claims))
+			require.NoError(t, err)
+			assert.Equal(t, "alice", got.Subject)
+			assert.Equal(t, []string{"api", "other"}, got.Audience)
+			assert.Equal(t, now.Add(time.Hour), got.ExpiresAt)
+			assert.Equal(t, "admin", got.Raw["role"])
+
+			// without a kid every key of the right type is tried
+			_, err = v.Validate(keys.sign(t, alg, "", claims))
+			assert.NoError(t, err)
+		})
+	}
+
+	with := func(name string, value any) map[string]any {
+		changed := make(map[string]any)
+		for k, v := range claims {
+			changed[k] = v
+		}
+		if value == nil {
+			delete(changed, name)
+		} else {
+			changed[name] = value
+		}
+		return changed
+	}
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"expired", keys.sign(t, ES256, "es", with("exp", now.Add(-2*time.Minute).Unix())), ErrExpired},
+		{"not yet valid", keys.sign(t, ES256, "es", with("nbf", now.Add(2*time.Minute).Unix())), ErrNotYetValid},
+		{"wrong issuer", keys.sign(t, ES256, "es", with("iss", "https://evil.example")), ErrInvalidIssuer},
+		{"wrong audience", keys.sign(t, ES256, "es", with("aud", "other")), ErrInvalidAudience},
+		{"bad exp", keys.sign(t, ES256, "es", with("exp", "tomorrow")), ErrMalformed},
+		{"unknown kid", keys.sign(t, ES256, "missing", claims), ErrUnknownKey},
+		{"key of another type", keys.sign(t, ES256, "rs", claims), ErrUnknownKey},
+		{"key restricted to another alg", keys.sign(t, RS256, "hs", claims), ErrUnknownKey},
+		{"none", keys.sign(t, "none", "", claims), ErrAlgorithm},
+		{"two segments", "abc.def", ErrMalformed},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := v.Validate(tc.token)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	// within the clock skew
+	_, err = v.Validate(keys.sign(t, HS256, "hs", with("exp", now.Add(-30*time.Second).Unix())))
+	assert.NoError(t, err)
+
+	// the signature covers the claims
+	token := keys.sign(t, EdDSA, "ed", claims)
+	parts := strings.Split(token, ".")
+	forged := keys.sign(t, EdDSA, "ed", with("sub", "mallory"))
+	_, err = v.Validate(parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2])
+	assert.ErrorIs(t, err, ErrSignature)
+
+	only := &Validator{Keys: set, Algorithms: []string{RS256}, now: v.now}
+	_, err = only.Validate(keys.sign(t, HS256, "hs", claims))
+	assert.ErrorIs(t, err, ErrAlgorithm)
+}
+
+func TestJWKSFile(t *testing.T) {
+	first, second := newTestKeys(t), newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, first.jwks(t), 0o600))
+
+	file, err := NewJWKSFile(path)
+	require.NoError(t, err)
+	file.checkInterval = 0
+	v := &Validator{Keys: file}
+	claims := map[string]any{"sub": "alice"}
+
+	_, err = v.Validate(first.sign(t, EdDSA, "ed", claims))
+	require.NoError(t, err)
+
+	// rotated keys are picked up once the file changes
+	require.NoError(t, os.WriteFile(path, second.jwks(t), 0o600))
+	later := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, later, later))
+	_, err = v.Validate(first.sign(t, EdDSA, "ed", claims))
+	assert.ErrorIs(t, err, ErrSignature)
+	_, err = v.Validate(second.sign(t, EdDSA, "ed", claims))
+	require.NoError(t, err)
+
+	// a broken file keeps the last good keys
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	_, err = v.Validate(second.sign(t, EdDSA, "ed", claims))
+	assert.NoError(t, err)
+
+	_, err = NewJWKSFile(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	keys := newTestKeys(t)
+	set, err := ParseJWKS(keys.jwks(t))
+	require.NoError(t, err)
+	handler := Middleware("api", &Validator{Keys: set, Audience: "api"})(
+		func(w *response.Writer, req *request.Request) {
+			claims, found := ClaimsOf(req)
+			require.True(t, found)
+			w.WriteResponse(response.StatusOk, claims.Subject+" "+claims.Raw["role"].(string))
+		})
+
+	serve := func(token string) (*http.Response, string) {
+		req, err := request.RequestFromReader(strings.NewReader(
+			"GET / HTTP/1.1\r\nHost: localhost\r\nAuthorization: Bearer " + token + "\r\n\r\n"))
+		require.NoError(t, err)
+		w := response.NewWriter()
+		handler(&w, req)
+		resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(w.ReadBuffer())), nil)
+		require.NoError(t, err)
+		var body strings.Builder
+		_, err = bufio.NewReader(resp.Body).WriteTo(&body)
+		require.NoError(t, err)
+		return resp, body.String()
+	}
+
+	resp, body := serve(keys.sign(t, RS256, "rs", map[string]any{"sub": "alice", "aud": "api", "role": "admin"}))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "alice admin", body)
+
+	resp, _ = serve(keys.sign(t, RS256, "rs", map[string]any{"sub": "alice", "aud": "other"}))
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, `Bearer realm="api", error="invalid_token"`, resp.Header.Get("WWW-Authenticate"))
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// DefaultRoute is the route label of requests when HTTP.Route is nil. Raw
+// paths are not used, as clients could create any number of series
+const DefaultRoute = "other"
+
+// methods are the method label values, other methods are counted as OTHER
+var methods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "DELETE": true,
+	"CONNECT": true, "OPTIONS": true, "TRACE": true, "PATCH": true,
+}
+
+// HTTP collects the metrics of a server. Its Middleware records the
+// requests, and it is a server.Observer recording the connections
+type HTTP struct {
+	// Route names the route of a request, DefaultRoute for every request
+	// when nil. It must return few distinct values, each becomes a series
+	Route func(req *request.Request) string
+
+	requests     *CounterVec
+	duration     *HistogramVec
+	requestSize  *HistogramVec
+	responseSize *HistogramVec
+	activeConns  Gauge
+	parseErrors  *CounterVec
+	bytesRead    Counter
+	bytesWritten Counter
+}
+
+func NewHTTP(r *Registry) *HTTP {
+	return &HTTP{
+		requests: r.NewCounterVec("http_requests_total",
+			"Requests handled, by method, route and status code.", "method", "route", "status"),
+		duration: r.NewHistogramVec("http_request_duration_seconds",
+			"Time spent handling requests.", DefBuckets, "method", "route"),
+		requestSize: r.NewHistogramVec("http_request_size_bytes",
+			"Size of the request bodies.", SizeBuckets, "method", "route"),
+		responseSize: r.NewHistogramVec("http_response_size_bytes",
+			"Size of the responses, headers included.", SizeBuckets, "method", "route"),
+		activeConns: r.NewGauge("http_active_connections",
+			"Connections being handled."),
+		parseErrors: r.NewCounterVec("http_parse_errors_total",
+			"Requests that could not be parsed, by kind.", "kind"),
+		bytesRead: r.NewCounter("http_read_bytes_total",
+			"Bytes read from clients."),
+		bytesWritten: r.NewCounter("http_written_bytes_total",
+			"Bytes written to clients."),
+	}
+}
+
+// Middleware records the requests passing through it
+func (m *HTTP) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		start := time.Now()
+		next(w, req)
+		elapsed := time.Since(start)
+
+		route := m.route(req)
+		method := req.RequestLine.Method
+		if !methods[method] {
+			method = "OTHER"
+		}
+		m.requests.With(method, route, strconv.Itoa(int(w.StatusCode()))).Inc()
+		m.duration.With(method, route).Observe(elapsed.Seconds())
+		m.requestSize.With(method, route).Observe(float64(len(req.Body)))
+		m.responseSize.With(method, route).Observe(float64(w.BytesWritten()))
+	}
+}
+
+func (m *HTTP) route(req *request.Request) string {
+	if m.Route != nil {
+		return m.Route(req)
+	}
+	return DefaultRoute
+}
+
+func (m *HTTP) ConnOpened() {
+	m.activeConns.Inc()
+}
+
+func (m *HTTP) ConnClosed(bytesRead, bytesWritten int64) {
+	m.activeConns.Dec()
+	m.bytesRead.Add(float64(bytesRead))
+	m.bytesWritten.Add(float64(bytesWritten))
+}
+
+func (m *HTTP) ParseError(err error) {
+	m.parseErrors.With(parseErrorKind(err)).Inc()
+}
+
+func parseErrorKind(err error) string {
+	switch {
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return "body_too_large"
+	case errors.Is(err, request.ErrUnsupportedContentEncoding):
+		return "unsupported_encoding"
+	default:
+		return "malformed"
+	}
+}
+
+var _ server.Observer = (*HTTP)(nil)
@@ -0,0 +1,320 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// DefBuckets are the default histogram buckets, suited to latencies in
+// seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// SizeBuckets suit sizes in bytes, from 100B to 100MB
+var SizeBuckets = []float64{100, 1000, 10_000, 100_000, 1_000_000, 10_000_000, 100_000_000}
+
+// ContentType is the media type of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Registry holds metric families and writes them in the Prometheus text
+// exposition format
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	// buckets are the upper bounds of histograms, without +Inf
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is one combination of label values. Counters and gauges use value,
+// histograms use counts and sum
+type series struct {
+	labelValues []string
+	value       atomic.Uint64
+	counts      []atomic.Uint64
+	count       atomic.Uint64
+	sum         atomic.Uint64
+}
+
+func (r *Registry) register(name, help, kind string, labels []string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range r.families {
+		if f.name == name {
+			panic(fmt.Sprintf("metrics: %s is already registered", name))
+		}
+	}
+	f := &family{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*series)}
+	r.families = append(r.families, f)
+	return f
+}
+
+// with returns the series of the label values, creating it on first use
+func (f *family) with(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, found := f.series[key]
+	if !found {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		if f.kind == "histogram" {
+			s.counts = make([]atomic.Uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// addFloat atomically adds delta to a float64 stored as bits
+func addFloat(bits *atomic.Uint64, delta float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	s *series
+}
+
+func (c Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increases the counter, negative values are ignored
+func (c Counter) Add(delta float64) {
+	if delta > 0 {
+		addFloat(&c.s.value, delta)
+	}
+}
+
+// CounterVec is a family of counters partitioned by labels
+type CounterVec struct {
+	f *family
+}
+
+func (r *Registry) NewCounter(name, help string) Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{f: r.register(name, help, "counter", labels, nil)}
+}
+
+// With returns the counter of the label values, given in the order of the
+// label names
+func (v *CounterVec) With(labelValues ...string) Counter {
+	return Counter{s: v.f.with(labelValues)}
+}
+
+// Gauge is a value that goes up and down
+type Gauge struct {
+	s *series
+}
+
+func (g Gauge) Set(value float64) {
+	g.s.value.Store(math.Float64bits(value))
+}
+
+func (g Gauge) Add(delta float64) {
+	addFloat(&g.s.value, delta)
+}
+
+func (g Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g Gauge) Dec() {
+	g.Add(-1)
+}
+
+// GaugeVec is a family of gauges partitioned by labels
+type GaugeVec struct {
+	f *family
+}
+
+func (r *Registry) NewGauge(name, help string) Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{f: r.register(name, help, "gauge", labels, nil)}
+}
+
+func (v *GaugeVec) With(labelValues ...string) Gauge {
+	return Gauge{s: v.f.with(labelValues)}
+}
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	f *family
+	s *series
+}
+
+func (h Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.f.buckets, value)
+	if i < len(h.s.counts) {
+		h.s.counts[i].Add(1)
+	}
+	h.s.count.Add(1)
+	addFloat(&h.s.sum, value)
+}
+
+// HistogramVec is a family of histograms partitioned by labels
+type HistogramVec struct {
+	f *family
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds,
+// DefBuckets when nil
+func (r *Registry) NewHistogram(name, help string, buckets []float64) Histogram {
+	return r.NewHistogramVec(name, help, buckets).With()
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	return &HistogramVec{f: r.register(name, help, "histogram", labels, buckets)}
+}
+
+func (v *HistogramVec) With(labelValues ...string) Histogram {
+	return Histogram{f: v.f, s: v.f.with(labelValues)}
+}
+
+// WriteTo writes every metric in the text exposition format, families in
+// registration order and series sorted by label values
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	var b strings.Builder
+	for _, f := range families {
+		f.write(&b)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (f *family) write(b *strings.Builder) {
+	f.mu.Lock()
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	f.mu.Unlock()
+	slices.SortFunc(all, func(a, b *series) int {
+		return slices.Compare(a.labelValues, b.labelValues)
+	})
+
+	fmt.Fprintf(b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(b, "# TYPE %s %s\n", f.name, f.kind)
+	for _, s := range all {
+		if f.kind != "histogram" {
+			writeSample(b, f.name, f.labels, s.labelValues, "", "", math.Float64frombits(s.value.Load()))
+			continue
+		}
+		cumulative := uint64(0)
+		for i, bound := range f.buckets {
+			cumulative += s.counts[i].Load()
+			writeSample(b, f.name+"_bucket", f.labels, s.labelValues, "le", formatFloat(bound), float64(cumulative))
+		}
+		count := s.count.Load()
+		writeSample(b, f.name+"_bucket", f.labels, s.labelValues, "le", "+Inf", float64(count))
+		writeSample(b, f.name+"_sum", f.labels, s.labelValues, "", "", math.Float64frombits(s.sum.Load()))
+		writeSample(b, f.name+"_count", f.labels, s.labelValues, "", "", float64(count))
+	}
+}
+
+func writeSample(b *strings.Builder, name string, labels, values []string, extraLabel, extraValue string, value float64) {
+	b.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, "%s=\"%s\"", label, escapeLabelValue(values[i]))
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, "%s=\"%s\"", extraLabel, extraValue)
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(value))
+	b.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// Handler serves the metrics of the registry
+func (r *Registry) Handler() server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		var b strings.Builder
+		r.WriteTo(&b)
+		header := response.GetDefaultHeader(b.Len())
+		header.Replace("content-type", ContentType)
+		w.WriteStatusLine(response.StatusOk)
+		w.WriteHeaders(header)
+		w.WriteBody(b.String())
+	}
+}
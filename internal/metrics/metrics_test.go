@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exposition(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	_, err := r.WriteTo(&b)
+	require.NoError(t, err)
+	return b.String()
+}
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests\nby code.", "code", "path")
+	temperature := r.NewGauge("temperature", `Degrees in C:\`)
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{1, 0.1})
+
+	requests.With("200", "/b").Add(2)
+	requests.With("200", "/a").Inc()
+	requests.With("404", `/"quoted"`+"\n").Inc()
+	requests.With("200", "/a").Add(-5)
+	temperature.Set(21.5)
+	temperature.Dec()
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		latency.Observe(v)
+	}
+
+	expected := `# HELP requests_total Requests\nby code.
+# TYPE requests_total counter
+requests_total{code="200",path="/a"} 1
+requests_total{code="200",path="/b"} 2
+requests_total{code="404",path="/\"quoted\"\n"} 1
+# HELP temperature Degrees in C:\\
+# TYPE temperature gauge
+temperature 20.5
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 3
+latency_seconds_bucket{le="+Inf"} 4
+latency_seconds_sum 3.65
+latency_seconds_count 4
+`
+	assert.Equal(t, expected, exposition(t, r))
+
+	assert.Panics(t, func() { r.NewGauge("temperature", "again") })
+	assert.Panics(t, func() { requests.With("200") })
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounterVec("hits_total", "Hits.", "worker")
+	histogram := r.NewHistogram("values", "Values.", []float64{10})
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for range 1000 {
+				counter.With("all").Inc()
+				histogram.Observe(1)
+			}
+		})
+	}
+	wg.Wait()
+
+	out := exposition(t, r)
+	assert.Contains(t, out, `hits_total{worker="all"} 8000`)
+	assert.Contains(t, out, "values_sum 8000\nvalues_count 8000\n")
+}
+
+func TestHTTP(t *testing.T) {
+	r := NewRegistry()
+	m := NewHTTP(r)
+	m.Route = func(req *request.Request) string {
+		if strings.HasPrefix(req.RequestLine.RequestTarget, "/greet") {
+			return "/greet"
+		}
+		return DefaultRoute
+	}
+	mux := func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/metrics" {
+			r.Handler()(w, req)
+			return
+		}
+		w.WriteResponse(response.StatusOk, "hello")
+	}
+	s, err := server.ServeWithOptions(0, m.Middleware(mux), server.Options{Observer: m})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	_, port, err := net.SplitHostPort(s.Addr().String())
+	require.NoError(t, err)
+
+	send := func(raw string) *http.Response {
+		conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = io.WriteString(conn, raw)
+		require.NoError(t, err)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body = io.NopCloser(strings.NewReader(string(body)))
+		return resp
+	}
+
+	send("POST /greet?name=x HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc")
+	send("get / HTTP/1.1\r\n\r\n")
+	send("BREW /pot-1 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	send("BREW /pot-2 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	resp := send("GET /metrics HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, ContentType, resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	out := string(body)
+
+	assert.Contains(t, out, `http_requests_total{method="POST",route="/greet",status="200"} 1`)
+	assert.Contains(t, out, `http_request_size_bytes_bucket{method="POST",route="/greet",le="100"} 1`)
+	assert.Contains(t, out, `http_request_duration_seconds_count{method="POST",route="/greet"} 1`)
+	assert.Contains(t, out, `http_parse_errors_total{kind="malformed"} 1`)
+	// unknown methods and routes share a series
+	assert.Contains(t, out, `http_requests_total{method="OTHER",route="other",status="200"} 2`)
+	assert.NotContains(t, out, "pot-")
+	// the scraping connection is still open
+	assert.Regexp(t, `http_active_connections [1-3]\n`, out)
+	assert.Regexp(t, `http_read_bytes_total [1-9]`, out)
+	assert.Regexp(t, `http_written_bytes_total [1-9]`, out)
+
+	assert.Eventually(t, func() bool {
+		return strings.Contains(exposition(t, r), "http_active_connections 0\n")
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// Compress compresses eligible response bodies with the content coding
+// negotiated from the request's Accept-Encoding header
+func Compress(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method != "HEAD" {
+			acceptEncoding, _ := req.Headers.Get("Accept-Encoding")
+			w.EnableCompression(acceptEncoding)
+		}
+		next(w, req)
+	}
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// CORSOptions configures the CORS middleware
+type CORSOptions struct {
+	// AllowedOrigins lists the origins allowed to call the server. "*"
+	// allows any origin, and a "*." after the scheme allows any subdomain,
+	// as in "https://*.example.com"
+	AllowedOrigins []string
+	// AllowedOriginPatterns allows the origins matching any of the
+	// expressions, which should be anchored
+	AllowedOriginPatterns []*regexp.Regexp
+	// AllowedMethods defaults to GET, HEAD and POST
+	AllowedMethods []string
+	// AllowedHeaders lists the request headers a preflight may ask for, "*"
+	// allows any
+	AllowedHeaders []string
+	// ExposedHeaders lists the response headers scripts may read besides the
+	// CORS-safelisted ones
+	ExposedHeaders []string
+	// AllowCredentials lets browsers send cookies and authorization. The
+	// origin is then echoed instead of answering "*", so it cannot be
+	// combined with an AllowedOrigins of "*"
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache preflight results, omitted
+	// when zero
+	MaxAge time.Duration
+}
+
+var defaultCORSMethods = []string{"GET", "HEAD", "POST"}
+
+// CORS implements Cross-Origin Resource Sharing (Fetch standard section 3.2).
+// Preflight requests are answered with 204 without calling the handler,
+// and other requests from allowed origins get the Access-Control headers
+// added to their response. It panics when credentials are allowed for any
+// origin, which would let every site act with the cookies of the user
+func CORS(opts CORSOptions) server.Middleware {
+	if opts.AllowCredentials && slices.Contains(opts.AllowedOrigins, "*") {
+		panic(`middleware: CORS cannot allow credentials for the "*" origin`)
+	}
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = defaultCORSMethods
+	}
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			origin, hasOrigin := req.Headers.Get("Origin")
+			requestMethod, isPreflight := req.Headers.Get("Access-Control-Request-Method")
+			if hasOrigin && isPreflight && req.RequestLine.Method == "OPTIONS" {
+				preflight(w, req, &opts, origin, requestMethod)
+				return
+			}
+
+			w.BeforeWriteHeaders(func(_ response.StatusCode, h headers.Headers) {
+				// the response depends on the origin even when it is not
+				// allowed, so caches must not share it across origins
+				h.Put("Vary", "Origin")
+				if !hasOrigin || !opts.originAllowed(origin) {
+					return
+				}
+				opts.allowOrigin(h, origin)
+				if len(opts.ExposedHeaders) > 0 {
+					h.Put("Access-Control-Expose-Headers", strings.Join(opts.ExposedHeaders, ", "))
+				}
+			})
+			next(w, req)
+		}
+	}
+}
+
+func preflight(w *response.Writer, req *request.Request, opts *CORSOptions, origin, method string) {
+	header := headers.NewHeaders()
+	header.Put("Connection", "close")
+	header.Put("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+
+	requested := requestedHeaders(req)
+	// a rejected preflight gets no Access-Control headers, which makes the
+	// browser fail the actual request
+	if opts.originAllowed(origin) && slices.Contains(opts.AllowedMethods, method) && opts.headersAllowed(requested) {
+		opts.allowOrigin(header, origin)
+		header.Put("Access-Control-Allow-Methods", strings.Join(opts.AllowedMethods, ", "))
+		if len(requested) > 0 {
+			header.Put("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+		}
+		if opts.MaxAge > 0 {
+			header.Put("Access-Control-Max-Age", strconv.Itoa(int(opts.MaxAge/time.Second)))
+		}
+	}
+
+	w.WriteStatusLine(response.StatusNoContent)
+	w.WriteHeaders(header)
+	w.WriteBody("")
+}
+
+func (opts *CORSOptions) allowOrigin(h headers.Headers, origin string) {
+	if opts.AllowCredentials {
+		h.Put("Access-Control-Allow-Origin", origin)
+		h.Put("Access-Control-Allow-Credentials", "true")
+	} else if slices.Contains(opts.AllowedOrigins, "*") {
+		h.Put("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Put("Access-Control-Allow-Origin", origin)
+	}
+}
+
+func (opts *CORSOptions) originAllowed(origin string) bool {
+	for _, allowed := range opts.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		scheme, host, found := strings.Cut(allowed, "://*.")
+		if found {
+			originScheme, originHost, _ := strings.Cut(origin, "://")
+			if strings.EqualFold(scheme, originScheme) && strings.HasSuffix(strings.ToLower(originHost), "."+strings.ToLower(host)) {
+				return true
+			}
+		}
+	}
+	for _, pattern := range opts.AllowedOriginPatterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (opts *CORSOptions) headersAllowed(requested []string) bool {
+	if slices.Contains(opts.AllowedHeaders, "*") {
+		return true
+	}
+	for _, name := range requested {
+		allowed := slices.ContainsFunc(opts.AllowedHeaders, func(allowed string) bool {
+			return strings.EqualFold(allowed, name)
+		})
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// requestedHeaders returns the lowercased names of
+// Access-Control-Request-Headers
+func requestedHeaders(req *request.Request) []string {
+	value, isPresent := req.Headers.Get("Access-Control-Request-Headers")
+	if !isPresent {
+		return nil
+	}
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
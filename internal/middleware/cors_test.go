@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"bufio"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveCORS(t *testing.T, handler server.Handler, method string, fields ...string) *http.Response {
+	t.Helper()
+	raw := method + " /api HTTP/1.1\r\nHost: api.example.com\r\n"
+	for _, field := range fields {
+		raw += field + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	w := response.NewWriter()
+	handler(&w, req)
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(w.ReadBuffer())), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestCORS(t *testing.T) {
+	called := false
+	handler := CORS(CORSOptions{
+		AllowedOrigins:        []string{"https://app.example.com", "https://*.example.org"},
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`^http://localhost:\d+$`)},
+		AllowedMethods:        []string{"GET", "PUT"},
+		AllowedHeaders:        []string{"Content-Type", "X-Request-Id"},
+		ExposedHeaders:        []string{"X-Total-Count"},
+		AllowCredentials:      true,
+		MaxAge:                10 * time.Minute,
+	})(func(w *response.Writer, req *request.Request) {
+		called = true
+		w.WriteResponse(response.StatusOk, "ok")
+	})
+
+	t.Run("preflight", func(t *testing.T) {
+		called = false
+		resp := serveCORS(t, handler, "OPTIONS",
+			"Origin: https://app.example.com",
+			"Access-Control-Request-Method: PUT",
+			"Access-Control-Request-Headers: content-type, X-Request-Id")
+		assert.False(t, called)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "GET, PUT", resp.Header.Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "content-type, x-request-id", resp.Header.Get("Access-Control-Allow-Headers"))
+		assert.Equal(t, "600", resp.Header.Get("Access-Control-Max-Age"))
+		assert.Contains(t, resp.Header.Get("Vary"), "Origin")
+		assert.Empty(t, resp.Header.Get("Content-Length"))
+	})
+
+	rejected := map[string][]string{
+		"origin": {"Origin: https://evil.example.com", "Access-Control-Request-Method: GET"},
+		"method": {"Origin: https://app.example.com", "Access-Control-Request-Method: DELETE"},
+		"header": {"Origin: https://app.example.com", "Access-Control-Request-Method: GET",
+			"Access-Control-Request-Headers: X-Secret"},
+	}
+	for name, fields := range rejected {
+		t.Run("preflight with rejected "+name, func(t *testing.T) {
+			resp := serveCORS(t, handler, "OPTIONS", fields...)
+			assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+			assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+		})
+	}
+
+	for _, origin := range []string{"https://app.example.com", "https://eu.api.example.org", "http://localhost:3000"} {
+		t.Run("request from "+origin, func(t *testing.T) {
+			resp := serveCORS(t, handler, "GET", "Origin: "+origin)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, origin, resp.Header.Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, "X-Total-Count", resp.Header.Get("Access-Control-Expose-Headers"))
+			assert.Equal(t, "Origin", resp.Header.Get("Vary"))
+		})
+	}
+
+	for _, origin := range []string{"https://example.org", "http://eu.example.org", "http://localhost:3000.evil.com"} {
+		resp := serveCORS(t, handler, "GET", "Origin: "+origin)
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"), origin)
+		assert.Equal(t, "Origin", resp.Header.Get("Vary"))
+	}
+
+	// OPTIONS without a request method is not a preflight
+	called = false
+	serveCORS(t, handler, "OPTIONS", "Origin: https://app.example.com")
+	assert.True(t, called)
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	handler := CORS(CORSOptions{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}})(
+		func(w *response.Writer, req *request.Request) {
+			w.WriteResponse(response.StatusOk, "ok")
+		})
+
+	resp := serveCORS(t, handler, "GET", "Origin: https://anywhere.test")
+	assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Credentials"))
+
+	resp = serveCORS(t, handler, "OPTIONS", "Origin: https://anywhere.test",
+		"Access-Control-Request-Method: POST", "Access-Control-Request-Headers: X-Anything")
+	assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, HEAD, POST", resp.Header.Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "x-anything", resp.Header.Get("Access-Control-Allow-Headers"))
+}
+
+func TestCORSAnyOriginWithCredentials(t *testing.T) {
+	assert.Panics(t, func() {
+		CORS(CORSOptions{AllowedOrigins: []string{"https://app.example.com", "*"}, AllowCredentials: true})
+	})
+}
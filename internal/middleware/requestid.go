@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// RequestIDHeader carries the ID of a request from the client or a proxy in
+// front of the server, and back in the response
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength bounds the IDs accepted from clients
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key of the ID of a request
+type requestIDKey struct{}
+
+// RequestID gives every request an ID, kept in its context and sent back in
+// the X-Request-Id header of the response. The ID of the request header is
+// reused when valid, otherwise a random one is generated and set on the
+// request so that proxies pass it upstream
+func RequestID(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		id, isPresent := req.Headers.Get(RequestIDHeader)
+		if !isPresent || !validRequestID(id) {
+			id = newRequestID()
+			req.Headers.Remove(RequestIDHeader)
+			req.Headers.Put(RequestIDHeader, id)
+		}
+
+		w.BeforeWriteHeaders(func(_ response.StatusCode, h headers.Headers) {
+			h.Remove(RequestIDHeader)
+			h.Put(RequestIDHeader, id)
+		})
+		next(w, req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id)))
+	}
+}
+
+// RequestIDOf returns the ID given to a request by the RequestID
+// middleware, or "" for other requests
+func RequestIDOf(req *request.Request) string {
+	return RequestIDFromContext(req.Context())
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID accepts short IDs of visible ASCII characters, which are
+// safe to log and to send back
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
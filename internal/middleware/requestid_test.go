@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen, forwarded string
+	handler := RequestID(func(w *response.Writer, req *request.Request) {
+		seen = RequestIDOf(req)
+		forwarded, _ = req.Headers.Get(RequestIDHeader)
+		w.WriteResponse(response.StatusOk, "ok")
+	})
+	serve := func(raw string) *http.Response {
+		req, err := request.RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		w := response.NewWriter()
+		handler(&w, req)
+		resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(w.ReadBuffer())), nil)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Test: Valid incoming ID is kept
+	resp := serve("GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Request-Id: abc-123\r\n\r\n")
+	assert.Equal(t, "abc-123", seen)
+	assert.Equal(t, "abc-123", resp.Header.Get("X-Request-Id"))
+
+	// Test: Missing ID is generated and set on the request
+	resp = serve("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Len(t, seen, 32)
+	assert.Equal(t, seen, forwarded)
+	assert.Equal(t, seen, resp.Header.Get("X-Request-Id"))
+
+	// Test: Invalid ID is replaced
+	resp = serve("GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Request-Id: " + strings.Repeat("a", 200) + "\r\n\r\n")
+	assert.Len(t, seen, 32)
+	assert.Equal(t, []string{seen}, resp.Header.Values("X-Request-Id"))
+
+	// Test: Requests outside the middleware have no ID
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Empty(t, RequestIDOf(req))
+}
@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+)
+
+type Strategy int
+
+const (
+	RoundRobin Strategy = iota
+	LeastConnections
+	// ConsistentHash sends requests with the same key to the same backend.
+	// The key is the value of PoolConfig.HashHeader, or the client IP when
+	// no header is configured or the request does not carry it
+	ConsistentHash
+)
+
+const virtualNodesPerBackend = 100
+
+var ErrNoBackend = errors.New("No healthy upstream available")
+
+type PoolConfig struct {
+	Strategy   Strategy
+	HashHeader string
+
+	// HealthCheckPath enables active health checks when set. Every
+	// HealthCheckInterval a GET request is sent to each backend and any
+	// response other than 2xx or 3xx marks it as unhealthy
+	HealthCheckPath     string
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+
+	// MaxFailures consecutive failed requests eject a backend for
+	// EjectionDuration. Zero disables passive ejection
+	MaxFailures      int
+	EjectionDuration time.Duration
+
+	// MaxRetries is the number of other backends an idempotent request is
+	// retried on when the upstream cannot be reached
+	MaxRetries int
+}
+
+type Backend struct {
+	Addr string
+
+	healthy      atomic.Bool
+	active       atomic.Int64
+	failures     atomic.Int32
+	ejectedUntil atomic.Int64
+}
+
+// Available reports whether the backend passed its last health check and is
+// not ejected
+func (b *Backend) Available() bool {
+	return b.healthy.Load() && time.Now().UnixNano() >= b.ejectedUntil.Load()
+}
+
+// ActiveConnections returns the number of requests currently forwarded to
+// the backend
+func (b *Backend) ActiveConnections() int64 {
+	return b.active.Load()
+}
+
+type ringNode struct {
+	hash    uint32
+	backend *Backend
+}
+
+type Pool struct {
+	backends []*Backend
+	config   PoolConfig
+	ring     []ringNode
+	next     atomic.Uint64
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func NewPool(addrs []string, config PoolConfig) *Pool {
+	if config.HealthCheckInterval == 0 {
+		config.HealthCheckInterval = 10 * time.Second
+	}
+	if config.HealthCheckTimeout == 0 {
+		config.HealthCheckTimeout = 2 * time.Second
+	}
+	if config.EjectionDuration == 0 {
+		config.EjectionDuration = 30 * time.Second
+	}
+
+	pool := &Pool{
+		config: config,
+		done:   make(chan struct{}),
+	}
+	for _, addr := range addrs {
+		backend := &Backend{Addr: addr}
+		backend.healthy.Store(true)
+		pool.backends = append(pool.backends, backend)
+
+		for i := 0; i < virtualNodesPerBackend; i++ {
+			pool.ring = append(pool.ring, ringNode{
+				hash:    crc32.ChecksumIEEE([]byte(addr + "#" + strconv.Itoa(i))),
+				backend: backend,
+			})
+		}
+	}
+	sort.Slice(pool.ring, func(i, j int) bool { return pool.ring[i].hash < pool.ring[j].hash })
+
+	if config.HealthCheckPath != "" {
+		go pool.healthCheckLoop()
+	}
+	return pool
+}
+
+func (p *Pool) Backends() []*Backend {
+	return p.backends
+}
+
+// Close stops the active health checks
+func (p *Pool) Close() {
+	p.closeOnce.Do(func() { close(p.done) })
+}
+
+// Pick selects a backend for the request according to the pool strategy,
+// skipping unavailable backends and the ones in exclude. It returns nil when
+// no backend can be used
+func (p *Pool) Pick(req *request.Request, exclude map[*Backend]bool) *Backend {
+	usable := func(b *Backend) bool {
+		return b.Available() && !exclude[b]
+	}
+
+	switch p.config.Strategy {
+	case LeastConnections:
+		var best *Backend
+		start := int(p.next.Add(1))
+		for i := range p.backends {
+			b := p.backends[(start+i)%len(p.backends)]
+			if usable(b) && (best == nil || b.active.Load() < best.active.Load()) {
+				best = b
+			}
+		}
+		return best
+
+	case ConsistentHash:
+		if len(p.ring) == 0 {
+			return nil
+		}
+		hash := crc32.ChecksumIEEE([]byte(p.hashKey(req)))
+		start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= hash })
+		for i := range p.ring {
+			node := p.ring[(start+i)%len(p.ring)]
+			if usable(node.backend) {
+				return node.backend
+			}
+		}
+		return nil
+
+	default:
+		for range p.backends {
+			b := p.backends[int(p.next.Add(1)-1)%len(p.backends)]
+			if usable(b) {
+				return b
+			}
+		}
+		return nil
+	}
+}
+
+func (p *Pool) hashKey(req *request.Request) string {
+	if p.config.HashHeader != "" {
+		if value, isPresent := req.Headers.Get(p.config.HashHeader); isPresent {
+			return value
+		}
+	}
+	return clientIP(req)
+}
+
+func (p *Pool) reportSuccess(b *Backend) {
+	b.failures.Store(0)
+}
+
+func (p *Pool) reportFailure(b *Backend) {
+	if p.config.MaxFailures <= 0 {
+		return
+	}
+	if int(b.failures.Add(1)) >= p.config.MaxFailures {
+		b.ejectedUntil.Store(time.Now().Add(p.config.EjectionDuration).UnixNano())
+		b.failures.Store(0)
+	}
+}
+
+func (p *Pool) healthCheckLoop() {
+	p.checkAll()
+	ticker := time.NewTicker(p.config.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.checkAll()
+		}
+	}
+}
+
+func (p *Pool) checkAll() {
+	var wg sync.WaitGroup
+	for _, b := range p.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// a passing check does not lift a passive ejection, which lasts
+			// for EjectionDuration
+			b.healthy.Store(p.check(b))
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Pool) check(b *Backend) bool {
+	h := headers.NewHeaders()
+	h.Put("Host", b.Addr)
+	h.Put("Connection", "close")
+	req := &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        "GET",
+			RequestTarget: p.config.HealthCheckPath,
+			HttpVersion:   "1.1",
+		},
+		Headers: h,
+	}
+
+	resp, conn, err := roundTrip(b.Addr, nil, req, p.config.HealthCheckTimeout, p.config.HealthCheckTimeout, nil)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(p.config.HealthCheckTimeout))
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
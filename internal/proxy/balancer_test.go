@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func namedUpstream(t *testing.T, name string) string {
+	t.Helper()
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/health" && name == "sick" {
+			w.WriteResponse(response.StatusInternalServerError, "unhealthy")
+			return
+		}
+		w.WriteResponse(response.StatusOk, name)
+	})
+	return s.Addr().String()
+}
+
+func deadUpstream(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+func fetchBody(t *testing.T, addr net.Addr, raw string) (int, string) {
+	t.Helper()
+	resp := doRequest(t, addr, raw)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestRoundRobin(t *testing.T) {
+	pool := NewPool([]string{namedUpstream(t, "a"), namedUpstream(t, "b"), namedUpstream(t, "c")}, PoolConfig{})
+	proxy := startServer(t, NewReverseProxy(Route{Prefix: "/", Pool: pool}).Handle)
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		_, body := fetchBody(t, proxy.Addr(), "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+		got = append(got, body)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, got)
+}
+
+func TestLeastConnections(t *testing.T) {
+	pool := NewPool([]string{"a:1", "b:1", "c:1"}, PoolConfig{Strategy: LeastConnections})
+	backends := pool.Backends()
+	backends[0].active.Store(3)
+	backends[1].active.Store(1)
+	backends[2].active.Store(2)
+
+	req := &request.Request{}
+	assert.Equal(t, backends[1], pool.Pick(req, nil))
+	assert.Equal(t, backends[2], pool.Pick(req, map[*Backend]bool{backends[1]: true}))
+}
+
+func TestConsistentHash(t *testing.T) {
+	pool := NewPool([]string{"a:1", "b:1", "c:1", "d:1"}, PoolConfig{Strategy: ConsistentHash, HashHeader: "X-User"})
+
+	picked := make(map[string]*Backend)
+	for _, user := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nX-User: " + user + "\r\n\r\n"))
+		require.NoError(t, err)
+		picked[user] = pool.Pick(req, nil)
+		require.NotNil(t, picked[user])
+
+		// Test: Same key always maps to the same backend
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, picked[user], pool.Pick(req, nil))
+		}
+	}
+
+	// Test: Client IP is used when the header is missing
+	req := &request.Request{RemoteAddr: "10.1.2.3:5555"}
+	other := &request.Request{RemoteAddr: "10.1.2.3:6666"}
+	assert.Equal(t, pool.Pick(req, nil), pool.Pick(other, nil))
+
+	// Test: Keys of an ejected backend move, others stay
+	ejected := picked["alice"]
+	ejected.ejectedUntil.Store(time.Now().Add(time.Minute).UnixNano())
+	for user, backend := range picked {
+		req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nX-User: " + user + "\r\n\r\n"))
+		require.NoError(t, err)
+		if backend == ejected {
+			assert.NotEqual(t, ejected, pool.Pick(req, nil))
+		} else {
+			assert.Equal(t, backend, pool.Pick(req, nil))
+		}
+	}
+}
+
+func TestPassiveEjectionAndRetries(t *testing.T) {
+	dead := deadUpstream(t)
+	pool := NewPool([]string{dead, namedUpstream(t, "alive")}, PoolConfig{
+		MaxFailures: 2,
+		MaxRetries:  1,
+	})
+	proxy := startServer(t, NewReverseProxy(Route{Prefix: "/", Pool: pool}).Handle)
+
+	// Test: Idempotent requests are retried on the next backend
+	for i := 0; i < 4; i++ {
+		status, body := fetchBody(t, proxy.Addr(), "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+		assert.Equal(t, 200, status)
+		assert.Equal(t, "alive", body)
+	}
+
+	// Test: The failing backend is ejected after consecutive failures
+	assert.False(t, pool.Backends()[0].Available())
+	assert.True(t, pool.Backends()[1].Available())
+
+	// Test: Non idempotent requests are not retried
+	pool = NewPool([]string{dead, namedUpstream(t, "alive")}, PoolConfig{MaxRetries: 1})
+	proxy = startServer(t, NewReverseProxy(Route{Prefix: "/", Pool: pool}).Handle)
+	status, _ := fetchBody(t, proxy.Addr(), "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 2\r\n\r\nhi")
+	assert.Equal(t, 502, status)
+
+	// Test: No usable backend left
+	pool = NewPool([]string{dead}, PoolConfig{MaxFailures: 1})
+	proxy = startServer(t, NewReverseProxy(Route{Prefix: "/", Pool: pool}).Handle)
+	status, _ = fetchBody(t, proxy.Addr(), "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, 502, status)
+	status, _ = fetchBody(t, proxy.Addr(), "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, 503, status)
+}
+
+func TestActiveHealthCheck(t *testing.T) {
+	pool := NewPool([]string{namedUpstream(t, "sick"), namedUpstream(t, "well"), deadUpstream(t)}, PoolConfig{
+		HealthCheckPath:     "/health",
+		HealthCheckInterval: 20 * time.Millisecond,
+	})
+	defer pool.Close()
+
+	require.Eventually(t, func() bool {
+		backends := pool.Backends()
+		return !backends[0].Available() && backends[1].Available() && !backends[2].Available()
+	}, time.Second, 10*time.Millisecond)
+
+	proxy := startServer(t, NewReverseProxy(Route{Prefix: "/", Pool: pool}).Handle)
+	for i := 0; i < 3; i++ {
+		_, body := fetchBody(t, proxy.Addr(), "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+		assert.Equal(t, "well", body)
+	}
+}
+
+func TestHealthCheckKeepsEjection(t *testing.T) {
+	pool := NewPool([]string{namedUpstream(t, "well")}, PoolConfig{
+		MaxFailures:         1,
+		EjectionDuration:    200 * time.Millisecond,
+		HealthCheckPath:     "/health",
+		HealthCheckInterval: 10 * time.Millisecond,
+	})
+	defer pool.Close()
+	backend := pool.Backends()[0]
+	require.Eventually(t, backend.Available, time.Second, 5*time.Millisecond)
+
+	// Test: Passing health checks do not end an ejection early
+	pool.reportFailure(backend)
+	assert.Never(t, backend.Available, 100*time.Millisecond, 5*time.Millisecond)
+
+	// Test: The backend is back once the ejection expires
+	assert.Eventually(t, backend.Available, time.Second, 5*time.Millisecond)
+}
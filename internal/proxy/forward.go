@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+// ForwardProxy is an explicit HTTP proxy. Plain HTTP requests arrive with an
+// absolute-form target and are forwarded to the origin server, CONNECT
+// requests open a TCP tunnel to the requested host:port
+type ForwardProxy struct {
+	// AllowedHosts restricts the hosts that can be reached. Entries match
+	// the host exactly, or any subdomain when they start with "*.". Every
+	// host is allowed when empty
+	AllowedHosts []string
+	// AllowedPorts restricts the ports that can be reached, every port is
+	// allowed when empty
+	AllowedPorts []int
+	// Credentials maps user names to passwords required in the
+	// Proxy-Authorization header. Authentication is disabled when empty
+	Credentials map[string]string
+	// Realm is announced in the Proxy-Authenticate challenge
+	Realm                 string
+	DialTimeout           time.Duration
+	ResponseHeaderTimeout time.Duration
+}
+
+func NewForwardProxy() *ForwardProxy {
+	return &ForwardProxy{
+		Realm:                 "proxy",
+		DialTimeout:           DefaultDialTimeout,
+		ResponseHeaderTimeout: DefaultResponseHeaderTimeout,
+	}
+}
+
+// Handle proxies the request to the origin named in its target. It can be
+// passed to server.Serve as a server.Handler
+func (p *ForwardProxy) Handle(w *response.Writer, req *request.Request) {
+	if !p.authorized(req) {
+		msg := "Proxy authentication required"
+		header := response.GetDefaultHeader(len(msg))
+		header.Put("Proxy-Authenticate", fmt.Sprintf("Basic realm=%q", p.Realm))
+		w.WriteStatusLine(response.StatusProxyAuthRequired)
+		w.WriteHeaders(header)
+		w.WriteBody(msg)
+		return
+	}
+
+	if req.RequestLine.Method == "CONNECT" {
+		p.tunnel(w, req)
+		return
+	}
+
+	target, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil || !target.IsAbs() || target.Host == "" {
+		w.WriteResponse(response.StatusBadRequest, "Proxy requests need an absolute-form target")
+		return
+	}
+	if target.Scheme != "http" {
+		w.WriteResponse(response.StatusBadRequest, "Unsupported scheme "+target.Scheme)
+		return
+	}
+
+	host, port := target.Hostname(), target.Port()
+	if port == "" {
+		port = "80"
+	}
+	if !p.allowed(host, port) {
+		w.WriteResponse(response.StatusForbidden, "Destination not allowed")
+		return
+	}
+
+	outReq := p.outboundRequest(target, req)
+	span := startClientSpan(req, outReq)
+	defer span.Finish()
+	resp, conn, err := roundTrip(net.JoinHostPort(host, port), nil, outReq, p.DialTimeout, p.ResponseHeaderTimeout, func(resp *http.Response) {
+		writeInterim(w, resp)
+	})
+	if err != nil {
+		span.SetAttribute("error", err.Error())
+		writeUpstreamError(w, err)
+		return
+	}
+	span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+	defer conn.Close()
+	defer resp.Body.Close()
+
+	copyResponse(w, resp)
+}
+
+// tunnel answers a CONNECT request and splices the client connection to the
+// destination until either side closes its end
+func (p *ForwardProxy) tunnel(w *response.Writer, req *request.Request) {
+	host, port, err := net.SplitHostPort(req.RequestLine.RequestTarget)
+	if err != nil || host == "" {
+		w.WriteResponse(response.StatusBadRequest, "CONNECT needs a host:port target")
+		return
+	}
+	if !p.allowed(host, port) {
+		w.WriteResponse(response.StatusForbidden, "Destination not allowed")
+		return
+	}
+
+	dialer := net.Dialer{Timeout: p.DialTimeout}
+	upstream, err := dialer.DialContext(req.Context(), "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	defer upstream.Close()
+
+	// a 2xx answer to CONNECT has no body and carries no framing headers
+	w.WriteStatusLineReason(response.StatusOk, "Connection Established")
+	w.WriteHeaders(headers.NewHeaders())
+	client, buffered, err := w.Hijack()
+	if err != nil {
+		return
+	}
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		splice(upstream, io.MultiReader(bytes.NewReader(buffered), client))
+	}()
+	go func() {
+		defer wg.Done()
+		splice(client, upstream)
+	}()
+	wg.Wait()
+}
+
+// splice copies src to dst and half-closes dst once src is exhausted, so the
+// other direction of the tunnel keeps flowing
+func splice(dst net.Conn, src io.Reader) {
+	io.Copy(dst, src)
+	if tcpConn, ok := dst.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+		return
+	}
+	dst.Close()
+}
+
+// outboundRequest rewrites an absolute-form request into the origin-form
+// request sent to the origin server
+func (p *ForwardProxy) outboundRequest(target *url.URL, req *request.Request) *request.Request {
+	outReq := *req
+	outReq.Headers = req.Headers.Clone()
+	outReq.Trailers = headers.NewHeaders()
+	outReq.RequestLine.RequestTarget = target.RequestURI()
+
+	removeHopByHopHeaders(outReq.Headers)
+	removeLength(outReq.Headers, req)
+	outReq.Headers.Remove("Host")
+	outReq.Headers.Put("Host", target.Host)
+	outReq.Headers.Put("Via", "1.1 httpfromtcp")
+	addForwardedHeaders(outReq.Headers, req)
+	outReq.Headers.Put("Connection", "close")
+	return &outReq
+}
+
+// authorized checks the Basic credentials of the Proxy-Authorization header
+func (p *ForwardProxy) authorized(req *request.Request) bool {
+	if len(p.Credentials) == 0 {
+		return true
+	}
+	value, isPresent := req.Headers.Get("Proxy-Authorization")
+	if !isPresent {
+		return false
+	}
+	scheme, encoded, found := strings.Cut(strings.TrimSpace(value), " ")
+	if !found || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return false
+	}
+	user, password, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return false
+	}
+	expected, isPresent := p.Credentials[user]
+	if !isPresent {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+}
+
+// allowed checks the destination against the host and port allow-lists
+func (p *ForwardProxy) allowed(host, port string) bool {
+	if len(p.AllowedPorts) > 0 {
+		portNumber, err := strconv.Atoi(port)
+		if err != nil || !containsPort(p.AllowedPorts, portNumber) {
+			return false
+		}
+	}
+	if len(p.AllowedHosts) == 0 {
+		return true
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.AllowedHosts {
+		pattern = strings.ToLower(pattern)
+		if suffix, isWildcard := strings.CutPrefix(pattern, "*."); isWildcard {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, candidate := range ports {
+		if candidate == port {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoListener(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+func portOf(t *testing.T, addr net.Addr) string {
+	t.Helper()
+	_, port, err := net.SplitHostPort(addr.String())
+	require.NoError(t, err)
+	return port
+}
+
+func TestForwardProxy(t *testing.T) {
+	upstream := startServer(t, upstreamHandler)
+	upstreamHost := net.JoinHostPort("127.0.0.1", portOf(t, upstream.Addr()))
+	p := startServer(t, NewForwardProxy().Handle)
+
+	// Test: Absolute-form target is forwarded in origin-form
+	resp := doRequest(t, p.Addr(), "GET http://"+upstreamHost+"/coffee?cup=1 HTTP/1.1\r\n"+
+		"Host: "+upstreamHost+"\r\n"+
+		"Proxy-Connection: keep-alive\r\n\r\n")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Contains(t, string(body), "GET /coffee?cup=1\n")
+	assert.Contains(t, string(body), "xff=127.0.0.1\n")
+
+	// Test: Origin-form target is rejected
+	resp = doRequest(t, p.Addr(), "GET /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 400, resp.StatusCode)
+
+	// Test: Unreachable origin
+	resp = doRequest(t, p.Addr(), "GET http://127.0.0.1:1/ HTTP/1.1\r\nHost: 127.0.0.1:1\r\n\r\n")
+	assert.Equal(t, 502, resp.StatusCode)
+}
+
+func TestForwardProxyConnect(t *testing.T) {
+	echo := echoListener(t)
+	echoHost := net.JoinHostPort("127.0.0.1", portOf(t, echo.Addr()))
+	p := startServer(t, NewForwardProxy().Handle)
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", portOf(t, p.Addr())))
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// bytes sent right behind the CONNECT request must reach the tunnel
+	_, err = io.WriteString(conn, "CONNECT "+echoHost+" HTTP/1.1\r\nHost: "+echoHost+"\r\n\r\nearly ")
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: "CONNECT"})
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "200 Connection Established", resp.Status)
+
+	_, err = io.WriteString(conn, "bird")
+	require.NoError(t, err)
+	conn.(*net.TCPConn).CloseWrite()
+
+	// the echo server closes its side once it sees the end of the stream,
+	// which the tunnel passes back to the client
+	echoed, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "early bird", string(echoed))
+}
+
+func TestForwardProxyPolicy(t *testing.T) {
+	echo := echoListener(t)
+	echoPort := portOf(t, echo.Addr())
+	port, err := strconv.Atoi(echoPort)
+	require.NoError(t, err)
+
+	fp := NewForwardProxy()
+	fp.AllowedHosts = []string{"localhost", "*.example.com"}
+	fp.AllowedPorts = []int{port}
+	fp.Credentials = map[string]string{"alice": "secret"}
+	p := startServer(t, fp.Handle)
+
+	auth := "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte("alice:secret")) + "\r\n"
+
+	// Test: Missing credentials
+	resp := doRequest(t, p.Addr(), "CONNECT localhost:"+echoPort+" HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 407, resp.StatusCode)
+	assert.Equal(t, `Basic realm="proxy"`, resp.Header.Get("Proxy-Authenticate"))
+
+	// Test: Wrong password
+	wrong := "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte("alice:guess")) + "\r\n"
+	resp = doRequest(t, p.Addr(), "CONNECT localhost:"+echoPort+" HTTP/1.1\r\n"+wrong+"\r\n")
+	assert.Equal(t, 407, resp.StatusCode)
+
+	// Test: Host not on the allow-list
+	resp = doRequest(t, p.Addr(), "CONNECT 127.0.0.1:"+echoPort+" HTTP/1.1\r\n"+auth+"\r\n")
+	assert.Equal(t, 403, resp.StatusCode)
+
+	// Test: Port not on the allow-list
+	resp = doRequest(t, p.Addr(), "GET http://api.example.com:25/ HTTP/1.1\r\n"+auth+"\r\n")
+	assert.Equal(t, 403, resp.StatusCode)
+
+	// Test: Allowed destination with valid credentials
+	resp = doRequest(t, p.Addr(), "CONNECT localhost:"+echoPort+" HTTP/1.1\r\n"+auth+"\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestForwardProxyAllowed(t *testing.T) {
+	fp := &ForwardProxy{AllowedHosts: []string{"Example.com", "*.internal"}}
+	assert.True(t, fp.allowed("example.com", "80"))
+	assert.True(t, fp.allowed("EXAMPLE.COM.", "443"))
+	assert.True(t, fp.allowed("db.internal", "5432"))
+	assert.False(t, fp.allowed("internal", "80"))
+	assert.False(t, fp.allowed("evil.com", "80"))
+	assert.False(t, fp.allowed("example.com.evil.com", "80"))
+	assert.False(t, fp.allowed("evilinternal", "80"))
+
+	// Test: Only a leading "*." makes a pattern a wildcard
+	fp = &ForwardProxy{AllowedHosts: []string{"*corp.com"}}
+	assert.False(t, fp.allowed("evilcorp.com", "80"))
+	assert.False(t, fp.allowed("db.corp.com", "80"))
+}
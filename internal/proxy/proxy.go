@@ -0,0 +1,495 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/tracing"
+)
+
+const (
+	DefaultDialTimeout           = 5 * time.Second
+	DefaultResponseHeaderTimeout = 30 * time.Second
+)
+
+// hopByHopHeaders only apply to a single connection and must not be
+// forwarded by a proxy (RFC 9110 section 7.6.1)
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type Route struct {
+	// Prefix is matched against the start of the request target
+	Prefix string
+	// Upstream is the host:port matching requests are forwarded to
+	Upstream string
+	// Pool balances matching requests across several upstreams and takes
+	// precedence over Upstream when set
+	Pool *Pool
+	// StripPrefix removes Prefix from the request target before forwarding
+	StripPrefix bool
+	// Host replaces the Host header sent upstream when set
+	Host string
+	// TLS, when set, makes the proxy dial Upstream over TLS. Without a
+	// ServerName, the server name is taken from Host or else from Upstream
+	TLS *tls.Config
+}
+
+// tlsConfig returns the TLS configuration used to dial the upstream, or nil
+// for plain connections
+func (r Route) tlsConfig() *tls.Config {
+	if r.TLS == nil || r.TLS.ServerName != "" {
+		return r.TLS
+	}
+	name := r.Host
+	if name == "" {
+		name = r.Upstream
+	}
+	if host, _, err := net.SplitHostPort(name); err == nil {
+		name = host
+	}
+	config := r.TLS.Clone()
+	config.ServerName = name
+	return config
+}
+
+type ReverseProxy struct {
+	Routes                []Route
+	DialTimeout           time.Duration
+	ResponseHeaderTimeout time.Duration
+}
+
+func NewReverseProxy(routes ...Route) *ReverseProxy {
+	return &ReverseProxy{
+		Routes:                routes,
+		DialTimeout:           DefaultDialTimeout,
+		ResponseHeaderTimeout: DefaultResponseHeaderTimeout,
+	}
+}
+
+// Handle forwards the request to the upstream of the longest matching route
+// and streams the upstream response back to the client. It can be passed to
+// server.Serve as a server.Handler
+func (p *ReverseProxy) Handle(w *response.Writer, req *request.Request) {
+	route, found := p.match(req.RequestLine.RequestTarget)
+	if !found {
+		w.WriteResponse(response.StatusNotFound, "No upstream for "+req.RequestLine.RequestTarget)
+		return
+	}
+
+	outReq := p.outboundRequest(route, req)
+	span := startClientSpan(req, outReq)
+	defer span.Finish()
+	resp, conn, release, err := p.forward(route, outReq, req, func(resp *http.Response) {
+		writeInterim(w, resp)
+	})
+	if err != nil {
+		span.SetAttribute("error", err.Error())
+		writeUpstreamError(w, err)
+		return
+	}
+	span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+	defer release()
+	defer conn.Close()
+	defer resp.Body.Close()
+
+	copyResponse(w, resp)
+}
+
+// forward sends the request to the route's upstream. With a pool, failed
+// idempotent requests are retried on another backend unless their body is
+// streamed. The returned release function must be called once the response
+// has been consumed
+func (p *ReverseProxy) forward(route Route, outReq, req *request.Request, interim func(*http.Response)) (*http.Response, net.Conn, func(), error) {
+	if route.Pool == nil {
+		resp, conn, err := roundTrip(route.Upstream, route.tlsConfig(), outReq, p.DialTimeout, p.ResponseHeaderTimeout, interim)
+		return resp, conn, func() {}, err
+	}
+
+	pool := route.Pool
+	tried := make(map[*Backend]bool)
+	var lastErr error
+	for attempt := 0; attempt <= pool.config.MaxRetries; attempt++ {
+		backend := pool.Pick(req, tried)
+		if backend == nil {
+			break
+		}
+		tried[backend] = true
+
+		backend.active.Add(1)
+		resp, conn, err := roundTrip(backend.Addr, nil, outReq, p.DialTimeout, p.ResponseHeaderTimeout, interim)
+		if err == nil {
+			pool.reportSuccess(backend)
+			return resp, conn, func() { backend.active.Add(-1) }, nil
+		}
+
+		backend.active.Add(-1)
+		pool.reportFailure(backend)
+		lastErr = err
+		// a streamed body was consumed by the failed attempt
+		if !isIdempotent(req.RequestLine.Method) || req.BodyStreamed() || req.Context().Err() != nil {
+			break
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = ErrNoBackend
+	}
+	return nil, nil, nil, lastErr
+}
+
+func (p *ReverseProxy) match(target string) (Route, bool) {
+	var best Route
+	found := false
+	for _, route := range p.Routes {
+		if strings.HasPrefix(target, route.Prefix) && (!found || len(route.Prefix) > len(best.Prefix)) {
+			best = route
+			found = true
+		}
+	}
+	return best, found
+}
+
+// outboundRequest builds the request sent upstream without modifying the
+// request handed to the handler
+func (p *ReverseProxy) outboundRequest(route Route, req *request.Request) *request.Request {
+	outReq := *req
+	outReq.Headers = req.Headers.Clone()
+	outReq.Trailers = headers.NewHeaders()
+
+	if route.StripPrefix {
+		target := strings.TrimPrefix(req.RequestLine.RequestTarget, route.Prefix)
+		if !strings.HasPrefix(target, "/") {
+			target = "/" + target
+		}
+		outReq.RequestLine.RequestTarget = target
+	}
+	if route.Host != "" {
+		outReq.Headers.Replace("host", route.Host)
+	}
+
+	removeHopByHopHeaders(outReq.Headers)
+	removeLength(outReq.Headers, req)
+	addForwardedHeaders(outReq.Headers, req)
+	outReq.Headers.Put("Connection", "close")
+	return &outReq
+}
+
+// startClientSpan starts the span of an outbound request when the incoming
+// request is traced, and sends its context upstream. Untraced requests keep
+// the traceparent they came with
+func startClientSpan(req, outReq *request.Request) *tracing.Span {
+	span := tracing.StartChild(req, "proxy "+outReq.RequestLine.Method, tracing.KindClient)
+	if span == nil {
+		return nil
+	}
+	span.SetAttribute("http.method", outReq.RequestLine.Method)
+	span.SetAttribute("http.target", outReq.RequestLine.RequestTarget)
+	if host, isPresent := outReq.Headers.Get("Host"); isPresent {
+		span.SetAttribute("net.peer", host)
+	}
+	tracing.Inject(outReq.Headers, span.SpanContext())
+	return span
+}
+
+// roundTrip sends the request to the upstream and reads the response head.
+// Interim 1xx responses are passed to interim, when set, until the final
+// one arrives. A streamed request body is sent while the response is read,
+// as the upstream may answer before it gets the whole body, or only start
+// receiving it after a 100 (Continue). The exchange is aborted when the
+// context of the request is done. The upstream is dialed over TLS when
+// tlsConfig is set. The caller is responsible for closing the returned
+// connection
+func roundTrip(upstream string, tlsConfig *tls.Config, req *request.Request, dialTimeout, headerTimeout time.Duration, interim func(*http.Response)) (*http.Response, net.Conn, error) {
+	ctx := req.Context()
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var conn net.Conn
+	var err error
+	if tlsConfig != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", upstream)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", upstream)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// closing the connection interrupts the exchange at any point, including
+	// while the caller copies the body
+	stop := context.AfterFunc(ctx, func() { abort(conn) })
+	fail := func(err error) (*http.Response, net.Conn, error) {
+		stop()
+		conn.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, nil, ctxErr
+		}
+		return nil, nil, err
+	}
+
+	// the request is framed, so the connection is not half-closed: some
+	// servers take a client closing its end for one that went away
+	var headRead func()
+	if req.BodyStreamed() {
+		headRead = sendStreamed(conn, req, headerTimeout)
+	} else {
+		if headerTimeout > 0 {
+			conn.SetDeadline(time.Now().Add(headerTimeout))
+		}
+		err = req.Write(conn)
+		if err != nil {
+			return fail(err)
+		}
+		headRead = func() { conn.SetDeadline(time.Time{}) }
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		resp, err := http.ReadResponse(reader, &http.Request{Method: req.RequestLine.Method})
+		if err != nil {
+			return fail(err)
+		}
+		if resp.StatusCode/100 == 1 && resp.StatusCode != http.StatusSwitchingProtocols {
+			if interim != nil {
+				interim(resp)
+			}
+			continue
+		}
+		headRead()
+		return resp, &upstreamConn{Conn: conn, stop: stop}, nil
+	}
+}
+
+// sendStreamed writes the request to the upstream in the background. The
+// header timeout starts once the body is sent, unless the response head
+// arrived first. The returned function must be called when it does
+func sendStreamed(conn net.Conn, req *request.Request, headerTimeout time.Duration) func() {
+	var mu sync.Mutex
+	headRead := false
+	go func() {
+		err := req.Write(conn)
+		mu.Lock()
+		defer mu.Unlock()
+		if headRead {
+			// the upstream answered without waiting for the whole body
+			return
+		}
+		if err != nil {
+			abort(conn)
+			return
+		}
+		if headerTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(headerTimeout))
+		}
+	}()
+
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		headRead = true
+		conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// abort closes an upstream connection with a reset, which the upstream
+// takes for a broken connection rather than for the end of a request
+func abort(conn net.Conn) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// upstreamConn stops watching the context of the request once closed
+type upstreamConn struct {
+	net.Conn
+	stop func() bool
+}
+
+func (c *upstreamConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+// copyResponse writes the upstream response to the client using chunked
+// framing so the body can be streamed as it arrives, followed by any trailers
+// the upstream sent
+func copyResponse(w *response.Writer, resp *http.Response) {
+	header := writeStatusLine(w, resp)
+	header.Put("Connection", "close")
+
+	if !hasBody(resp) {
+		// the Content-Length of HEAD and 304 responses is that of the
+		// representation they describe, 1xx and 204 responses have none
+		if resp.StatusCode/100 == 1 || resp.StatusCode == 204 {
+			header.Remove("Content-Length")
+		}
+		w.WriteHeaders(header)
+		w.WriteBody("")
+		return
+	}
+
+	header.Remove("Content-Length")
+	header.Put("Transfer-Encoding", "chunked")
+	for key := range resp.Trailer {
+		header.Put("Trailer", key)
+	}
+	w.WriteHeaders(header)
+	w.Flush()
+
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buffer)
+		if n > 0 {
+			_, writeErr := w.WriteChunkedBody(buffer[:n])
+			if writeErr != nil {
+				return
+			}
+			w.Flush()
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// the status line is already sent, leave the chunked body
+			// unterminated so the client sees the response is incomplete
+			return
+		}
+	}
+	w.WriteChunkedBodyDone()
+
+	trailer := headers.NewHeaders()
+	for key, values := range resp.Trailer {
+		for _, value := range values {
+			trailer.Put(key, value)
+		}
+	}
+	if len(trailer) > 0 {
+		w.WriteTrailers(trailer)
+	}
+}
+
+// writeInterim relays an interim 1xx response of the upstream, such as a
+// 100 (Continue) the client waits for before sending the body
+func writeInterim(w *response.Writer, resp *http.Response) {
+	header := writeStatusLine(w, resp)
+	w.WriteHeaders(header)
+	w.Flush()
+}
+
+// writeStatusLine writes the status line of the upstream response and
+// returns its headers without the hop-by-hop ones
+func writeStatusLine(w *response.Writer, resp *http.Response) headers.Headers {
+	reason := strings.TrimSpace(strings.TrimPrefix(resp.Status, fmt.Sprintf("%d", resp.StatusCode)))
+	w.WriteStatusLineReason(response.StatusCode(resp.StatusCode), reason)
+
+	header := headers.NewHeaders()
+	for key, values := range resp.Header {
+		for _, value := range values {
+			header.Put(key, value)
+		}
+	}
+	removeHopByHopHeaders(header)
+	return header
+}
+
+func hasBody(resp *http.Response) bool {
+	if resp.StatusCode/100 == 1 || resp.StatusCode == 204 || resp.StatusCode == 304 {
+		return false
+	}
+	return resp.Request == nil || resp.Request.Method != "HEAD"
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func writeUpstreamError(w *response.Writer, err error) {
+	if errors.Is(err, ErrNoBackend) {
+		w.WriteResponse(response.StatusServiceUnavailable, "No healthy upstream")
+		return
+	}
+	if isTimeout(err) {
+		w.WriteResponse(response.StatusGatewayTimeout, "Upstream timed out")
+		return
+	}
+	w.WriteResponse(response.StatusBadGateway, "Upstream unavailable")
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func removeHopByHopHeaders(h headers.Headers) {
+	if connection, isPresent := h.Get("Connection"); isPresent {
+		for _, name := range strings.Split(connection, ",") {
+			h.Remove(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		h.Remove(name)
+	}
+}
+
+// removeLength removes the Content-Length Write sets again from the body. A
+// streamed body keeps it, or is sent chunked when it came chunked
+func removeLength(h headers.Headers, req *request.Request) {
+	if !req.BodyStreamed() || req.IsChunked() {
+		h.Remove("Content-Length")
+	}
+}
+
+// clientIP returns the IP address of the client without the port
+func clientIP(req *request.Request) string {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// addForwardedHeaders appends the client to X-Forwarded-For and Forwarded
+func addForwardedHeaders(h headers.Headers, req *request.Request) {
+	clientIP := clientIP(req)
+	if clientIP == "" {
+		return
+	}
+	h.Put("X-Forwarded-For", clientIP)
+
+	forwarded := "for=" + clientIP
+	if strings.Contains(clientIP, ":") {
+		forwarded = fmt.Sprintf("for=\"[%s]\"", clientIP)
+	}
+	if host, isPresent := req.Headers.Get("Host"); isPresent {
+		forwarded += fmt.Sprintf(";host=%q", host)
+	}
+	forwarded += ";proto=http"
+	h.Put("Forwarded", forwarded)
+}
@@ -0,0 +1,442 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"httpfromtcp/internal/tracing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func upstreamHandler(w *response.Writer, req *request.Request) {
+	switch req.RequestLine.RequestTarget {
+	case "/trailers":
+		w.WriteStatusLine(response.StatusOk)
+		header := headers.NewHeaders()
+		header.Put("Transfer-Encoding", "chunked")
+		header.Put("Trailer", "X-Checksum")
+		w.WriteHeaders(header)
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.WriteChunkedBodyDone()
+		trailer := headers.NewHeaders()
+		trailer.Put("X-Checksum", "abc")
+		w.WriteTrailers(trailer)
+
+	case "/slow":
+		time.Sleep(500 * time.Millisecond)
+		w.WriteResponse(response.StatusOk, "too late")
+
+	default:
+		xff, _ := req.Headers.Get("X-Forwarded-For")
+		forwarded, _ := req.Headers.Get("Forwarded")
+		_, hasHopHeader := req.Headers.Get("X-Hop")
+		_, hasKeepAlive := req.Headers.Get("Keep-Alive")
+		body := fmt.Sprintf("%s %s\nxff=%s\nforwarded=%s\nhop=%t\nkeepalive=%t\nbody=%s",
+			req.RequestLine.Method, req.RequestLine.RequestTarget, xff, forwarded, hasHopHeader, hasKeepAlive, req.Body)
+
+		w.WriteStatusLineReason(201, "Brewed")
+		header := response.GetDefaultHeader(len(body))
+		header.Put("X-Upstream", "one")
+		header.Put("X-Upstream", "two")
+		w.WriteHeaders(header)
+		w.WriteBody(body)
+	}
+}
+
+func startServer(t *testing.T, handler server.Handler) *server.Server {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func doRequest(t *testing.T, addr net.Addr, raw string) *http.Response {
+	t.Helper()
+	_, port, err := net.SplitHostPort(addr.String())
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestReverseProxy(t *testing.T) {
+	upstream := startServer(t, upstreamHandler)
+	rp := NewReverseProxy(
+		Route{Prefix: "/", Upstream: upstream.Addr().String()},
+		Route{Prefix: "/api/", Upstream: upstream.Addr().String(), StripPrefix: true},
+	)
+	rp.ResponseHeaderTimeout = 200 * time.Millisecond
+	proxy := startServer(t, rp.Handle)
+
+	// Test: Request is forwarded with forwarding headers and without hop-by-hop headers
+	resp := doRequest(t, proxy.Addr(), "POST /api/coffee HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"Connection: keep-alive, X-Hop\r\n"+
+		"Keep-Alive: timeout=5\r\n"+
+		"X-Hop: 1\r\n"+
+		"X-Forwarded-For: 10.0.0.1\r\n"+
+		"Content-Length: 11\r\n"+
+		"\r\n"+
+		"dark roast!")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "201 Brewed", resp.Status)
+	assert.Equal(t, []string{"one, two"}, resp.Header.Values("X-Upstream"))
+	assert.Equal(t, "POST /coffee\n"+
+		"xff=10.0.0.1, 127.0.0.1\n"+
+		"forwarded=for=127.0.0.1;host=\"example.com\";proto=http\n"+
+		"hop=false\n"+
+		"keepalive=false\n"+
+		"body=dark roast!", string(body))
+
+	// Test: Body spanning several reads from a client keeping its side open
+	large := strings.Repeat("x", 3000)
+	resp = doRequest(t, proxy.Addr(), "POST /large HTTP/1.1\r\nHost: example.com\r\nContent-Length: 3000\r\n\r\n"+large)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.True(t, strings.HasSuffix(string(body), "body="+large))
+
+	// Test: Upstream trailers are propagated
+	resp = doRequest(t, proxy.Addr(), "GET /trailers HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+
+	// Test: Upstream that does not answer in time
+	resp = doRequest(t, proxy.Addr(), "GET /slow HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, 504, resp.StatusCode)
+}
+
+func TestReverseProxyUpstreamDown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	proxy := startServer(t, NewReverseProxy(Route{Prefix: "/", Upstream: addr}).Handle)
+	resp := doRequest(t, proxy.Addr(), "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, 502, resp.StatusCode)
+}
+
+// spanExporter passes the exported spans to the test
+type spanExporter chan *tracing.Span
+
+func (e spanExporter) Export(span *tracing.Span) error {
+	e <- span
+	return nil
+}
+
+func TestReverseProxyTracing(t *testing.T) {
+	upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+		traceparent, _ := req.Headers.Get("traceparent")
+		w.WriteResponse(response.StatusOk, traceparent)
+	})
+	exporter := make(spanExporter, 16)
+	tracer := tracing.NewTracer(exporter)
+	rp := NewReverseProxy(Route{Prefix: "/", Upstream: upstream.Addr().String()})
+
+	// Test: Untraced requests keep their traceparent
+	proxy := startServer(t, rp.Handle)
+	resp := doRequest(t, proxy.Addr(), "GET / HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"Traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n"+
+		"\r\n")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", string(body))
+
+	// Test: Traced requests send the context of the client span upstream
+	proxy = startServer(t, tracer.Middleware(rp.Handle))
+	resp = doRequest(t, proxy.Addr(), "GET / HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"Traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n"+
+		"\r\n")
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	var client *tracing.Span
+	for client == nil {
+		select {
+		case span := <-exporter:
+			if span.Kind == tracing.KindClient {
+				client = span
+			}
+		case <-time.After(time.Second):
+			t.Fatal("client span was not exported")
+		}
+	}
+	assert.Equal(t, client.Context.Traceparent(), string(body))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", client.Context.TraceID.String())
+	assert.Equal(t, "200", client.Attributes["http.status_code"])
+}
+
+func TestReverseProxyClientGone(t *testing.T) {
+	errs := make(chan error, 1)
+	upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+		select {
+		case <-req.Context().Done():
+			errs <- req.Context().Err()
+		case <-time.After(time.Second):
+			errs <- nil
+		}
+		w.WriteResponse(response.StatusOk, "too late")
+	})
+	rp := NewReverseProxy(Route{Prefix: "/", Upstream: upstream.Addr().String()})
+	proxy := startServer(t, rp.Handle)
+
+	_, port, err := net.SplitHostPort(proxy.Addr().String())
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	require.NoError(t, err)
+
+	// Test: Upstream request is aborted when the client connection breaks
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+
+	// Test: Upstream request is bounded by the request deadline
+	timed, err := server.ServeWithOptions(0, rp.Handle, server.Options{RequestTimeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+	t.Cleanup(func() { timed.Close() })
+	resp := doRequest(t, timed.Addr(), "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, 504, resp.StatusCode)
+	assert.ErrorIs(t, <-errs, context.Canceled)
+}
+
+// rawUpstream answers a single connection with serve and returns its address
+func rawUpstream(t *testing.T, serve func(conn net.Conn, r *bufio.Reader)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		serve(conn, bufio.NewReader(conn))
+	}()
+	return listener.Addr().String()
+}
+
+// readHead reads the request line and headers sent to a raw upstream
+func readHead(r *bufio.Reader) (string, error) {
+	var head strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return head.String(), err
+		}
+		head.WriteString(line)
+		if line == "\r\n" {
+			return head.String(), nil
+		}
+	}
+}
+
+func TestReverseProxyStreamedBody(t *testing.T) {
+	received := make(chan string, 1)
+	addr := rawUpstream(t, func(conn net.Conn, r *bufio.Reader) {
+		head, err := readHead(r)
+		if err != nil {
+			return
+		}
+		first, _ := r.ReadString('\n')
+		data, _ := r.ReadString('\n')
+		received <- head + first + data
+
+		rest := make([]byte, len("6\r\n world\r\n0\r\n\r\n"))
+		_, err = io.ReadFull(r, rest)
+		if err != nil {
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: "+fmt.Sprint(len(rest))+"\r\n\r\n"+string(rest))
+	})
+	rp := NewReverseProxy(Route{Prefix: "/", Upstream: addr})
+	proxy, err := server.ServeWithOptions(0, rp.Handle, server.Options{StreamRequestBodies: true})
+	require.NoError(t, err)
+	t.Cleanup(func() { proxy.Close() })
+
+	_, port, err := net.SplitHostPort(proxy.Addr().String())
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Test: Chunks reach the upstream before the client ends the body
+	_, err = io.WriteString(conn, "POST /upload HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n")
+	require.NoError(t, err)
+	select {
+	case sent := <-received:
+		assert.Contains(t, sent, "Transfer-Encoding: chunked\r\n")
+		assert.NotContains(t, sent, "Content-Length")
+		assert.True(t, strings.HasSuffix(sent, "\r\n\r\n5\r\nhello\r\n"))
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream did not receive the first chunk")
+	}
+
+	_, err = io.WriteString(conn, "6\r\n world\r\n0\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "6\r\n world\r\n0\r\n\r\n", string(body))
+
+	// Test: Body with a Content-Length is streamed with it
+	upstream := startServer(t, upstreamHandler)
+	rp = NewReverseProxy(Route{Prefix: "/", Upstream: upstream.Addr().String()})
+	streaming, err := server.ServeWithOptions(0, rp.Handle, server.Options{StreamRequestBodies: true})
+	require.NoError(t, err)
+	t.Cleanup(func() { streaming.Close() })
+	large := strings.Repeat("x", 3000)
+	resp = doRequest(t, streaming.Addr(), "POST /large HTTP/1.1\r\nHost: example.com\r\nContent-Length: 3000\r\n\r\n"+large)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.True(t, strings.HasSuffix(string(body), "body="+large))
+}
+
+func TestReverseProxyInterimResponses(t *testing.T) {
+	addr := rawUpstream(t, func(conn net.Conn, r *bufio.Reader) {
+		_, err := readHead(r)
+		if err != nil {
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 100 Continue\r\n\r\n")
+		body := make([]byte, 5)
+		_, err = io.ReadFull(r, body)
+		if err != nil {
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n"+
+			"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n"+string(body))
+	})
+	rp := NewReverseProxy(Route{Prefix: "/", Upstream: addr})
+	proxy, err := server.ServeWithOptions(0, rp.Handle, server.Options{StreamRequestBodies: true})
+	require.NoError(t, err)
+	t.Cleanup(func() { proxy.Close() })
+
+	_, port, err := net.SplitHostPort(proxy.Addr().String())
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	// Test: 100 (Continue) of the upstream reaches the client waiting for it
+	_, err = io.WriteString(conn, "PUT /file HTTP/1.1\r\nHost: example.com\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 100, resp.StatusCode)
+
+	// Test: Further interim responses are relayed before the final one
+	_, err = io.WriteString(conn, "hello")
+	require.NoError(t, err)
+	resp, err = http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 103, resp.StatusCode)
+	assert.Equal(t, "</style.css>; rel=preload", resp.Header.Get("Link"))
+	resp, err = http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestReverseProxyBodilessResponses(t *testing.T) {
+	cases := []struct {
+		name     string
+		method   string
+		upstream string
+		length   string
+	}{
+		{name: "HEAD", method: "HEAD", upstream: "HTTP/1.1 200 OK\r\nContent-Length: 1234\r\n\r\n", length: "1234"},
+		{name: "not modified", method: "GET", upstream: "HTTP/1.1 304 Not Modified\r\nETag: \"v1\"\r\nContent-Length: 1234\r\n\r\n", length: "1234"},
+		{name: "not modified without length", method: "GET", upstream: "HTTP/1.1 304 Not Modified\r\nETag: \"v1\"\r\n\r\n"},
+		{name: "no content", method: "DELETE", upstream: "HTTP/1.1 204 No Content\r\nContent-Length: 0\r\n\r\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			addr := rawUpstream(t, func(conn net.Conn, r *bufio.Reader) {
+				if _, err := readHead(r); err == nil {
+					io.WriteString(conn, c.upstream)
+				}
+			})
+			proxy := startServer(t, NewReverseProxy(Route{Prefix: "/", Upstream: addr}).Handle)
+
+			// Test: Content-Length is relayed for HEAD and 304 only
+			resp := doRequest(t, proxy.Addr(), c.method+" / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+			assert.Equal(t, c.length, resp.Header.Get("Content-Length"))
+		})
+	}
+}
+
+func TestReverseProxyTLSUpstream(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s over %s", r.Method, r.URL.Path, r.TLS.ServerName)
+	}))
+	t.Cleanup(upstream.Close)
+	roots := x509.NewCertPool()
+	roots.AddCert(upstream.Certificate())
+
+	// Test: SNI is taken from Host and the certificate is verified
+	proxy := startServer(t, NewReverseProxy(Route{
+		Prefix:   "/",
+		Upstream: upstream.Listener.Addr().String(),
+		Host:     "example.com",
+		TLS:      &tls.Config{RootCAs: roots},
+	}).Handle)
+	resp := doRequest(t, proxy.Addr(), "GET /secure HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "GET /secure over example.com", string(body))
+
+	// Test: an untrusted certificate fails the request
+	proxy = startServer(t, NewReverseProxy(Route{
+		Prefix:   "/",
+		Upstream: upstream.Listener.Addr().String(),
+		TLS:      &tls.Config{},
+	}).Handle)
+	resp = doRequest(t, proxy.Addr(), "GET /secure HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 502, resp.StatusCode)
+}
@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// State is what a Store keeps per key. Its meaning depends on the algorithm,
+// a zero State is a key that was never seen
+type State struct {
+	Value    float64   `json:"value"`
+	Previous float64   `json:"previous,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Decision is the outcome of taking a request from a key's allowance
+type Decision struct {
+	Allowed bool
+	// Limit is the number of requests allowed in a burst or window
+	Limit     int
+	Remaining int
+	// Reset is how long until the allowance is fully restored
+	Reset time.Duration
+	// RetryAfter is how long until a request would be allowed again, zero
+	// when Allowed
+	RetryAfter time.Duration
+}
+
+// Algorithm decides whether a request is allowed given the state of its key
+type Algorithm interface {
+	// Take returns the decision and the new state of the key
+	Take(state State, now time.Time) (State, Decision)
+	// IdleTTL is how long after its last request a key is back to its zero
+	// state and can be evicted
+	IdleTTL() time.Duration
+}
+
+// TokenBucket allows bursts of Burst requests, refilled at Rate requests per
+// second
+type TokenBucket struct {
+	Rate  float64
+	Burst int
+}
+
+// Take uses State.Value for the tokens left at State.Time
+func (b TokenBucket) Take(state State, now time.Time) (State, Decision) {
+	burst := float64(b.Burst)
+	tokens := burst
+	if !state.Time.IsZero() {
+		elapsed := max(now.Sub(state.Time).Seconds(), 0)
+		tokens = min(burst, state.Value+elapsed*b.Rate)
+	}
+
+	decision := Decision{Limit: b.Burst}
+	if tokens >= 1 {
+		tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = seconds((1 - tokens) / b.Rate)
+	}
+	decision.Remaining = int(tokens)
+	decision.Reset = seconds((burst - tokens) / b.Rate)
+	return State{Value: tokens, Time: now}, decision
+}
+
+func (b TokenBucket) IdleTTL() time.Duration {
+	return seconds(float64(b.Burst) / b.Rate)
+}
+
+// SlidingWindow allows Limit requests in any Window. It approximates the
+// count over the sliding window by weighting the count of the previous fixed
+// window by how much of it still overlaps
+type SlidingWindow struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Take uses State.Value and State.Previous for the counts of the current and
+// previous fixed windows, and State.Time for the start of the current one
+func (s SlidingWindow) Take(state State, now time.Time) (State, Decision) {
+	start := now.Truncate(s.Window)
+	switch {
+	case state.Time.Equal(start):
+	case state.Time.Equal(start.Add(-s.Window)):
+		state = State{Previous: state.Value, Time: start}
+	default:
+		state = State{Time: start}
+	}
+
+	limit := float64(s.Limit)
+	elapsed := now.Sub(start)
+	overlap := 1 - float64(elapsed)/float64(s.Window)
+	count := state.Previous*overlap + state.Value
+
+	decision := Decision{Limit: s.Limit, Reset: s.Window - elapsed}
+	if count+1 <= limit {
+		state.Value++
+		count++
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = s.retryAfter(state, elapsed)
+	}
+	decision.Remaining = int(math.Max(limit-count, 0))
+	return state, decision
+}
+
+// retryAfter solves when the weighted count drops to Limit-1, in the current
+// window if the previous count decays enough or else in the next one
+func (s SlidingWindow) retryAfter(state State, elapsed time.Duration) time.Duration {
+	room := float64(s.Limit) - 1 - state.Value
+	if room >= 0 && state.Previous > 0 {
+		at := time.Duration(float64(s.Window) * (1 - room/state.Previous))
+		return max(at-elapsed, 0)
+	}
+	at := s.Window
+	if state.Value > 0 {
+		at += time.Duration(float64(s.Window) * math.Max(1-(float64(s.Limit)-1)/state.Value, 0))
+	}
+	return at - elapsed
+}
+
+func (s SlidingWindow) IdleTTL() time.Duration {
+	return 2 * s.Window
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"httpfromtcp/internal/auth"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// Limiter applies an algorithm to keys whose state is kept in a store
+type Limiter struct {
+	algorithm Algorithm
+	store     Store
+	now       func() time.Time
+}
+
+func NewLimiter(algorithm Algorithm, store Store) *Limiter {
+	return &Limiter{algorithm: algorithm, store: store, now: time.Now}
+}
+
+// Allow takes a request from the allowance of key
+func (l *Limiter) Allow(key string) (Decision, error) {
+	var decision Decision
+	now := l.now()
+	err := l.store.Update(key, l.algorithm.IdleTTL(), func(state State) State {
+		state, decision = l.algorithm.Take(state, now)
+		return state
+	})
+	return decision, err
+}
+
+// KeyFunc returns the key a request is limited by, or false to let the
+// request through without limiting it
+type KeyFunc func(req *request.Request) (string, bool)
+
+// ByIP limits each client IP address
+func ByIP(req *request.Request) (string, bool) {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host, true
+	}
+	return req.RemoteAddr, req.RemoteAddr != ""
+}
+
+// ByHeader limits each value of a request header, such as an API key.
+// Requests without the header are not limited
+func ByHeader(name string) KeyFunc {
+	return func(req *request.Request) (string, bool) {
+		value, isPresent := req.Headers.Get(name)
+		return value, isPresent && value != ""
+	}
+}
+
+// ByPrincipal limits each principal authenticated by the auth middlewares,
+// which must run first. Anonymous requests are not limited
+func ByPrincipal(req *request.Request) (string, bool) {
+	p, found := auth.PrincipalOf(req)
+	if !found {
+		return "", false
+	}
+	return p.Scheme + ":" + p.Name, true
+}
+
+// Middleware answers 429 Too Many Requests with Retry-After once the key of
+// a request runs out of allowance, and reports the allowance in RateLimit
+// headers (draft-ietf-httpapi-ratelimit-headers). Requests are let through
+// when the store fails
+func Middleware(l *Limiter, key KeyFunc) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			k, limited := key(req)
+			if !limited {
+				next(w, req)
+				return
+			}
+			decision, err := l.Allow(k)
+			if err != nil {
+				log.Printf("ratelimit: %v", err)
+				next(w, req)
+				return
+			}
+
+			if !decision.Allowed {
+				msg := "Too many requests"
+				header := response.GetDefaultHeader(len(msg))
+				addHeaders(header, decision)
+				header.Put("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter)))
+				w.WriteStatusLine(response.StatusTooManyRequests)
+				w.WriteHeaders(header)
+				w.WriteBody(msg)
+				return
+			}
+			w.BeforeWriteHeaders(func(_ response.StatusCode, h headers.Headers) {
+				addHeaders(h, decision)
+			})
+			next(w, req)
+		}
+	}
+}
+
+func addHeaders(h headers.Headers, d Decision) {
+	h.Put("RateLimit-Limit", strconv.Itoa(d.Limit))
+	h.Put("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	h.Put("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+}
+
+// ceilSeconds rounds up so clients never retry too early
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
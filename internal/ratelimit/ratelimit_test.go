@@ -0,0 +1,176 @@
+package ratelimit
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/auth"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := TokenBucket{Rate: 2, Burst: 3}
+	now := time.Unix(1_800_000_000, 0)
+	var state State
+	var d Decision
+
+	for i := range 3 {
+		state, d = bucket.Take(state, now)
+		assert.True(t, d.Allowed)
+		assert.Equal(t, 2-i, d.Remaining)
+	}
+	state, d = bucket.Take(state, now)
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 500*time.Millisecond, d.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, d.Reset)
+
+	// tokens refill at the rate and never exceed the burst
+	state, d = bucket.Take(state, now.Add(500*time.Millisecond))
+	assert.True(t, d.Allowed)
+	_, d = bucket.Take(state, now.Add(time.Hour))
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 2, d.Remaining)
+	assert.Equal(t, 1500*time.Millisecond, bucket.IdleTTL())
+}
+
+func TestSlidingWindow(t *testing.T) {
+	window := SlidingWindow{Limit: 4, Window: time.Minute}
+	start := time.Unix(1_800_000_000, 0).Truncate(time.Minute)
+	var state State
+	var d Decision
+
+	for range 4 {
+		state, d = window.Take(state, start.Add(10*time.Second))
+		assert.True(t, d.Allowed)
+	}
+	assert.Equal(t, 0, d.Remaining)
+	assert.Equal(t, 50*time.Second, d.Reset)
+
+	state, d = window.Take(state, start.Add(20*time.Second))
+	assert.False(t, d.Allowed)
+	// the 4 requests weigh 4*(1-x/60) in the next window, which allows one
+	// more once x reaches 15s
+	assert.Equal(t, 55*time.Second, d.RetryAfter)
+
+	// a quarter into the next window the previous count weighs 3
+	_, d = window.Take(state, start.Add(74*time.Second))
+	assert.False(t, d.Allowed)
+	assert.Equal(t, time.Second, d.RetryAfter)
+	state, d = window.Take(state, start.Add(75*time.Second))
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 0, d.Remaining)
+
+	// windows older than the previous one are forgotten
+	_, d = window.Take(state, start.Add(3*time.Minute))
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 3, d.Remaining)
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Unix(1_800_000_000, 0)
+	store.now = func() time.Time { return now }
+	increment := func(s State) State {
+		s.Value++
+		return s
+	}
+
+	var seen State
+	require.NoError(t, store.Update("a", time.Second, increment))
+	require.NoError(t, store.Update("a", time.Second, func(s State) State {
+		seen = s
+		return increment(s)
+	}))
+	assert.Equal(t, 1.0, seen.Value)
+
+	// idle keys start over and are evicted
+	now = now.Add(time.Second)
+	require.NoError(t, store.Update("a", time.Second, func(s State) State {
+		seen = s
+		return s
+	}))
+	assert.Equal(t, State{}, seen)
+	require.NoError(t, store.Update("b", time.Second, increment))
+	now = now.Add(2 * sweepInterval)
+	require.NoError(t, store.Update("c", time.Second, increment))
+	assert.Equal(t, 1, store.Len())
+}
+
+func serve(t *testing.T, handler server.Handler, remoteAddr string, fields ...string) *http.Response {
+	t.Helper()
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+	for _, field := range fields {
+		raw += field + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = remoteAddr
+
+	w := response.NewWriter()
+	handler(&w, req)
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(w.ReadBuffer())), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func ok(w *response.Writer, req *request.Request) {
+	w.WriteResponse(response.StatusOk, "ok")
+}
+
+func TestMiddleware(t *testing.T) {
+	limiter := NewLimiter(TokenBucket{Rate: 0.5, Burst: 2}, NewMemoryStore())
+	now := time.Unix(1_800_000_000, 0)
+	limiter.now = func() time.Time { return now }
+	handler := Middleware(limiter, ByIP)(ok)
+
+	resp := serve(t, handler, "10.0.0.1:4000")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "2", resp.Header.Get("RateLimit-Reset"))
+
+	// the port does not matter
+	serve(t, handler, "10.0.0.1:4001")
+	resp = serve(t, handler, "10.0.0.1:4002")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("Retry-After"))
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+
+	resp = serve(t, handler, "10.0.0.2:4000")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	now = now.Add(2 * time.Second)
+	resp = serve(t, handler, "10.0.0.1:4003")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestKeys(t *testing.T) {
+	limiter := NewLimiter(SlidingWindow{Limit: 1, Window: time.Hour}, NewMemoryStore())
+
+	byHeader := Middleware(limiter, ByHeader("X-Api-Key"))(ok)
+	assert.Equal(t, http.StatusOK, serve(t, byHeader, "10.0.0.1:1", "X-Api-Key: a").StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, serve(t, byHeader, "10.0.0.2:1", "X-Api-Key: a").StatusCode)
+	assert.Equal(t, http.StatusOK, serve(t, byHeader, "10.0.0.1:1", "X-Api-Key: b").StatusCode)
+	// requests without a key are not limited
+	for range 3 {
+		resp := serve(t, byHeader, "10.0.0.1:1")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("RateLimit-Limit"))
+	}
+
+	byPrincipal := auth.Basic("api", auth.Credentials{"alice": "pw", "bob": "pw"})(
+		Middleware(limiter, ByPrincipal)(ok))
+	alice := "Authorization: Basic YWxpY2U6cHc="
+	bob := "Authorization: Basic Ym9iOnB3"
+	assert.Equal(t, http.StatusOK, serve(t, byPrincipal, "10.0.0.1:1", alice).StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, serve(t, byPrincipal, "10.0.0.1:1", alice).StatusCode)
+	assert.Equal(t, http.StatusOK, serve(t, byPrincipal, "10.0.0.1:1", bob).StatusCode)
+}
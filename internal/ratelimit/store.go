@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Store keeps the state of every key. Implementations must be safe for
+// concurrent use and serialize updates of the same key
+type Store interface {
+	// Update replaces the state of key with the result of fn, which is
+	// called with the zero State for unknown or evicted keys. The key may be
+	// evicted once ttl has passed without updates
+	Update(key string, ttl time.Duration, fn func(State) State) error
+}
+
+// sweepInterval is how often MemoryStore evicts idle keys
+const sweepInterval = time.Minute
+
+type memoryEntry struct {
+	state   State
+	expires time.Time
+}
+
+// MemoryStore is a Store keeping the states in memory, so every server
+// enforces its own limits
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]memoryEntry),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Update(key string, ttl time.Duration, fn func(State) State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+
+	entry := s.entries[key]
+	if !now.Before(entry.expires) {
+		entry.state = State{}
+	}
+	s.entries[key] = memoryEntry{state: fn(entry.state), expires: now.Add(ttl)}
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.lastSweep = now
+		for key, entry := range s.entries {
+			if !now.Before(entry.expires) {
+				delete(s.entries, key)
+			}
+		}
+	}
+	return nil
+}
+
+// Len returns the number of keys, idle ones included until they are swept
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
@@ -0,0 +1,22 @@
+package request
+
+import "httpfromtcp/internal/cookie"
+
+// Cookies returns the cookies sent in the Cookie header
+func (r *Request) Cookies() []*cookie.Cookie {
+	value, isPresent := r.Headers.Get("Cookie")
+	if !isPresent {
+		return nil
+	}
+	return cookie.Parse(value)
+}
+
+// Cookie returns the first cookie with the given name
+func (r *Request) Cookie(name string) (*cookie.Cookie, bool) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
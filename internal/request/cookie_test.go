@@ -0,0 +1,27 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookies(t *testing.T) {
+	r, err := RequestFromReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nCookie: session=abc; theme=dark\r\nCookie: lang=en\r\n\r\n",
+		numBytesPerRead: 5,
+	})
+	require.NoError(t, err)
+
+	cookies := r.Cookies()
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "lang", cookies[2].Name)
+
+	c, found := r.Cookie("theme")
+	require.True(t, found)
+	assert.Equal(t, "dark", c.Value)
+
+	_, found = r.Cookie("missing")
+	assert.False(t, found)
+}
@@ -0,0 +1,99 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxDecodedBodySize bounds the size of a request body after its
+// content codings are removed when DecodeBody is given no limit
+const DefaultMaxDecodedBodySize = 10 << 20
+
+var (
+	ErrUnsupportedContentEncoding = errors.New("Unsupported Content-Encoding")
+	ErrBodyTooLarge               = errors.New("Request body is too large")
+)
+
+// DecodeBody removes the content codings listed in Content-Encoding from
+// the body. Codings are listed in the order they were applied, so they are
+// removed from last to first. The decoded body is bounded by maxSize, or
+// DefaultMaxDecodedBodySize when it is not positive, so a small compressed
+// body cannot expand into an unbounded amount of memory. On success the
+// body as received is kept in RawBody, the Content-Encoding header is
+// dropped and Content-Length, when present, describes the decoded body
+func (r *Request) DecodeBody(maxSize int64) error {
+	contentEncoding, isPresent := r.Headers.Get("Content-Encoding")
+	if !isPresent {
+		return nil
+	}
+
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDecodedBodySize
+	}
+	codings := strings.Split(contentEncoding, ",")
+	body := r.Body
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		var err error
+		switch coding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			body, err = decodeWith(body, maxSize, func(src io.Reader) (io.Reader, error) {
+				return gzip.NewReader(src)
+			})
+		case "deflate":
+			body, err = decodeDeflate(body, maxSize)
+		default:
+			return fmt.Errorf("%w: %s", ErrUnsupportedContentEncoding, coding)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	r.RawBody = r.Body
+	r.Body = body
+	r.Headers.Remove("Content-Encoding")
+	if _, hasLength := r.Headers.Get("Content-Length"); hasLength {
+		r.Headers.Replace("content-length", strconv.Itoa(len(body)))
+	}
+	return nil
+}
+
+// decodeDeflate decodes the "deflate" coding, which is the zlib format.
+// Some clients send a raw deflate stream instead, which is accepted as well
+func decodeDeflate(body []byte, maxSize int64) ([]byte, error) {
+	decoded, err := decodeWith(body, maxSize, func(src io.Reader) (io.Reader, error) {
+		return zlib.NewReader(src)
+	})
+	if err == nil || errors.Is(err, ErrBodyTooLarge) {
+		return decoded, err
+	}
+	return decodeWith(body, maxSize, func(src io.Reader) (io.Reader, error) {
+		return flate.NewReader(src), nil
+	})
+}
+
+func decodeWith(body []byte, maxSize int64, newReader func(io.Reader) (io.Reader, error)) ([]byte, error) {
+	reader, err := newReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid compressed body: %w", err)
+	}
+
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid compressed body: %w", err)
+	}
+	if int64(len(decoded)) > maxSize {
+		return nil, ErrBodyTooLarge
+	}
+	return decoded, nil
+}
@@ -0,0 +1,118 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func zlibBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func flateBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	require.NoError(t, err)
+	_, err = zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func encodedRequest(encoding string, body []byte) string {
+	return fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: localhost:8080\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n\r\n%s", encoding, len(body), body)
+}
+
+func TestDecodeBody(t *testing.T) {
+	payload := []byte(`{"flavor":"dark mode","shots":2}`)
+	inputs := map[string]string{
+		"gzip":          encodedRequest("gzip", gzipBytes(t, payload)),
+		"x-gzip":        encodedRequest("x-gzip", gzipBytes(t, payload)),
+		"deflate":       encodedRequest("deflate", zlibBytes(t, payload)),
+		"raw deflate":   encodedRequest("deflate", flateBytes(t, payload)),
+		"stacked":       encodedRequest("deflate, gzip", gzipBytes(t, zlibBytes(t, payload))),
+		"with identity": encodedRequest("identity, gzip", gzipBytes(t, payload)),
+	}
+
+	for name, data := range inputs {
+		for byteSize := 1; byteSize < len(data)+5; byteSize += 7 {
+			r, err := RequestFromReader(&chunkReader{data: data, numBytesPerRead: byteSize})
+			require.NoError(t, err, name)
+			raw := r.Body
+			require.NoError(t, r.DecodeBody(0), name)
+			assert.Equal(t, payload, r.Body, name)
+			assert.Equal(t, raw, r.RawBody, name)
+			_, hasEncoding := r.Headers.Get("Content-Encoding")
+			assert.False(t, hasEncoding, name)
+			assert.Equal(t, fmt.Sprint(len(payload)), r.Headers["content-length"], name)
+		}
+	}
+
+	// Test: Chunked and gzipped body
+	compressed := gzipBytes(t, payload)
+	data := fmt.Sprintf("POST /upload HTTP/1.1\r\nContent-Encoding: gzip\r\nTransfer-Encoding: chunked\r\n\r\n%X\r\n%s\r\n0\r\n\r\n", len(compressed), compressed)
+	r, err := RequestFromReader(&chunkReader{data: data, numBytesPerRead: 3})
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeBody(0))
+	assert.Equal(t, payload, r.Body)
+	assert.Equal(t, compressed, r.RawBody)
+
+	// Test: Body without content codings is left as is
+	r, err = RequestFromReader(&chunkReader{data: "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello", numBytesPerRead: 3})
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeBody(0))
+	assert.Equal(t, "hello", string(r.Body))
+	assert.Nil(t, r.RawBody)
+}
+
+// decode parses the request and decodes its body
+func decode(t *testing.T, data string, maxSize int64) error {
+	t.Helper()
+	r, err := RequestFromReader(&chunkReader{data: data, numBytesPerRead: 8})
+	require.NoError(t, err)
+	return r.DecodeBody(maxSize)
+}
+
+func TestDecodeBodyErrors(t *testing.T) {
+	// Test: Unsupported coding
+	err := decode(t, encodedRequest("br", []byte("abc")), 0)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrUnsupportedContentEncoding))
+
+	// Test: Corrupt gzip data
+	err = decode(t, encodedRequest("gzip", []byte("not gzip")), 0)
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrUnsupportedContentEncoding))
+
+	// Test: Decompression bomb
+	bomb := gzipBytes(t, bytes.Repeat([]byte{0}, 1<<20))
+	require.Less(t, len(bomb), 8192)
+	err = decode(t, encodedRequest("gzip", bomb), 8192)
+	assert.True(t, errors.Is(err, ErrBodyTooLarge))
+
+	// Test: Stacked bomb is caught on the inner layer
+	err = decode(t, encodedRequest("gzip, gzip", gzipBytes(t, bomb)), 8192)
+	assert.True(t, errors.Is(err, ErrBodyTooLarge))
+}
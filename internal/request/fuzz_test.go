@@ -0,0 +1,202 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"httpfromtcp/internal/headers"
+)
+
+// fuzzSeeds are well-formed and malformed requests the fuzz targets start
+// from
+var fuzzSeeds = []string{
+	"GET / HTTP/1.1\r\nHost: localhost:8080\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+	"GET /coffee?q=1 HTTP/1.1\r\nX-A: a\r\nx-a: b\r\nCookie: a=1\r\nCookie: b=2\r\n\r\n",
+	"POST /submit HTTP/1.1\r\nHost: localhost:8080\r\nContent-Length: 13\r\n\r\nhello world!\n",
+	"POST / HTTP/1.1\r\nContent-Length: 0\r\n\r\n",
+	"POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello world",
+	"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6;ext=1\r\n world\r\n0\r\n\r\n",
+	"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n4\r\ndata\r\n0\r\nX-Sum: abc\r\nSet-Cookie: a=1\r\nSet-Cookie: b=2\r\n\r\n",
+	"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhi\r\n0\r\n\r\nGET /next HTTP/1.1\r\n\r\n",
+	"POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\nA\r\n0123456789\r\n0\r\n\r\n",
+	"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\n0\r\n\r\n",
+	"GET / HTTP/1.1\r\nX-A: first\r\n  folded\r\n\r\n",
+	"GET / HTTP/1.1\r\nX-A: \t spaced \t\r\nX-Empty:\r\nX-Text: caf\xc3\xa9\r\n\r\n",
+	"GET / HTTP/1.1\r\nBad Name: x\r\n\r\n",
+	"GET / HTTP/1.1\r\n: x\r\n\r\n",
+	"get / HTTP/1.1\r\n\r\n",
+	"GET / HTTP/2.0\r\n\r\n",
+	"GET / HTTP/1.1\r\n",
+}
+
+// sizesReader returns reads of the sizes in sizes, in a loop, so the fuzzer
+// controls how a request is split across reads
+type sizesReader struct {
+	data  []byte
+	sizes []byte
+	n     int
+}
+
+func (r *sizesReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	size := len(r.data)
+	if len(r.sizes) > 0 {
+		size = int(r.sizes[r.n%len(r.sizes)])%16 + 1
+		r.n++
+	}
+	n := copy(p, r.data[:min(size, len(r.data))])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// describe returns what a handler sees of a parsed request, its buffered
+// bytes excepted
+func describe(req *Request) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%q %q %q\n", req.RequestLine.Method, req.RequestLine.RequestTarget, req.RequestLine.HttpVersion)
+	describeFields(&b, req.Headers)
+	fmt.Fprintf(&b, "body %q\n", req.Body)
+	describeFields(&b, req.Trailers)
+	return b.String()
+}
+
+func describeFields(b *bytes.Buffer, h headers.Headers) {
+	for _, name := range orderedNames(h, nil) {
+		fmt.Fprintf(b, "%q: %q\n", name, h[name])
+	}
+}
+
+func FuzzRequestFromReader(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed), []byte{0})
+		f.Add([]byte(seed), []byte{2, 6, 15})
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, sizes []byte) {
+		whole, wholeErr := RequestFromReader(&sizesReader{data: data})
+		split, splitErr := RequestFromReader(&sizesReader{data: data, sizes: sizes})
+
+		// the result does not depend on how the request is split across reads
+		if (wholeErr == nil) != (splitErr == nil) {
+			t.Fatalf("whole read error %v, split reads error %v", wholeErr, splitErr)
+		}
+		if wholeErr != nil {
+			return
+		}
+		if describe(whole) != describe(split) {
+			t.Fatalf("whole read gives\n%s\nsplit reads give\n%s", describe(whole), describe(split))
+		}
+		// reads stop at the end of the request, so fewer bytes can be
+		// buffered past it
+		if !bytes.HasPrefix(whole.Buffered(), split.Buffered()) {
+			t.Fatalf("split reads buffered %q, not a prefix of %q", split.Buffered(), whole.Buffered())
+		}
+		if !bytes.HasSuffix(data, whole.Buffered()) {
+			t.Fatalf("buffered %q is not the end of the input", whole.Buffered())
+		}
+
+		// a parsed request is written back as a request that parses the same
+		var written bytes.Buffer
+		if err := whole.Write(&written); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		again, err := RequestFromReader(&sizesReader{data: written.Bytes()})
+		if err != nil {
+			t.Fatalf("written request %q does not parse: %v", written.Bytes(), err)
+		}
+		if describe(again) != describe(whole) {
+			t.Fatalf("written request %q parses as\n%s\ninstead of\n%s", written.Bytes(), describe(again), describe(whole))
+		}
+	})
+}
+
+func FuzzParseChunk(f *testing.F) {
+	seeds := []string{
+		"5\r\nhello\r\n",
+		"A;name=value\r\n0123456789\r\n",
+		"5 \r\nhello\r\n",
+		"0\r\n\r\n",
+		"0;ext\r\n",
+		"5\r\nhelloXX",
+		"zz\r\nhello\r\n",
+		"\r\n",
+		"7fffffff\r\n",
+		"-1\r\nx\r\n",
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := newRequest()
+		n, done, err := r.parseChunk(data)
+		if n < 0 || n > len(data) {
+			t.Fatalf("parsed %d bytes of %d", n, len(data))
+		}
+		if err == nil && !done && len(r.Body) > n {
+			t.Fatalf("decoded %d bytes from %d parsed ones", len(r.Body), n)
+		}
+
+		// a chunk parsed or rejected from the start of the data is parsed or
+		// rejected the same way once more data is received
+		for i := range data {
+			prefix := newRequest()
+			pn, pdone, perr := prefix.parseChunk(data[:i])
+			if perr == nil && pn == 0 {
+				continue
+			}
+			if (perr == nil) != (err == nil) || pn != n || pdone != done || !bytes.Equal(prefix.Body, r.Body) {
+				t.Fatalf("%q parses as (%d, %v, %v), %q as (%d, %v, %v)", data[:i], pn, pdone, perr, data, n, done, err)
+			}
+		}
+	})
+}
+
+func FuzzChunkedBody(f *testing.F) {
+	seeds := []string{
+		"5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n",
+		"4\r\ndata\r\n0\r\nX-Sum: abc\r\n\r\n",
+		"2\r\nhi\r\n0\r\n\r\nGET / HTTP/1.1\r\n\r\n",
+		"0\r\nX-A: first\r\n\tsecond\r\n\r\n",
+		"5\r\nhello\r\n",
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed), []byte{1, 4})
+	}
+
+	f.Fuzz(func(t *testing.T, body []byte, sizes []byte) {
+		data := append([]byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n"), body...)
+		whole, wholeErr := RequestFromReader(&sizesReader{data: data})
+		split, splitErr := RequestFromReader(&sizesReader{data: data, sizes: sizes})
+		if (wholeErr == nil) != (splitErr == nil) {
+			t.Fatalf("whole read error %v, split reads error %v", wholeErr, splitErr)
+		}
+		if wholeErr != nil {
+			return
+		}
+		if describe(whole) != describe(split) {
+			t.Fatalf("whole read gives\n%s\nsplit reads give\n%s", describe(whole), describe(split))
+		}
+
+		// the body is framed again in a single chunk
+		var written bytes.Buffer
+		if err := whole.writeChunkedBody(&written); err != nil {
+			t.Fatalf("writeChunkedBody: %v", err)
+		}
+		again := newRequest()
+		again.RequestLine = whole.RequestLine
+		again.Headers.Put("Transfer-Encoding", "chunked")
+		again.state = ParsingChunkedBody
+		n, err := again.parse(written.Bytes())
+		if err != nil || n != written.Len() || again.state != Done {
+			t.Fatalf("written body %q parses %d bytes, state %v: %v", written.Bytes(), n, again.state, err)
+		}
+		if describe(again) != describe(whole) {
+			t.Fatalf("written body %q parses as\n%s\ninstead of\n%s", written.Bytes(), describe(again), describe(whole))
+		}
+	})
+}
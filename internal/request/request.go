@@ -1,20 +1,31 @@
 package request
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
+	"time"
 
 	"httpfromtcp/internal/headers"
 )
 
+var (
+	ErrObsFold                 = errors.New("Field line starts with whitespace")
+	ErrInvalidTransferEncoding = errors.New("Invalid Transfer-Encoding")
+)
+
 type ParserState int
 
 const (
 	Initialized ParserState = iota
 	ParsingHeaders
 	ParsingBody
+	ParsingChunkedBody
+	ParsingTrailers
 	Done
 )
 
@@ -29,19 +40,58 @@ type Request struct {
 	state ParserState
 	Headers headers.Headers
 	Body []byte
+	// RawBody is the body as received when DecodeBody removed content
+	// codings from it, and nil otherwise
+	RawBody []byte
+	Trailers headers.Headers
+	// RemoteAddr is the address of the client, set by the server
+	RemoteAddr string
+
+	// headerOrder and trailerOrder keep the field names in the order and
+	// case they were received so that Write can reproduce them
+	headerOrder []string
+	trailerOrder []string
+
+	// buffered holds the bytes read past the end of the request
+	buffered []byte
+	// headOnly is set for requests parsed by RequestHeadFromReader, whose
+	// body is read from stream
+	headOnly bool
+	stream io.Reader
+
+	// receivedAt is when the first bytes of the request were read and
+	// headersParsedAt when the end of its header section was parsed
+	receivedAt time.Time
+	headersParsedAt time.Time
+
+	// ctx is set by the server, see Context
+	ctx context.Context
 }
 
 func newRequest() *Request {
 	return &Request{
 		state: Initialized,
 		Headers: headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
 	}
 }
 
 const CRLF = "\r\n"
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	return readRequest(reader, false)
+}
+
+// RequestHeadFromReader parses the request line and the headers of a
+// request. Its body, if any, is not read: it is left to be streamed with
+// BodyReader, and Body stays nil
+func RequestHeadFromReader(reader io.Reader) (*Request, error) {
+	return readRequest(reader, true)
+}
+
+func readRequest(reader io.Reader, headOnly bool) (*Request, error) {
 	request := newRequest()
+	request.headOnly = headOnly
 	var data []byte
 	var unparsed []byte
 	var parsedData []byte
@@ -55,23 +105,32 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 			return nil, err
 		}
 		lengthRead += len
+		if len > 0 && request.receivedAt.IsZero() {
+			request.receivedAt = time.Now()
+		}
 
 		// _, err = data.Write(bytesRead[0:len])
 		data = append(data, bytesRead[0:len]...)
 		unparsed = append(unparsed, bytesRead[0:len]...)
 
-		if(request.state == ParsingBody && err == io.EOF) || request.state != ParsingBody {
-			parsedLength, parseErr := request.parse(unparsed)
-			parsedData = append(parsedData, unparsed[:parsedLength]...)
-			if parseErr != nil {
-				return nil, parseErr
-			}
-			if err == io.EOF && request.state != Done {
-				return nil, fmt.Errorf("Received Body of length smaller than content length")
-			}
-			unparsed = unparsed[parsedLength:]
+		parsedLength, parseErr := request.parse(unparsed)
+		parsedData = append(parsedData, unparsed[:parsedLength]...)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		if headOnly && request.state == ParsingBody {
+			request.headersParsedAt = time.Now()
+			request.stream = newBodyReader(request, io.MultiReader(bytes.NewReader(unparsed[parsedLength:]), reader))
+			return request, nil
+		}
+		if err == io.EOF && request.state != Done {
+			return nil, fmt.Errorf("Received Body of length smaller than content length")
+		}
+		unparsed = unparsed[parsedLength:]
 
-			lengthParsed += parsedLength
+		lengthParsed += parsedLength
+		if request.headersParsedAt.IsZero() && request.state > ParsingHeaders {
+			request.headersParsedAt = time.Now()
 		}
 
 		if request.state == Done {
@@ -79,9 +138,63 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		}
 	}
 
+	if len(unparsed) > 0 {
+		request.buffered = append([]byte(nil), unparsed...)
+	}
+	return request, nil
+}
+
+// RequestFromFields builds a request that was received in another framing
+// than HTTP/1.1, such as an HTTP/2 stream
+func RequestFromFields(line RequestLine, fields headers.Headers, body []byte, trailers headers.Headers) (*Request, error) {
+	request := newRequest()
+	request.RequestLine = line
+	request.Body = body
+	if fields != nil {
+		request.Headers = fields
+	}
+	if trailers != nil {
+		request.Trailers = trailers
+	}
+	request.state = Done
 	return request, nil
 }
 
+// Timing returns when the first bytes of the request were read and when its
+// header section was parsed. Both are zero for requests that were not parsed
+// by RequestFromReader
+func (r *Request) Timing() (received, headersParsed time.Time) {
+	return r.receivedAt, r.headersParsedAt
+}
+
+// Context returns the context of the request. For requests from the server
+// it is canceled when the client closes or breaks the connection, the
+// server is closed or the request deadline of the server expires. It is
+// context.Background for other requests
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of the request with its context
+// replaced by ctx, such as to add values for the handlers down the chain
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("request: nil context")
+	}
+	copied := *r
+	copied.ctx = ctx
+	return &copied
+}
+
+// Buffered returns the bytes that were read from the reader after the end of
+// the request, such as data a client sends right after an upgrade request
+func (r *Request) Buffered() []byte {
+	return r.buffered
+}
+
 func parseRequestLine(request *Request, data string) (int, error) {
 	reqLine, _, found := strings.Cut(data, CRLF)
 	if !found {
@@ -93,13 +206,17 @@ func parseRequestLine(request *Request, data string) (int, error) {
 		return 0, fmt.Errorf("Start line of length: %d has too few or too many strings", len(reqLineElements))
 	}
 
-	if !validateMethod(reqLineElements[0]) {
-		return 0, fmt.Errorf("Request Method %s is not uppercase", request.RequestLine.Method)
+	if !ValidMethod(reqLineElements[0]) {
+		return 0, fmt.Errorf("Request Method %q is not an uppercase token", reqLineElements[0])
+	}
+
+	if !ValidTarget(reqLineElements[1]) {
+		return 0, fmt.Errorf("Request Target %q is not valid", reqLineElements[1])
 	}
 
 	verString := strings.Split(reqLineElements[2], "/")
 	if !validateVersion(verString) {
-		return 0, fmt.Errorf("Request Version %s is not valid", request.RequestLine.HttpVersion)
+		return 0, fmt.Errorf("Request Version %q is not valid", reqLineElements[2])
 	}
 
 	request.RequestLine.Method = reqLineElements[0]
@@ -108,8 +225,16 @@ func parseRequestLine(request *Request, data string) (int, error) {
 	return len([]byte(reqLine)) + len(CRLF), nil
 }
 
-func validateMethod(method string) bool {
-	return strings.ToUpper(method) == method
+// ValidMethod accepts methods that are tokens (RFC 9110 section 9.1),
+// which this server also requires in uppercase
+func ValidMethod(method string) bool {
+	return headers.IsToken(method) && strings.ToUpper(method) == method
+}
+
+// ValidTarget rejects empty targets, whitespace and control characters,
+// which cannot appear in any request-target form (RFC 9112 section 3.2)
+func ValidTarget(target string) bool {
+	return target != "" && headers.ValidFieldValue([]byte(target)) && !strings.ContainsAny(target, " \t")
 }
 
 func validateVersion(version []string) bool {
@@ -137,6 +262,9 @@ outer:
 			data = data[parsedLength:]
 
 		case ParsingHeaders:
+			if startsWithWhitespace(data) {
+				return parsedLen, ErrObsFold
+			}
 			len, done, err := r.Headers.Parse(data)
 			if err != nil {
 				return parsedLen, err
@@ -146,6 +274,9 @@ outer:
 				break outer
 			}
 
+			if !done {
+				r.headerOrder = recordFieldName(r.headerOrder, data[:len])
+			}
 			data = data[len:]
 			parsedLen += len
 			if done {
@@ -153,42 +284,73 @@ outer:
 			}
 
 		case ParsingBody:
-			cl, isPresent := r.Headers.Get("Content-Length")
-			if !isPresent {
-				r.state = Done
-				continue
-			}
-
-			length, err := checkContentLength(cl)
+			length, chunked, err := r.bodyFraming()
 			if err != nil {
 				return parsedLen, err
 			}
+			if r.headOnly && (chunked || length > 0) {
+				// the body is left to BodyReader
+				break outer
+			}
+			if chunked {
+				r.state = ParsingChunkedBody
+				continue
+			}
 			if length == 0 {
 				r.state = Done
 				continue
 			}
 
-			r.Body = append(r.Body, data...)
-			parsedLen += len(data)
-			data = data[len(data):]
+			// the request ends with its body, without waiting for the
+			// client to close the connection
+			n := min(length-len(r.Body), len(data))
+			r.Body = append(r.Body, data[:n]...)
+			parsedLen += n
+			data = data[n:]
 
-			if len(r.Body) > length {
-				return parsedLen, fmt.Errorf("Received Body of length greater than content length")
-			} else if len(r.Body) == length {
+			if len(r.Body) == length {
 				r.state = Done
 			} else {
 				break outer
 			}
 
-		case Done:
-			cl, _ := r.Headers.Get("Content-Length")
-			length, err := checkContentLength(cl)
+		case ParsingChunkedBody:
+			len, done, err := r.parseChunk(data)
 			if err != nil {
 				return parsedLen, err
 			}
-			if length > 0 && len(data) > 0 {
-				return parsedLen, fmt.Errorf("Received Body of length greater than content length")
+			data = data[len:]
+			parsedLen += len
+			if done {
+				r.state = ParsingTrailers
+			} else if len == 0 {
+				break outer
+			}
+
+		case ParsingTrailers:
+			if startsWithWhitespace(data) {
+				return parsedLen, ErrObsFold
 			}
+			len, done, err := r.Trailers.Parse(data)
+			if err != nil {
+				return parsedLen, err
+			}
+			if len == 0 {
+				break outer
+			}
+
+			if !done {
+				r.trailerOrder = recordFieldName(r.trailerOrder, data[:len])
+			}
+			data = data[len:]
+			parsedLen += len
+			if done {
+				r.state = Done
+			}
+
+		case Done:
+			// the bytes after the request belong to the next message, they
+			// are kept as Buffered
 			break outer
 
 		default:
@@ -199,10 +361,129 @@ outer:
 	return parsedLen, nil
 }
 
+// bodyFraming returns how the body of the request is framed: chunked, or
+// with a length, zero when there is no Content-Length
+func (r *Request) bodyFraming() (length int, chunked bool, err error) {
+	if err := checkTransferEncoding(r.Headers); err != nil {
+		return 0, false, err
+	}
+	if r.IsChunked() {
+		return 0, true, nil
+	}
+	cl, isPresent := r.Headers.Get("Content-Length")
+	if !isPresent {
+		return 0, false, nil
+	}
+	if cl == "" {
+		return 0, false, fmt.Errorf("Empty Content-Length")
+	}
+	length, err = checkContentLength(cl)
+	return length, false, err
+}
+
+// checkTransferEncoding rejects a Transfer-Encoding that does not end with
+// chunked, as the length of the body could not be determined (RFC 9112
+// section 6.3), that applies chunked more than once, or that comes with a
+// Content-Length, which is a request smuggling attempt
+func checkTransferEncoding(h headers.Headers) error {
+	te, isPresent := h.Get("Transfer-Encoding")
+	if !isPresent {
+		return nil
+	}
+	if _, hasLength := h.Get("Content-Length"); hasLength {
+		return fmt.Errorf("%w: sent with Content-Length", ErrInvalidTransferEncoding)
+	}
+	codings := strings.Split(te, ",")
+	for i, coding := range codings {
+		isChunked := strings.EqualFold(strings.TrimSpace(coding), "chunked")
+		if isChunked != (i == len(codings)-1) {
+			return fmt.Errorf("%w: %q", ErrInvalidTransferEncoding, te)
+		}
+	}
+	return nil
+}
+
+// startsWithWhitespace reports whether a field line starts with SP or HTAB,
+// either the obs-fold continuation of the previous line or whitespace after
+// the start line. Both are rejected (RFC 9112 sections 2.2 and 5.2)
+func startsWithWhitespace(data []byte) bool {
+	return len(data) > 0 && (data[0] == ' ' || data[0] == '\t')
+}
+
+// IsChunked reports whether the request body uses the chunked transfer coding
+func (r *Request) IsChunked() bool {
+	te, isPresent := r.Headers.Get("Transfer-Encoding")
+	if !isPresent {
+		return false
+	}
+	codings := strings.Split(te, ",")
+	return strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+}
+
+// parseChunk consumes a single complete chunk from data. It returns 0 bytes
+// parsed if the chunk has not been fully received yet, and done once the
+// last (zero sized) chunk has been read
+func (r *Request) parseChunk(data []byte) (int, bool, error) {
+	sizeLine, rest, found := bytes.Cut(data, []byte(CRLF))
+	if !found {
+		return 0, false, nil
+	}
+
+	size, err := parseChunkSize(sizeLine)
+	if err != nil {
+		return 0, false, err
+	}
+
+	headerLen := len(sizeLine) + len(CRLF)
+	if size == 0 {
+		return headerLen, true, nil
+	}
+
+	if len(rest) < size+len(CRLF) {
+		return 0, false, nil
+	}
+	if string(rest[size:size+len(CRLF)]) != CRLF {
+		return 0, false, fmt.Errorf("Chunk data is not followed by CRLF")
+	}
+
+	r.Body = append(r.Body, rest[:size]...)
+	return headerLen + size + len(CRLF), false, nil
+}
+
+// parseChunkSize parses the size of a chunk from its size line, ignoring
+// the chunk extensions
+func parseChunkSize(sizeLine []byte) (int, error) {
+	sizeStr, _, _ := bytes.Cut(sizeLine, []byte(";"))
+	sizeStr = bytes.TrimRight(sizeStr, " \t")
+	size, err := strconv.ParseUint(string(sizeStr), 16, 31)
+	if err != nil || len(sizeStr) == 0 {
+		return 0, fmt.Errorf("Invalid chunk size %q", sizeStr)
+	}
+	return int(size), nil
+}
+
+// recordFieldName appends the name of a parsed field line to order, keeping
+// only its first occurrence
+func recordFieldName(order []string, line []byte) []string {
+	name, _, _ := bytes.Cut(line, []byte(":"))
+	key := strings.TrimSpace(string(name))
+	for _, existing := range order {
+		if strings.EqualFold(existing, key) {
+			return order
+		}
+	}
+	return append(order, key)
+}
+
+// checkContentLength parses a Content-Length value, only made of digits
+// (RFC 9110 section 8.6). An empty value stands for a missing field
 func checkContentLength(cl string) (int, error) {
 	if len(cl) == 0 {
 		return 0, nil
 	}
+	if strings.Trim(cl, "0123456789") != "" {
+		return 0, fmt.Errorf("Invalid Content-Length %q", cl)
+	}
 
 	len, err := strconv.Atoi(cl)
 	if err != nil {
@@ -287,7 +287,8 @@ func TestBody(t *testing.T) {
 		require.NotNil(t, r)
 		assert.Equal(t, "", string(r.Body))
 
-		// Test: Body longer then reported length
+		// Test: Body longer then reported length, the rest belongs to the
+		// next message
 		reader = &chunkReader{
 			data: "POST /submit HTTP/1.1\r\n" +
 				"Host: localhost:8080\r\n" +
@@ -296,8 +297,29 @@ func TestBody(t *testing.T) {
 				"partial content sdfghjkuytrertyuioiasjhd",
 			numBytesPerRead: byteSize,
 		}
-		_, err = RequestFromReader(reader)
-		require.Error(t, err)
+		r, err = RequestFromReader(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "partial content sdfg", string(r.Body))
+		// what was read past the body is buffered, the rest is left unread
+		assert.Equal(t, "hjkuytrertyuioiasjhd", string(r.Buffered())+reader.data[reader.pos:])
 	}
 
-}
\ No newline at end of file
+}
+
+func TestBuffered(t *testing.T) {
+	// Test: Bytes after a request without a body are kept
+	r, err := RequestFromReader(&chunkReader{
+		data:            "GET /chat HTTP/1.1\r\nUpgrade: websocket\r\n\r\n\x81\x05hello",
+		numBytesPerRead: 1024,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("\x81\x05hello"), r.Buffered())
+
+	// Test: Nothing is buffered when the request ends with the read
+	r, err = RequestFromReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:8080\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, r.Buffered())
+}
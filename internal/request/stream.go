@@ -0,0 +1,162 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// bodyReader reads the body of a request parsed by RequestHeadFromReader
+// from the connection, removing the chunked framing. The trailers of a
+// chunked body are added to the request once the last chunk is read
+type bodyReader struct {
+	req     *Request
+	src     *bufio.Reader
+	chunked bool
+	// remaining is the number of bytes left in the body, or in the current
+	// chunk of a chunked body
+	remaining int
+	err       error
+}
+
+func newBodyReader(req *Request, src io.Reader) *bodyReader {
+	length, chunked, _ := req.bodyFraming()
+	return &bodyReader{req: req, src: bufio.NewReader(src), chunked: chunked, remaining: length}
+}
+
+// BodyReader returns a reader of the body. It streams the body from the
+// connection for requests parsed by RequestHeadFromReader, and reads Body
+// otherwise
+func (r *Request) BodyReader() io.Reader {
+	if r.stream != nil {
+		return r.stream
+	}
+	return bytes.NewReader(r.Body)
+}
+
+// BodyStreamed reports whether the body is read from the connection with
+// BodyReader rather than held in Body. Such a body can only be read once
+func (r *Request) BodyStreamed() bool {
+	return r.stream != nil
+}
+
+// BufferBody reads a streamed body into memory, so that it can be inspected
+// before the handler reads it from BodyReader, which then returns the
+// buffered copy. The body is bounded by maxSize, or
+// DefaultMaxDecodedBodySize when it is not positive. Bodies that are not
+// streamed are returned as is
+func (r *Request) BufferBody(maxSize int64) ([]byte, error) {
+	if r.stream == nil {
+		return r.Body, nil
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDecodedBodySize
+	}
+	body, err := io.ReadAll(io.LimitReader(r.stream, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxSize {
+		return nil, ErrBodyTooLarge
+	}
+	r.stream = bytes.NewReader(body)
+	return body, nil
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.chunked && b.remaining == 0 {
+		b.err = b.nextChunk()
+		if b.err != nil {
+			return 0, b.err
+		}
+	}
+	if b.remaining == 0 {
+		b.err = io.EOF
+		return 0, b.err
+	}
+
+	n, err := b.src.Read(p[:min(len(p), b.remaining)])
+	b.remaining -= n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && b.chunked && b.remaining == 0 {
+		err = b.chunkEnd()
+	}
+	b.err = err
+	return n, err
+}
+
+// nextChunk reads the size line of the next chunk, and the trailers when it
+// is the last one
+func (b *bodyReader) nextChunk() error {
+	line, err := b.readLine()
+	if err != nil {
+		return err
+	}
+	size, err := parseChunkSize(line[:len(line)-len(CRLF)])
+	if err != nil {
+		return err
+	}
+	if size > 0 {
+		b.remaining = size
+		return nil
+	}
+
+	for {
+		line, err := b.readLine()
+		if err != nil {
+			return err
+		}
+		if startsWithWhitespace(line) {
+			return ErrObsFold
+		}
+		_, done, err := b.req.Trailers.Parse(line)
+		if err != nil {
+			return err
+		}
+		if done {
+			return io.EOF
+		}
+		b.req.trailerOrder = recordFieldName(b.req.trailerOrder, line)
+	}
+}
+
+// chunkEnd reads the CRLF following the data of a chunk
+func (b *bodyReader) chunkEnd() error {
+	var end [2]byte
+	_, err := io.ReadFull(b.src, end[:])
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	if string(end[:]) != CRLF {
+		return fmt.Errorf("Chunk data is not followed by CRLF")
+	}
+	return nil
+}
+
+// readLine reads a line ending with CRLF, bounded by the size of the buffer
+func (b *bodyReader) readLine() ([]byte, error) {
+	line, err := b.src.ReadSlice('\n')
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("Chunk line is too long")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(line, []byte(CRLF)) {
+		return nil, fmt.Errorf("Chunk line does not end with CRLF")
+	}
+	return line, nil
+}
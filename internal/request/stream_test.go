@@ -0,0 +1,101 @@
+package request
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestHeadFromReader(t *testing.T) {
+	// Test: Content-Length body is left to BodyReader
+	reader := &chunkReader{
+		data:            "POST /upload HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 11\r\n\r\nhello world",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestHeadFromReader(reader)
+	require.NoError(t, err)
+	assert.True(t, r.BodyStreamed())
+	assert.Empty(t, r.Body)
+	assert.Empty(t, r.Buffered())
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+
+	// Test: Chunked body is read without its framing, trailers included
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n" +
+			"5\r\nhello\r\n6;ext=1\r\n world\r\n0\r\nX-Checksum: abc\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	r, err = RequestHeadFromReader(reader)
+	require.NoError(t, err)
+	require.True(t, r.BodyStreamed())
+	body, err = io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	checksum, _ := r.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc", checksum)
+
+	// Test: Streamed body is written chunked with its trailers
+	r, err = RequestHeadFromReader(&chunkReader{
+		data:            "POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n",
+		numBytesPerRead: 64,
+	})
+	require.NoError(t, err)
+	var written bytes.Buffer
+	require.NoError(t, r.Write(&written))
+	assert.Equal(t, "POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n", written.String())
+
+	// Test: Request without a body is parsed whole
+	r, err = RequestHeadFromReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 5,
+	})
+	require.NoError(t, err)
+	assert.False(t, r.BodyStreamed())
+
+	// Test: Body cut short by the connection
+	r, err = RequestHeadFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nContent-Length: 20\r\n\r\npartial",
+		numBytesPerRead: 8,
+	})
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader())
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Malformed chunk size
+	r, err = RequestHeadFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 8,
+	})
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader())
+	assert.Error(t, err)
+}
+
+func TestBufferBody(t *testing.T) {
+	raw := "POST /upload HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5\r\nhello\r\n6\r\n world\r\n0\r\nX-Checksum: abc\r\n\r\n"
+
+	// Test: Buffered body is read again from BodyReader, trailers included
+	r, err := RequestHeadFromReader(&chunkReader{data: raw, numBytesPerRead: 4})
+	require.NoError(t, err)
+	body, err := r.BufferBody(0)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.True(t, r.BodyStreamed())
+	checksum, _ := r.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc", checksum)
+	body, err = io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+
+	// Test: Body over the limit
+	r, err = RequestHeadFromReader(&chunkReader{data: raw, numBytesPerRead: 4})
+	require.NoError(t, err)
+	_, err = r.BufferBody(10)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+}
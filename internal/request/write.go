@@ -0,0 +1,185 @@
+package request
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"httpfromtcp/internal/headers"
+)
+
+// Write serializes the request in HTTP/1.1 wire format: the request line,
+// the headers in the order they were received, and the body framed either
+// with Content-Length or with the chunked transfer coding. Headers that were
+// added after parsing are written after the received ones, sorted by name.
+// Repeated field lines are written once with their comma-joined value,
+// except Set-Cookie. A streamed body is copied from BodyReader as it
+// arrives, chunked when the request carries no framing headers.
+func (r *Request) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	// requests received over HTTP/2 are forwarded as HTTP/1.1 as well
+	_, err := fmt.Fprintf(bw, "%s %s HTTP/1.1%s", r.RequestLine.Method, r.RequestLine.RequestTarget, CRLF)
+	if err != nil {
+		return err
+	}
+
+	err = writeFields(bw, r.Headers, r.headerOrder)
+	if err != nil {
+		return err
+	}
+
+	chunked := r.IsChunked()
+	_, hasLength := r.Headers.Get("Content-Length")
+	switch {
+	case chunked || hasLength:
+	case r.stream != nil:
+		_, err = fmt.Fprintf(bw, "Transfer-Encoding: chunked%s", CRLF)
+		chunked = true
+	case len(r.Body) > 0:
+		_, err = fmt.Fprintf(bw, "Content-Length: %d%s", len(r.Body), CRLF)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = bw.WriteString(CRLF)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case chunked:
+		err = r.writeChunkedBody(bw)
+	case r.stream != nil:
+		err = r.copyStream(bw, func(p []byte) error {
+			_, err := bw.Write(p)
+			return err
+		})
+	default:
+		_, err = bw.Write(r.Body)
+	}
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// orderedNames returns the names of the fields in h to serialize, received
+// fields first in their original case and order
+func orderedNames(h headers.Headers, order []string) []string {
+	names := make([]string, 0, len(h))
+	seen := make(map[string]bool, len(h))
+	for _, name := range order {
+		key := strings.ToLower(name)
+		if _, isPresent := h[key]; isPresent && !seen[key] {
+			names = append(names, name)
+			seen[key] = true
+		}
+	}
+
+	var added []string
+	for key := range h {
+		if !seen[key] {
+			added = append(added, key)
+		}
+	}
+	sort.Strings(added)
+	return append(names, added...)
+}
+
+// writeFields writes the field lines of h, Set-Cookie values on lines of
+// their own
+func writeFields(w io.Writer, h headers.Headers, order []string) error {
+	for _, name := range orderedNames(h, order) {
+		for _, value := range h.Values(name) {
+			_, err := fmt.Fprintf(w, "%s: %s%s", name, value, CRLF)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Request) writeChunkedBody(w io.Writer) error {
+	var err error
+	if r.stream != nil {
+		err = r.copyStream(w, func(p []byte) error {
+			return writeChunk(w, p)
+		})
+	} else {
+		err = writeChunk(w, r.Body)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "0"+CRLF)
+	if err != nil {
+		return err
+	}
+
+	err = writeFields(w, r.Trailers, r.trailerOrder)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, CRLF)
+	return err
+}
+
+// writeChunk writes p as one chunk, or nothing when it is empty since an
+// empty chunk ends the body
+func writeChunk(w io.Writer, p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(w, "%X%s", len(p), CRLF)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(p)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, CRLF)
+	return err
+}
+
+// copyStream passes what BodyReader returns to write as it arrives. A
+// buffered w is flushed so that the head and every piece of the body are
+// sent without delay
+func (r *Request) copyStream(w io.Writer, write func([]byte) error) error {
+	flush := func() error { return nil }
+	if f, ok := w.(interface{ Flush() error }); ok {
+		flush = f.Flush
+	}
+	err := flush()
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.stream.Read(buf)
+		if n > 0 {
+			werr := write(buf[:n])
+			if werr == nil {
+				werr = flush()
+			}
+			if werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
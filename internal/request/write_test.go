@@ -0,0 +1,115 @@
+package request
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteRoundTrip(t *testing.T) {
+	inputDataStrs := [...]string{
+		"GET / HTTP/1.1\r\nHost: localhost:8080\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+		"GET /coffee HTTP/1.1\r\n\r\n",
+		"POST /submit HTTP/1.1\r\nHost: localhost:8080\r\nContent-Length: 13\r\n\r\nhello world!\n",
+		"POST /submit HTTP/1.1\r\nHost: localhost:8080\r\nContent-Length: 0\r\n\r\n",
+		"POST /submit HTTP/1.1\r\nHost: localhost:8080\r\nTransfer-Encoding: chunked\r\n\r\nD\r\nhello world!\n\r\n0\r\n\r\n",
+		"POST /submit HTTP/1.1\r\nHost: localhost:8080\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n5\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n",
+		"POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+	}
+
+	for _, str := range inputDataStrs {
+		for byteSize := 1; byteSize < len(str)+5; byteSize += 5 {
+			reader := &chunkReader{
+				data:            str,
+				numBytesPerRead: byteSize,
+			}
+			r, err := RequestFromReader(reader)
+			require.NoError(t, err)
+
+			var out bytes.Buffer
+			err = r.Write(&out)
+			require.NoError(t, err)
+			assert.Equal(t, str, out.String())
+
+			reparsed, err := RequestFromReader(&chunkReader{data: out.String(), numBytesPerRead: byteSize})
+			require.NoError(t, err)
+			assert.Equal(t, r.RequestLine, reparsed.RequestLine)
+			assert.Equal(t, r.Headers, reparsed.Headers)
+			assert.Equal(t, r.Body, reparsed.Body)
+			assert.Equal(t, r.Trailers, reparsed.Trailers)
+		}
+	}
+}
+
+func TestWriteFraming(t *testing.T) {
+	// Test: Chunked body split over several chunks is rewritten as one chunk
+	reader := &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n7\r\n world!\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(r.Body))
+
+	var out bytes.Buffer
+	require.NoError(t, r.Write(&out))
+	assert.Equal(t, "POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nC\r\nhello world!\r\n0\r\n\r\n", out.String())
+
+	// Test: Missing Content-Length is added for a body set by the caller
+	r, err = RequestFromReader(&chunkReader{data: "PUT /item HTTP/1.1\r\nHost: localhost:8080\r\n\r\n", numBytesPerRead: 8})
+	require.NoError(t, err)
+	r.Body = []byte("payload")
+	r.Headers.Put("X-Added", "yes")
+
+	out.Reset()
+	require.NoError(t, r.Write(&out))
+	assert.Equal(t, "PUT /item HTTP/1.1\r\nHost: localhost:8080\r\nx-added: yes\r\nContent-Length: 7\r\n\r\npayload", out.String())
+
+	reparsed, err := RequestFromReader(&chunkReader{data: out.String(), numBytesPerRead: 4})
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(reparsed.Body))
+
+	// Test: Repeated headers are joined into a single line
+	r, err = RequestFromReader(&chunkReader{data: "GET / HTTP/1.1\r\nAccept: text/html\r\nAccept: */*\r\n\r\n", numBytesPerRead: 8})
+	require.NoError(t, err)
+	out.Reset()
+	require.NoError(t, r.Write(&out))
+	assert.Equal(t, "GET / HTTP/1.1\r\nAccept: text/html, */*\r\n\r\n", out.String())
+
+	// Test: Repeated Set-Cookie fields keep a line each, in trailers too
+	r, err = RequestFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nSet-Cookie: a=1\r\nSet-Cookie: b=2\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nSet-Cookie: c=3\r\nSet-Cookie: d=4\r\n\r\n",
+		numBytesPerRead: 8,
+	})
+	require.NoError(t, err)
+	out.Reset()
+	require.NoError(t, r.Write(&out))
+	assert.Equal(t, "POST / HTTP/1.1\r\nSet-Cookie: a=1\r\nSet-Cookie: b=2\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nSet-Cookie: c=3\r\nSet-Cookie: d=4\r\n\r\n", out.String())
+	assert.NotContains(t, strings.ReplaceAll(out.String(), "\r\n", ""), "\n")
+}
+
+func TestChunkedBody(t *testing.T) {
+	// Test: Invalid chunk size
+	_, err := RequestFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 4,
+	})
+	require.Error(t, err)
+
+	// Test: Chunk data longer than chunk size
+	_, err = RequestFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 4,
+	})
+	require.Error(t, err)
+
+	// Test: Missing last chunk
+	_, err = RequestFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n",
+		numBytesPerRead: 4,
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,216 @@
+package response
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"httpfromtcp/internal/headers"
+)
+
+const (
+	EncodingGzip     = "gzip"
+	EncodingDeflate  = "deflate"
+	EncodingIdentity = "identity"
+
+	// bodies with a known length below this size are not worth compressing
+	minCompressSize = 256
+)
+
+// supportedEncodings lists the content codings in order of preference
+var supportedEncodings = []string{EncodingGzip, EncodingDeflate}
+
+// compressibleTypes are the media types compressed in addition to text/*
+var compressibleTypes = []string{
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// NegotiateEncoding picks the content coding for the response from the
+// Accept-Encoding request header (RFC 9110 section 12.5.3). It returns
+// EncodingIdentity when no supported coding is acceptable
+func NegotiateEncoding(acceptEncoding string) string {
+	qvalues := make(map[string]float64)
+	wildcard := -1.0
+	for _, element := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(element, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if found && strings.EqualFold(name, "q") {
+				parsed, err := strconv.ParseFloat(value, 64)
+				if err != nil || parsed < 0 || parsed > 1 {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		if coding == "*" {
+			wildcard = q
+		} else if coding == "x-gzip" {
+			qvalues[EncodingGzip] = q
+		} else {
+			qvalues[coding] = q
+		}
+	}
+
+	best := EncodingIdentity
+	bestQ := 0.0
+	for _, coding := range supportedEncodings {
+		q, isPresent := qvalues[coding]
+		if !isPresent {
+			q = max(wildcard, 0)
+		}
+		if q > bestQ {
+			best = coding
+			bestQ = q
+		}
+	}
+	return best
+}
+
+// EnableCompression makes the writer compress the body with the coding
+// negotiated from acceptEncoding when the response is eligible: it has a
+// body, a compressible content type, no Content-Encoding or Content-Range,
+// and is not known to be smaller than a few hundred bytes. Compressed bodies
+// are sent with chunked framing since their length is not known upfront,
+// and a strong ETag is made weak. It must be called before the headers are
+// written
+func (w *Writer) EnableCompression(acceptEncoding string) {
+	w.acceptEncoding = acceptEncoding
+	w.compression = true
+}
+
+// listsField reports whether the comma-separated list in the header key
+// contains name, compared case-insensitively
+func listsField(h headers.Headers, key, name string) bool {
+	value, _ := h.Get(key)
+	for _, field := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(field), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareCompression adjusts the headers of an eligible response and sets
+// up the encoder used for the body
+func (w *Writer) prepareCompression(h headers.Headers) error {
+	if !w.compression || !isCompressible(w.statusCode, h) {
+		return nil
+	}
+	if !listsField(h, "Vary", "Accept-Encoding") {
+		h.Put("Vary", "Accept-Encoding")
+	}
+
+	if cl, isPresent := h.Get("Content-Length"); isPresent {
+		length, err := strconv.Atoi(cl)
+		if err == nil && length < minCompressSize {
+			return nil
+		}
+	}
+
+	encoding := NegotiateEncoding(w.acceptEncoding)
+	if encoding == EncodingIdentity {
+		return nil
+	}
+
+	h.Remove("Content-Length")
+	h.Replace("content-encoding", encoding)
+	// the compressed representation is not byte for byte the one the strong
+	// validator was computed for (RFC 9110 section 8.8.1)
+	if etag, isPresent := h.Get("ETag"); isPresent && !strings.HasPrefix(etag, "W/") {
+		h.Replace("etag", "W/"+etag)
+	}
+	if te, isPresent := h.Get("Transfer-Encoding"); !isPresent || !strings.Contains(strings.ToLower(te), "chunked") {
+		h.Replace("transfer-encoding", "chunked")
+	}
+
+	var err error
+	chunks := chunkWriter{w}
+	switch encoding {
+	case EncodingGzip:
+		w.encoder = gzip.NewWriter(chunks)
+	case EncodingDeflate:
+		w.encoder = zlib.NewWriter(chunks)
+	default:
+		err = fmt.Errorf("Unsupported content coding %s", encoding)
+	}
+	return err
+}
+
+func isCompressible(statusCode StatusCode, h headers.Headers) bool {
+	if statusCode < 200 || statusCode == 204 || statusCode == 206 || statusCode == 304 {
+		return false
+	}
+	if _, isPresent := h.Get("Content-Encoding"); isPresent {
+		return false
+	}
+	if _, isPresent := h.Get("Content-Range"); isPresent {
+		return false
+	}
+	contentType, _ := h.Get("Content-Type")
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "text/event-stream" {
+		// compressors buffer output, which would hold back events
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	for _, compressible := range compressibleTypes {
+		if mediaType == compressible {
+			return true
+		}
+	}
+	return false
+}
+
+// closeEncoder flushes the remaining compressed data and writes the last
+// chunk
+func (w *Writer) closeEncoder() error {
+	err := w.encoder.Close()
+	w.encoder = nil
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte("0\r\n"))
+	if err != nil {
+		return err
+	}
+	w.state = StateChunkedBodyDone
+	return nil
+}
+
+// chunkWriter frames the output of an encoder as chunks
+type chunkWriter struct {
+	w *Writer
+}
+
+func (c chunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	_, err := fmt.Fprintf(c.w, "%X%s%s%s", len(p), headers.CRLF, p, headers.CRLF)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,184 @@
+package response
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"httpfromtcp/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                          EncodingIdentity,
+		"gzip":                      EncodingGzip,
+		"deflate":                   EncodingDeflate,
+		"gzip, deflate, br":         EncodingGzip,
+		"deflate, gzip":             EncodingGzip,
+		"gzip;q=0.5, deflate;q=0.8": EncodingDeflate,
+		"gzip;q=0, deflate;q=0":     EncodingIdentity,
+		"br":                        EncodingIdentity,
+		"*":                         EncodingGzip,
+		"*;q=0.1, gzip;q=0":         EncodingDeflate,
+		"identity;q=1, *;q=0":       EncodingIdentity,
+		"GZIP ; Q=0.3":              EncodingGzip,
+		"x-gzip":                    EncodingGzip,
+		"gzip;q=abc, deflate;q=0.2": EncodingDeflate,
+	}
+	for acceptEncoding, expected := range cases {
+		assert.Equal(t, expected, NegotiateEncoding(acceptEncoding), acceptEncoding)
+	}
+}
+
+func readResponse(t *testing.T, w *Writer) *http.Response {
+	t.Helper()
+	require.NoError(t, w.Finish())
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(w.ReadBuffer())), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestCompression(t *testing.T) {
+	body := strings.Repeat("<p>Your request was an absolute banger.</p>\n", 50)
+
+	// Test: Body with known length is gzipped and sent chunked
+	w := NewWriter()
+	w.EnableCompression("gzip, deflate")
+	require.NoError(t, w.WriteStatusLine(StatusOk))
+	header := GetDefaultHeader(len(body))
+	header.Replace("content-type", "text/html")
+	header.Put("ETag", `"v1"`)
+	require.NoError(t, w.WriteHeaders(header))
+	_, err := w.WriteBody(body)
+	require.NoError(t, err)
+
+	resp := readResponse(t, &w)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, `W/"v1"`, resp.Header.Get("ETag"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	gz, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(decoded))
+
+	// Test: Chunked body with trailers is deflated
+	w = NewWriter()
+	w.EnableCompression("deflate")
+	require.NoError(t, w.WriteStatusLine(StatusOk))
+	header = headers.NewHeaders()
+	header.Put("Content-Type", "application/json")
+	header.Put("Transfer-Encoding", "chunked")
+	header.Put("Trailer", "X-Count")
+	require.NoError(t, w.WriteHeaders(header))
+	for i := 0; i < 20; i++ {
+		_, err = w.WriteChunkedBody([]byte(`{"flavor":"dark mode"}`))
+		require.NoError(t, err)
+	}
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailer := headers.NewHeaders()
+	trailer.Put("X-Count", "20")
+	require.NoError(t, w.WriteTrailers(trailer))
+
+	resp = readResponse(t, &w)
+	assert.Equal(t, "deflate", resp.Header.Get("Content-Encoding"))
+	zr, err := zlib.NewReader(resp.Body)
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat(`{"flavor":"dark mode"}`, 20), string(decoded))
+	assert.Equal(t, "20", resp.Trailer.Get("X-Count"))
+
+	// Test: Streamed body through CopyBody
+	w = NewWriter()
+	w.EnableCompression("gzip")
+	require.NoError(t, w.WriteStatusLine(StatusOk))
+	header = headers.NewHeaders()
+	header.Put("Content-Type", "text/plain; charset=utf-8")
+	require.NoError(t, w.WriteHeaders(header))
+	_, err = w.CopyBody(strings.NewReader(body))
+	require.NoError(t, err)
+
+	resp = readResponse(t, &w)
+	gz, err = gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(decoded))
+}
+
+func TestCompressionSkipped(t *testing.T) {
+	body := strings.Repeat("a", 1024)
+	cases := []struct {
+		name        string
+		status      StatusCode
+		contentType string
+		length      int
+		extra       map[string]string
+		vary        bool
+	}{
+		{name: "already compressed media", status: StatusOk, contentType: "video/mp4", length: len(body)},
+		{name: "small body", status: StatusOk, contentType: "text/plain", length: 10, vary: true},
+		{name: "event stream", status: StatusOk, contentType: "text/event-stream", length: len(body)},
+		{name: "partial content", status: StatusPartialContent, contentType: "text/plain", length: len(body)},
+		{name: "already encoded", status: StatusOk, contentType: "text/plain", length: len(body), extra: map[string]string{"Content-Encoding": "br"}},
+	}
+
+	for _, c := range cases {
+		w := NewWriter()
+		w.EnableCompression("gzip")
+		require.NoError(t, w.WriteStatusLine(c.status))
+		header := GetDefaultHeader(c.length)
+		header.Replace("content-type", c.contentType)
+		for key, value := range c.extra {
+			header.Put(key, value)
+		}
+		require.NoError(t, w.WriteHeaders(header))
+		_, err := w.WriteBody(body[:c.length])
+		require.NoError(t, err)
+
+		resp := readResponse(t, &w)
+		assert.NotEqual(t, "gzip", resp.Header.Get("Content-Encoding"), c.name)
+		assert.Equal(t, int64(c.length), resp.ContentLength, c.name)
+		if c.vary {
+			assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"), c.name)
+		}
+	}
+
+	// Test: Client without Accept-Encoding gets identity but a Vary header
+	w := NewWriter()
+	w.EnableCompression("")
+	require.NoError(t, w.WriteStatusLine(StatusOk))
+	header := GetDefaultHeader(len(body))
+	header.Put("ETag", `"v1"`)
+	require.NoError(t, w.WriteHeaders(header))
+	_, err := w.WriteBody(body)
+	require.NoError(t, err)
+	resp := readResponse(t, &w)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, `"v1"`, resp.Header.Get("ETag"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+
+	// Test: Accept-Encoding already listed in Vary is not repeated
+	w = NewWriter()
+	w.EnableCompression("")
+	require.NoError(t, w.WriteStatusLine(StatusOk))
+	header = GetDefaultHeader(len(body))
+	header.Put("Vary", "Origin, accept-encoding")
+	require.NoError(t, w.WriteHeaders(header))
+	_, err = w.WriteBody(body)
+	require.NoError(t, err)
+	resp = readResponse(t, &w)
+	assert.Equal(t, "Origin, accept-encoding", resp.Header.Get("Vary"))
+}
@@ -0,0 +1,17 @@
+package response
+
+import (
+	"httpfromtcp/internal/cookie"
+	"httpfromtcp/internal/headers"
+)
+
+// SetCookie adds a Set-Cookie field for the cookie to the headers. Every
+// cookie is written on its own header line
+func SetCookie(h headers.Headers, c *cookie.Cookie) error {
+	err := c.Valid()
+	if err != nil {
+		return err
+	}
+	h.Put("Set-Cookie", c.String())
+	return nil
+}
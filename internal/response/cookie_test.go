@@ -0,0 +1,51 @@
+package response
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/cookie"
+	"httpfromtcp/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetCookie(t *testing.T) {
+	header := GetDefaultHeader(0)
+	expires := time.Date(2026, time.March, 1, 9, 30, 0, 0, time.UTC)
+	require.NoError(t, SetCookie(header, &cookie.Cookie{Name: "session", Value: "abc", Expires: expires, HttpOnly: true}))
+	require.NoError(t, SetCookie(header, &cookie.Cookie{Name: "theme", Value: "dark", Path: "/"}))
+	assert.ErrorIs(t, SetCookie(header, &cookie.Cookie{Name: "bad name"}), cookie.ErrInvalidCookie)
+
+	w := NewWriter()
+	require.NoError(t, w.WriteStatusLine(StatusOk))
+	require.NoError(t, w.WriteHeaders(header))
+	_, err := w.WriteBody("")
+	require.NoError(t, err)
+
+	// every cookie is on its own line, the comma in Expires stays intact
+	raw := w.ReadBuffer()
+	assert.Contains(t, raw, "set-cookie: session=abc; Expires=Sun, 01 Mar 2026 09:30:00 GMT; HttpOnly\r\n")
+	assert.Contains(t, raw, "set-cookie: theme=dark; Path=/\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(raw)), nil)
+	require.NoError(t, err)
+	assert.Len(t, resp.Cookies(), 2)
+}
+
+func TestBeforeWriteHeaders(t *testing.T) {
+	w := NewWriter()
+	var status StatusCode
+	w.BeforeWriteHeaders(func(statusCode StatusCode, h headers.Headers) {
+		status = statusCode
+		require.NoError(t, SetCookie(h, &cookie.Cookie{Name: "hook", Value: "1"}))
+	})
+	w.WriteResponse(StatusBadRequest, "bad")
+
+	assert.Equal(t, StatusBadRequest, status)
+	assert.Contains(t, w.ReadBuffer(), "set-cookie: hook=1\r\n")
+}
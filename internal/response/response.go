@@ -1,9 +1,12 @@
 package response
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"httpfromtcp/internal/headers"
+	"io"
+	"net"
 	"strconv"
 )
 
@@ -11,19 +14,118 @@ type StatusCode int
 type WriterState string
 
 const (
+	StatusContinue StatusCode = 100
+	StatusSwitchingProtocols StatusCode = 101
 	StatusOk StatusCode = 200
+	StatusNoContent StatusCode = 204
+	StatusPartialContent StatusCode = 206
+	StatusMovedPermanently StatusCode = 301
+	StatusNotModified StatusCode = 304
 	StatusBadRequest StatusCode = 400
+	StatusUnauthorized StatusCode = 401
+	StatusForbidden StatusCode = 403
+	StatusNotFound StatusCode = 404
+	StatusMethodNotAllowed StatusCode = 405
+	StatusProxyAuthRequired StatusCode = 407
+	StatusPreconditionFailed StatusCode = 412
+	StatusContentTooLarge StatusCode = 413
+	StatusUnsupportedMediaType StatusCode = 415
+	StatusUpgradeRequired StatusCode = 426
+	StatusTooManyRequests StatusCode = 429
+	StatusRangeNotSatisfiable StatusCode = 416
 	StatusInternalServerError StatusCode = 500
+	StatusBadGateway StatusCode = 502
+	StatusServiceUnavailable StatusCode = 503
+	StatusGatewayTimeout StatusCode = 504
 
 	StateReset WriterState = "Reset"
 	StateStatusLineDone WriterState = "Status Line Done"
 	StateHeadersDone WriterState = "Headers Completed"
+	StateChunkedBodyDone WriterState = "Chunked Body Done"
 	StateCompleted WriterState = "Completed"
+	StateHijacked WriterState = "Hijacked"
 )
 
+var statusText = map[StatusCode]string{
+	100: "Continue",
+	101: "Switching Protocols",
+	200: "OK",
+	201: "Created",
+	202: "Accepted",
+	204: "No Content",
+	206: "Partial Content",
+	301: "Moved Permanently",
+	302: "Found",
+	303: "See Other",
+	304: "Not Modified",
+	307: "Temporary Redirect",
+	308: "Permanent Redirect",
+	400: "Bad Request",
+	401: "Unauthorized",
+	403: "Forbidden",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	407: "Proxy Authentication Required",
+	408: "Request Timeout",
+	409: "Conflict",
+	411: "Length Required",
+	412: "Precondition Failed",
+	413: "Content Too Large",
+	414: "URI Too Long",
+	415: "Unsupported Media Type",
+	416: "Range Not Satisfiable",
+	426: "Upgrade Required",
+	429: "Too Many Requests",
+	431: "Request Header Fields Too Large",
+	500: "Internal Server Error",
+	501: "Not Implemented",
+	502: "Bad Gateway",
+	503: "Service Unavailable",
+	504: "Gateway Timeout",
+	505: "HTTP Version Not Supported",
+}
+
+// StatusText returns the standard reason phrase for the status code, or an
+// empty string if the code is unknown
+func StatusText(statusCode StatusCode) string {
+	return statusText[statusCode]
+}
+
+// isInterim reports whether the status code is a 1xx response followed by
+// another one. 101 ends the HTTP/1.1 exchange instead
+func isInterim(statusCode StatusCode) bool {
+	return statusCode >= 100 && statusCode < 200 && statusCode != StatusSwitchingProtocols
+}
+
+// bodyAllowed reports whether responses with the status code can have
+// content (RFC 9110 sections 15.3.5 and 15.4.5)
+func bodyAllowed(statusCode StatusCode) bool {
+	return statusCode >= 200 && statusCode != StatusNoContent && statusCode != StatusNotModified
+}
+
 type Writer struct {
 	buffer bytes.Buffer
+	// dst receives the response directly when the writer streams instead of
+	// buffering
+	dst io.Writer
+	// conn is the client connection of writers created by the server and
+	// buffered the bytes read from it past the end of the request
+	conn net.Conn
+	buffered []byte
 	state WriterState
+	statusCode StatusCode
+	// written counts the bytes of the response, headers included
+	written int64
+
+	compression bool
+	acceptEncoding string
+	// encoder compresses the body when compression was negotiated
+	encoder encoder
+
+	// headerHooks run right before the headers are written
+	headerHooks []func(StatusCode, headers.Headers)
+	// hijackHooks run when the connection is hijacked
+	hijackHooks []func() []byte
 }
 
 func NewWriter() Writer {
@@ -35,35 +137,128 @@ func NewWriter() Writer {
 	return writer
 }
 
+// NewStreamWriter returns a writer that writes the response to dst as it is
+// produced instead of buffering it
+func NewStreamWriter(dst io.Writer) Writer {
+	writer := NewWriter()
+	writer.dst = dst
+	return writer
+}
+
+// NewConnWriter returns a streaming writer for the client connection, which
+// lets handlers take over the connection with Hijack. buffered holds the
+// bytes already read from the connection but not parsed as the request
+func NewConnWriter(conn net.Conn, buffered []byte) Writer {
+	writer := NewStreamWriter(bufio.NewWriter(conn))
+	writer.conn = conn
+	writer.buffered = buffered
+	return writer
+}
+
+// Hijack hands the client connection over to the caller, after sending
+// anything written so far. It also returns the bytes the server read from
+// the connection after the end of the request, which the caller must
+// consume before reading from the connection. The server neither writes to,
+// reads from nor closes a hijacked connection
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.conn == nil {
+		return nil, nil, fmt.Errorf("Writer is not attached to a connection")
+	}
+	if w.state == StateHijacked {
+		return nil, nil, fmt.Errorf("Connection has already been hijacked")
+	}
+	err := w.Flush()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.state = StateHijacked
+	buffered := w.buffered
+	w.buffered = nil
+	for _, hook := range w.hijackHooks {
+		buffered = append(buffered, hook()...)
+	}
+	return w.conn, buffered, nil
+}
+
+// BeforeHijack registers fn to be called by Hijack before it hands the
+// connection over, for the server to stop reading from it. The bytes fn
+// returns were read from the connection and are added to those returned by
+// Hijack. Hooks run in the order they were registered
+func (w *Writer) BeforeHijack(fn func() []byte) {
+	w.hijackHooks = append(w.hijackHooks, fn)
+}
+
+func (w *Writer) Hijacked() bool {
+	return w.state == StateHijacked
+}
+
 func (w *Writer) ReadBuffer() string {
 	return w.buffer.String()
 }
 
+func (w *Writer) State() WriterState {
+	return w.state
+}
+
+// StatusCode returns the status code of the status line, or zero before it
+// is written
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// BytesWritten returns the number of bytes of the response written so far,
+// status line and headers included. Compressed bodies count their encoded
+// size
+func (w *Writer) BytesWritten() int64 {
+	return w.written
+}
+
 func (w *Writer) Write(data []byte) (int, error) {
-	n, err := w.buffer.Write(data)
+	if w.state == StateHijacked {
+		return 0, fmt.Errorf("Cannot write to a hijacked connection")
+	}
+	var n int
+	var err error
+	if w.dst != nil {
+		n, err = w.dst.Write(data)
+	} else {
+		n, err = w.buffer.Write(data)
+	}
+	w.written += int64(n)
 	return n, err
 }
 
+// Flush sends any data held by the destination of a streaming writer to the
+// client. It is a no-op for buffered writers
+func (w *Writer) Flush() error {
+	if w.encoder != nil {
+		err := w.encoder.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	flusher, ok := w.dst.(interface{ Flush() error })
+	if !ok {
+		return nil
+	}
+	return flusher.Flush()
+}
+
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineReason(statusCode, StatusText(statusCode))
+}
+
+// WriteStatusLineReason writes the status line with a custom reason phrase
+func (w *Writer) WriteStatusLineReason(statusCode StatusCode, reason string) error {
 	if w.state != StateReset {
 		return fmt.Errorf("Cannot write status line - status is %s", w.state)
 	}
-	var statusLine string
-	switch statusCode {
-	case StatusOk:
-		statusLine = "HTTP/1.1 200 OK\r\n"
-	case StatusBadRequest:
-		statusLine = "HTTP/1.1 400 Bad Request\r\n"
-	case StatusInternalServerError:
-		statusLine = "HTTP/1.1 500 Internal Server Error\r\n"
-	default:
-		break
-	}
 
-	_, err := w.Write([]byte(statusLine))
+	_, err := fmt.Fprintf(w, "HTTP/1.1 %03d %s\r\n", statusCode, reason)
 	if err != nil {
 		return err
 	}
+	w.statusCode = statusCode
 	w.state = StateStatusLineDone
 	return nil
 }
@@ -80,10 +275,12 @@ func (w *Writer) WriteHeaderValues(headers headers.Headers) error {
 	if headers == nil {
 		headers = GetDefaultHeader(0)
 	}
-	for key, value := range headers {
-		_, err := fmt.Fprintf(w, "%s: %s\r\n", key, value)
-		if err != nil {
-			return err
+	for key := range headers {
+		for _, value := range headers.Values(key) {
+			_, err := fmt.Fprintf(w, "%s: %s\r\n", key, value)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	_, err := w.Write([]byte("\r\n"))
@@ -93,12 +290,52 @@ func (w *Writer) WriteHeaderValues(headers headers.Headers) error {
 	return nil
 }
 
+// BeforeWriteHeaders registers fn to be called with the status code and the
+// headers right before WriteHeaders writes them, which lets middlewares add
+// fields to responses built by the handler. Hooks run in the order they were
+// registered
+func (w *Writer) BeforeWriteHeaders(fn func(StatusCode, headers.Headers)) {
+	w.headerHooks = append(w.headerHooks, fn)
+}
+
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	if w.state != StateStatusLineDone {
 		return fmt.Errorf("Cannot write headers - status is %s", w.state)
 	}
 
-	err := w.WriteHeaderValues(headers)
+	// interim responses are followed by the final one, for which the hooks
+	// are kept
+	if isInterim(w.statusCode) {
+		var err error
+		if headers == nil {
+			_, err = w.Write([]byte("\r\n"))
+		} else {
+			removeFraming(w.statusCode, headers)
+			err = w.WriteHeaderValues(headers)
+		}
+		if err != nil {
+			return err
+		}
+		w.state = StateReset
+		return nil
+	}
+
+	if headers == nil {
+		headers = GetDefaultHeader(0)
+		// the default length would not be that of the representation
+		if w.statusCode == StatusNotModified {
+			headers.Remove("Content-Length")
+		}
+	}
+	for _, hook := range w.headerHooks {
+		hook(w.statusCode, headers)
+	}
+	removeFraming(w.statusCode, headers)
+	err := w.prepareCompression(headers)
+	if err != nil {
+		return err
+	}
+	err = w.WriteHeaderValues(headers)
 	if err != nil {
 		return err
 	}
@@ -106,10 +343,38 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	return nil
 }
 
+// removeFraming drops the framing fields responses without content cannot
+// carry: 1xx and 204 responses have neither Content-Length nor
+// Transfer-Encoding (RFC 9110 section 8.6, RFC 9112 section 6.1) and 304
+// responses are not chunked
+func removeFraming(statusCode StatusCode, h headers.Headers) {
+	if bodyAllowed(statusCode) {
+		return
+	}
+	h.Remove("Transfer-Encoding")
+	if statusCode != StatusNotModified {
+		h.Remove("Content-Length")
+	}
+}
+
 func (w *Writer) WriteBody(body string) (int, error) {
 	if w.state != StateHeadersDone {
 		return 0, fmt.Errorf("Cannot write response body - status is %s", w.state)
 	}
+	if !bodyAllowed(w.statusCode) {
+		if body != "" {
+			return 0, fmt.Errorf("Status %d does not allow a body", w.statusCode)
+		}
+		w.state = StateCompleted
+		return 0, nil
+	}
+	if w.encoder != nil {
+		n, err := io.WriteString(w.encoder, body)
+		if err != nil {
+			return n, err
+		}
+		return n, w.closeEncoder()
+	}
 	w.state = StateCompleted
 	n, err := w.Write([]byte(body))
 	if err != nil {
@@ -120,26 +385,81 @@ func (w *Writer) WriteBody(body string) (int, error) {
 	return n, err
 }
 
+// CopyBody writes the whole body from src, for bodies that are too large to
+// hold in memory. The length must already be declared in the headers
+func (w *Writer) CopyBody(src io.Reader) (int64, error) {
+	if w.state != StateHeadersDone {
+		return 0, fmt.Errorf("Cannot write response body - status is %s", w.state)
+	}
+	if !bodyAllowed(w.statusCode) {
+		return 0, fmt.Errorf("Status %d does not allow a body", w.statusCode)
+	}
+	if w.encoder != nil {
+		n, err := io.Copy(w.encoder, src)
+		if err != nil {
+			return n, err
+		}
+		return n, w.closeEncoder()
+	}
+	w.state = StateCompleted
+	return io.Copy(w, src)
+}
+
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	if w.state != StateHeadersDone {
 		return 0, fmt.Errorf("Cannot write response body - status is %s", w.state)
 	}
+	if !bodyAllowed(w.statusCode) {
+		return 0, fmt.Errorf("Status %d does not allow a body", w.statusCode)
+	}
+	// an empty chunk would be read as the last chunk
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(p)
+	}
 	
 	length := len(p)
 	writeLen, err := fmt.Fprintf(w, "%X%s%s%s", length, headers.CRLF, p, headers.CRLF)
 	return writeLen, err
 }
 
+// WriteChunkedBodyDone writes the last chunk. The chunked body is terminated
+// either by WriteTrailers or, when there are no trailers, by Finish
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
-	n, err := w.Write([]byte("0\r\n\r\n"))
+	if w.state != StateHeadersDone {
+		return 0, fmt.Errorf("Cannot write last chunk - status is %s", w.state)
+	}
+	if !bodyAllowed(w.statusCode) {
+		return 0, fmt.Errorf("Status %d does not allow a body", w.statusCode)
+	}
+	if w.encoder != nil {
+		return 0, w.closeEncoder()
+	}
+	n, err := w.Write([]byte("0\r\n"))
 	if err != nil {
 		return 0, err
 	}
 
-	w.state = StateCompleted
+	w.state = StateChunkedBodyDone
 	return n, nil
 }
 
+// Finish terminates a chunked body that was not followed by trailers. It is
+// called by the server once the handler returns
+func (w *Writer) Finish() error {
+	if w.state != StateChunkedBodyDone {
+		return nil
+	}
+	_, err := w.Write([]byte(headers.CRLF))
+	if err != nil {
+		return err
+	}
+	w.state = StateCompleted
+	return nil
+}
+
 func (w *Writer) WriteResponse(statusCode StatusCode, msg string) {
 	w.WriteStatusLine(statusCode)
 	header := GetDefaultHeader(len(msg))
@@ -148,14 +468,18 @@ func (w *Writer) WriteResponse(statusCode StatusCode, msg string) {
 }
 
 func (w *Writer) WriteTrailers(h headers.Headers) error {
-	if w.state != StateCompleted {
-		return fmt.Errorf("Need to complete writing body before writing the trailers")
+	if w.state != StateChunkedBodyDone {
+		return fmt.Errorf("Need to complete writing chunked body before writing the trailers")
 	}
 
+	if h == nil {
+		h = headers.NewHeaders()
+	}
 	err := w.WriteHeaderValues(h)
 	if err != nil {
 		return err
 	}
 
+	w.state = StateCompleted
 	return nil
 }
\ No newline at end of file
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// contextHandler reports the context error of its request once the context
+// is done, or nil when it is still running after a second
+func contextHandler(errs chan<- error) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		select {
+		case <-req.Context().Done():
+			errs <- req.Context().Err()
+		case <-time.After(time.Second):
+			errs <- nil
+		}
+		w.WriteResponse(response.StatusOk, "done")
+	}
+}
+
+func dial(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+	_, port, err := net.SplitHostPort(s.Addr().String())
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestRequestContext(t *testing.T) {
+	// Test: Client closing the connection
+	errs := make(chan error, 1)
+	s := startServer(t, contextHandler(errs))
+	conn := dial(t, s)
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+
+	// Test: Server closing
+	s = startServer(t, contextHandler(errs))
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	s.Close()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+
+	// Test: Request deadline
+	s = startServerWithOptions(t, contextHandler(errs), Options{RequestTimeout: 50 * time.Millisecond})
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	require.NoError(t, err)
+	assert.ErrorIs(t, <-errs, context.DeadlineExceeded)
+
+	// Test: Connected client
+	s = startServer(t, contextHandler(errs))
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	require.NoError(t, err)
+	assert.NoError(t, <-errs)
+	body, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "done")
+
+	// Test: Client closing its sending side once its request is sent
+	s = startServer(t, contextHandler(errs))
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	require.NoError(t, err)
+	conn.(*net.TCPConn).CloseWrite()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+
+	// Test: Same client on a server allowing half-closed connections
+	s = startServerWithOptions(t, contextHandler(errs), Options{AllowHalfClose: true})
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	require.NoError(t, err)
+	conn.(*net.TCPConn).CloseWrite()
+	assert.NoError(t, <-errs)
+	body, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "done")
+}
+
+func TestRequestContextLargeBody(t *testing.T) {
+	s := startServerWithOptions(t, func(w *response.Writer, req *request.Request) {
+		w.WriteResponse(response.StatusOk, fmt.Sprintf("%d %v", len(req.Body), req.Context().Err()))
+	}, Options{AllowHalfClose: true})
+	body := strings.Repeat("x", 3000)
+	raw := "POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 3000\r\n\r\n" + body
+
+	// Test: Body spanning several reads, the client keeping its side open
+	resp := doRequest(t, s, raw)
+	got, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "3000 <nil>", string(got))
+
+	// Test: Same body from a client closing its sending side
+	conn := dial(t, s)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+	conn.(*net.TCPConn).CloseWrite()
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	got, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "3000 <nil>", string(got))
+}
+
+func TestRequestContextHijack(t *testing.T) {
+	hijacked := make(chan []byte, 1)
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		// wait for the byte sent after the request to reach the watcher
+		time.Sleep(50 * time.Millisecond)
+		conn, buffered, err := w.Hijack()
+		if err != nil {
+			hijacked <- nil
+			return
+		}
+		defer conn.Close()
+		rest := make([]byte, 3)
+		io.ReadFull(conn, rest)
+		hijacked <- append(buffered, rest...)
+	})
+
+	conn := dial(t, s)
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	_, err = io.WriteString(conn, "ping")
+	require.NoError(t, err)
+
+	// Test: Bytes read by the watcher are handed over with the connection
+	select {
+	case data := <-hijacked:
+		assert.Equal(t, "ping", string(data))
+	case <-time.After(time.Second):
+		t.Fatal("handler did not read from the hijacked connection")
+	}
+}
@@ -14,6 +14,18 @@ type HandlerError struct {
 
 type Handler func(*response.Writer, *request.Request)
 
+// Middleware wraps a Handler to add behavior before or after it runs
+type Middleware func(Handler) Handler
+
+// Chain applies the middlewares to the handler so that the first one is the
+// outermost, i.e. the first to see the request
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
 func WriteError(w io.Writer, handlerError *HandlerError) error {
 	_, err := fmt.Fprintf(w, "Server responded with status code %d and response message %s", handlerError.StatusCode, handlerError.Message)
 	return err
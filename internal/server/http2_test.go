@@ -0,0 +1,252 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/http2"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func h2Handler(w *response.Writer, req *request.Request) {
+	switch req.RequestLine.RequestTarget {
+	case "/large":
+		body := strings.Repeat("0123456789", 100000)
+		w.WriteResponse(response.StatusOk, body)
+
+	case "/early":
+		w.WriteStatusLine(103)
+		hints := headers.NewHeaders()
+		hints.Put("Link", "</style.css>; rel=preload")
+		w.WriteHeaders(hints)
+		w.WriteResponse(response.StatusOk, "final")
+
+	case "/trailers":
+		w.WriteStatusLine(response.StatusOk)
+		header := headers.NewHeaders()
+		header.Put("Transfer-Encoding", "chunked")
+		header.Put("Trailer", "X-Checksum")
+		w.WriteHeaders(header)
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.WriteChunkedBodyDone()
+		trailer := headers.NewHeaders()
+		trailer.Put("X-Checksum", "abc")
+		w.WriteTrailers(trailer)
+
+	default:
+		host, _ := req.Headers.Get("Host")
+		body := fmt.Sprintf("%s %s HTTP/%s host=%s body=%s", req.RequestLine.Method, req.RequestLine.RequestTarget, req.RequestLine.HttpVersion, host, req.Body)
+		w.WriteResponse(response.StatusOk, body)
+	}
+}
+
+func h2Client() *http.Client {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{
+		Transport: &http.Transport{Protocols: protocols},
+		Timeout:   5 * time.Second,
+	}
+}
+
+func baseURL(t *testing.T, s *Server) string {
+	t.Helper()
+	_, port, err := net.SplitHostPort(s.Addr().String())
+	require.NoError(t, err)
+	return "http://" + net.JoinHostPort("127.0.0.1", port)
+}
+
+func TestHTTP2PriorKnowledge(t *testing.T) {
+	s := startServer(t, h2Handler)
+	client := h2Client()
+	url := baseURL(t, s)
+
+	// Test: Request reaches the handler with the HTTP/2 fields mapped
+	resp, err := client.Post(url+"/echo?q=1", "text/plain", strings.NewReader("ping"))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	assert.Empty(t, resp.Header.Get("Connection"))
+	assert.Equal(t, "POST /echo?q=1 HTTP/2.0 host="+strings.TrimPrefix(url, "http://")+" body=ping", string(body))
+
+	// Test: Response larger than the flow-control windows
+	resp, err = client.Get(url + "/large")
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Len(t, body, 1000000)
+
+	// Test: Trailers
+	resp, err = client.Get(url + "/trailers")
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+
+	// Test: Interim responses are sent before the final one
+	var interim []int
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			interim = append(interim, code)
+			assert.Equal(t, "</style.css>; rel=preload", header.Get("Link"))
+			return nil
+		},
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "GET", url+"/early", nil)
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, []int{103}, interim)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "final", string(body))
+
+	// Test: Body over the limit is answered without being buffered
+	large := bytes.Repeat([]byte("x"), http2.DefaultMaxBodySize+1)
+	resp, err = client.Post(url+"/echo", "text/plain", bytes.NewReader(large))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 413, resp.StatusCode)
+
+	// Test: Concurrent streams on one connection
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(fmt.Sprintf("%s/stream/%d", url, i))
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			assert.Contains(t, string(body), fmt.Sprintf("GET /stream/%d ", i))
+		}()
+	}
+	wg.Wait()
+
+	// Test: HTTP/1.1 still works on the same server
+	resp = doRequest(t, s, "GET /plain HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "GET /plain HTTP/1.1 host=localhost body=", string(body))
+}
+
+func TestHTTP2Upgrade(t *testing.T) {
+	s := startServer(t, h2Handler)
+	_, port, err := net.SplitHostPort(s.Addr().String())
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// HTTP2-Settings carries SETTINGS_MAX_CONCURRENT_STREAMS = 100
+	_, err = io.WriteString(conn, "GET /upgraded HTTP/1.1\r\nHost: localhost\r\n"+
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABk\r\n\r\n"+
+		http2.ClientPreface)
+	require.NoError(t, err)
+	require.NoError(t, http2.WriteFrame(conn, http2.Frame{Type: http2.FrameSettings}))
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 101, resp.StatusCode)
+	assert.Equal(t, "h2c", resp.Header.Get("Upgrade"))
+
+	// the response to the upgrade request arrives on stream 1
+	decoder := headers.NewDecoder(headers.DefaultHeaderTableSize)
+	var fields []headers.HeaderField
+	var body []byte
+	for {
+		frame, err := http2.ReadFrame(reader, http2.DefaultMaxFrameSize)
+		require.NoError(t, err)
+		if frame.StreamID != 1 {
+			continue
+		}
+		switch frame.Type {
+		case http2.FrameHeaders:
+			fields, err = decoder.Decode(frame.Payload)
+			require.NoError(t, err)
+		case http2.FrameData:
+			body = append(body, frame.Payload...)
+		}
+		if frame.Has(http2.FlagEndStream) {
+			break
+		}
+	}
+
+	assert.Equal(t, headers.HeaderField{Name: ":status", Value: "200"}, fields[0])
+	assert.Equal(t, "GET /upgraded HTTP/2.0 host=localhost body=", string(body))
+}
+
+func TestHTTP2MalformedTrailers(t *testing.T) {
+	s := startServer(t, h2Handler)
+	_, port, err := net.SplitHostPort(s.Addr().String())
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = io.WriteString(conn, http2.ClientPreface)
+	require.NoError(t, err)
+	require.NoError(t, http2.WriteFrame(conn, http2.Frame{Type: http2.FrameSettings}))
+	encoder := headers.NewEncoder(headers.DefaultHeaderTableSize)
+	require.NoError(t, http2.WriteFrame(conn, http2.Frame{
+		Type:     http2.FrameHeaders,
+		Flags:    http2.FlagEndHeaders,
+		StreamID: 1,
+		Payload: encoder.Encode([]headers.HeaderField{
+			{Name: ":method", Value: "POST"},
+			{Name: ":scheme", Value: "http"},
+			{Name: ":path", Value: "/echo"},
+		}),
+	}))
+	require.NoError(t, http2.WriteFrame(conn, http2.Frame{Type: http2.FrameData, StreamID: 1, Payload: []byte("ping")}))
+	require.NoError(t, http2.WriteFrame(conn, http2.Frame{
+		Type:     http2.FrameHeaders,
+		Flags:    http2.FlagEndHeaders | http2.FlagEndStream,
+		StreamID: 1,
+		Payload:  encoder.Encode([]headers.HeaderField{{Name: "x-checksum", Value: "abc\r\nX-Injected: 1"}}),
+	}))
+
+	// Test: Trailer injecting a field line resets the stream
+	reader := bufio.NewReader(conn)
+	for {
+		frame, err := http2.ReadFrame(reader, http2.DefaultMaxFrameSize)
+		require.NoError(t, err)
+		if frame.StreamID != 1 || frame.Type == http2.FrameWindowUpdate {
+			continue
+		}
+		require.Equal(t, http2.FrameRSTStream, frame.Type)
+		assert.Equal(t, uint32(http2.ErrCodeProtocol), binary.BigEndian.Uint32(frame.Payload))
+		break
+	}
+}
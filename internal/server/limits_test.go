@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingHandler holds every request until release is closed
+type blockingHandler struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{entered: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (b *blockingHandler) handle(w *response.Writer, req *request.Request) {
+	b.entered <- struct{}{}
+	<-b.release
+	w.WriteResponse(response.StatusOk, "ok")
+}
+
+func startServerWithOptions(t *testing.T, handler Handler, options Options) *Server {
+	t.Helper()
+	s, err := ServeWithOptions(0, handler, options)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// sendRequest connects and sends a request, the response is read from the
+// returned reader
+func sendRequest(t *testing.T, s *Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	_, port, err := net.SplitHostPort(s.Addr().String())
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	return conn, bufio.NewReader(conn)
+}
+
+func readStatus(t *testing.T, r *bufio.Reader) int {
+	t.Helper()
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	return resp.StatusCode
+}
+
+func TestMaxConnsReject(t *testing.T) {
+	for name, options := range map[string]Options{
+		"server": {MaxConns: 1, RejectWhenFull: true},
+		"per IP": {MaxConnsPerIP: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			b := newBlockingHandler()
+			s := startServerWithOptions(t, b.handle, options)
+
+			_, first := sendRequest(t, s)
+			<-b.entered
+
+			_, rejected := sendRequest(t, s)
+			resp, err := http.ReadResponse(rejected, nil)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+			assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+			close(b.release)
+			assert.Equal(t, http.StatusOK, readStatus(t, first))
+
+			// the slot is freed once the first connection is done
+			require.Eventually(t, func() bool {
+				_, next := sendRequest(t, s)
+				resp, err := http.ReadResponse(next, nil)
+				return err == nil && resp.StatusCode == http.StatusOK
+			}, time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestMaxConnsBlock(t *testing.T) {
+	b := newBlockingHandler()
+	s := startServerWithOptions(t, b.handle, Options{MaxConns: 1})
+
+	_, first := sendRequest(t, s)
+	<-b.entered
+
+	// the second client waits in the backlog instead of being rejected
+	_, second := sendRequest(t, s)
+	select {
+	case <-b.entered:
+		t.Fatal("second connection handled while the first holds the only slot")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(b.release)
+	assert.Equal(t, http.StatusOK, readStatus(t, first))
+	assert.Equal(t, http.StatusOK, readStatus(t, second))
+}
+
+func TestMaxConnsHijacked(t *testing.T) {
+	for name, options := range map[string]Options{
+		"server": {MaxConns: 1, RejectWhenFull: true},
+		"per IP": {MaxConnsPerIP: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			release := make(chan struct{})
+			hijacked := make(chan struct{}, 1)
+			s := startServerWithOptions(t, func(w *response.Writer, req *request.Request) {
+				if req.RequestLine.RequestTarget != "/hijack" {
+					w.WriteResponse(response.StatusOk, "ok")
+					return
+				}
+				conn, _, err := w.Hijack()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				hijacked <- struct{}{}
+				// the handler keeps running with the connection
+				<-release
+			}, options)
+			t.Cleanup(func() { close(release) })
+
+			_, port, err := net.SplitHostPort(s.Addr().String())
+			require.NoError(t, err)
+			conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+			require.NoError(t, err)
+			t.Cleanup(func() { conn.Close() })
+			_, err = io.WriteString(conn, "GET /hijack HTTP/1.1\r\nHost: localhost\r\n\r\n")
+			require.NoError(t, err)
+			<-hijacked
+
+			// Test: Hijacked connection no longer holds its slot
+			_, next := sendRequest(t, s)
+			assert.Equal(t, http.StatusOK, readStatus(t, next))
+		})
+	}
+}
+
+func TestRejectBounded(t *testing.T) {
+	b := newBlockingHandler()
+	s := startServerWithOptions(t, b.handle, Options{MaxConns: 1, RejectWhenFull: true})
+	_, first := sendRequest(t, s)
+	<-b.entered
+
+	// rejected clients that never read keep their answers pending
+	_, port, err := net.SplitHostPort(s.Addr().String())
+	require.NoError(t, err)
+	for range maxRejecting {
+		conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+	}
+	require.Eventually(t, func() bool {
+		return len(s.rejecting) == maxRejecting
+	}, time.Second, 10*time.Millisecond)
+
+	// Test: Connections over the bound are closed without an answer
+	conn, _ := sendRequest(t, s)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, os.ErrDeadlineExceeded))
+
+	close(b.release)
+	assert.Equal(t, http.StatusOK, readStatus(t, first))
+}
+
+// flakyListener fails Accept with errs before reporting it is closed
+type flakyListener struct {
+	net.Listener
+	mu    sync.Mutex
+	errs  int
+	times []time.Time
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.times = append(l.times, time.Now())
+	if l.errs > 0 {
+		l.errs--
+		return nil, errors.New("accept: too many open files")
+	}
+	return nil, net.ErrClosed
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	s := newServer(0, echoHandler, Options{})
+	listener := &flakyListener{errs: 4}
+	s.listener = listener
+	s.state.Store(true)
+
+	s.listen()
+
+	// the pauses double from minAcceptBackoff
+	require.Len(t, listener.times, 5)
+	for i := 1; i < len(listener.times); i++ {
+		wait := minAcceptBackoff << (i - 1)
+		assert.GreaterOrEqual(t, listener.times[i].Sub(listener.times[i-1]), wait)
+	}
+}
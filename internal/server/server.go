@@ -1,12 +1,19 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"httpfromtcp/internal/http2"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
+	"io"
 	"net"
 	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type ServerAddr struct {
@@ -22,18 +29,89 @@ func (sAddr ServerAddr) String() string {
 	return sAddr.networkAddr
 }
 
+// Options limits the connections a server handles. Zero values mean no
+// limit. Connections taken over with Hijack stop counting once hijacked
+type Options struct {
+	// MaxConns caps the connections handled at once. Once reached the server
+	// stops accepting, leaving new clients in the listen backlog, or answers
+	// them 503 when RejectWhenFull is set
+	MaxConns int
+	RejectWhenFull bool
+	// MaxConnsPerIP caps the connections handled at once for one client IP,
+	// further connections are answered 503
+	MaxConnsPerIP int
+	// Observer is notified of the connections and their parse errors, such
+	// as to collect metrics
+	Observer Observer
+	// RequestTimeout sets the deadline of the request contexts, counted from
+	// the end of the parsing
+	RequestTimeout time.Duration
+	// AllowHalfClose keeps the context of a request whose client closed its
+	// sending side once the request was sent. A client closing its whole
+	// connection then goes unnoticed until the response is written
+	AllowHalfClose bool
+	// MaxDecodedBodySize bounds the request bodies once their content
+	// codings are removed, request.DefaultMaxDecodedBodySize is used when
+	// zero
+	MaxDecodedBodySize int64
+	// StreamRequestBodies hands requests to the handler once their headers
+	// are parsed, leaving the body to be read from the connection with
+	// Request.BodyReader. Such bodies are not decoded, and the contexts of
+	// their requests are not canceled when the connection breaks
+	StreamRequestBodies bool
+}
+
+// Observer receives connection events. Its methods are called concurrently
+// from the goroutines handling the connections
+type Observer interface {
+	ConnOpened()
+	// ConnClosed reports the bytes exchanged on the connection, those of
+	// hijacked connections only until they were taken over
+	ConnClosed(bytesRead, bytesWritten int64)
+	// ParseError reports a request that could not be parsed
+	ParseError(err error)
+}
+
+const (
+	// minAcceptBackoff and maxAcceptBackoff bound the pause after an Accept
+	// error such as EMFILE, which doubles while the errors persist
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+	// rejectTimeout bounds the time spent answering a rejected connection
+	rejectTimeout = time.Second
+	// maxRejectDrain bounds the bytes read from a rejected connection
+	maxRejectDrain = 64 << 10
+	// maxRejecting bounds the rejected connections answered at once, further
+	// ones are closed without a response
+	maxRejecting = 64
+)
+
 type Server struct {
 	state *atomic.Bool
 	serverAddr ServerAddr
 	listener net.Listener
 	handler Handler
+
+	options Options
+	// slots holds a token per connection being handled when MaxConns is set
+	slots chan struct{}
+	// rejecting holds a token per rejected connection being answered
+	rejecting chan struct{}
+	// done is closed by Close to stop waiting for a slot
+	done chan struct{}
+	// baseCtx is the parent of the request contexts, canceled by Close
+	baseCtx context.Context
+	cancel context.CancelFunc
+	mu sync.Mutex
+	connsPerIP map[string]int
 }
 
-func newServer(port int, handlerFunc Handler) *Server {
+func newServer(port int, handlerFunc Handler, options Options) *Server {
 	fmt.Printf("Creating new server on port: %d\n", port)
 	var serverState atomic.Bool
 	serverState.Store(false)
-	return &Server{
+	baseCtx, cancel := context.WithCancel(context.Background())
+	server := &Server{
 		state: &serverState,
 		serverAddr: ServerAddr{
 			networkType: "tcp",
@@ -41,11 +119,33 @@ func newServer(port int, handlerFunc Handler) *Server {
 		},
 		listener: nil,
 		handler: handlerFunc,
+		options: options,
+		done: make(chan struct{}),
+		baseCtx: baseCtx,
+		cancel: cancel,
+		connsPerIP: make(map[string]int),
+		rejecting: make(chan struct{}, maxRejecting),
 	}
+	if options.MaxConns > 0 {
+		server.slots = make(chan struct{}, options.MaxConns)
+	}
+	return server
 }
 
 func Serve(port int, handlerFunc Handler) (*Server, error) {
-	server := newServer(port, handlerFunc)
+	return ServeWithOptions(port, handlerFunc, Options{})
+}
+
+// ServeWithOptions is Serve with connection limits
+func ServeWithOptions(port int, handlerFunc Handler, options Options) (*Server, error) {
+	server := newServer(port, handlerFunc, options)
+	listener, err := net.Listen(server.serverAddr.networkType, server.serverAddr.networkAddr)
+	if err != nil {
+		return nil, err
+	}
+	server.listener = listener
+	server.state.Store(true)
+
 	go server.listen()
 	return server, nil
 }
@@ -56,7 +156,12 @@ func (s *Server) Accept() (net.Conn, error) {
 	return conn, err
 }
 
+// Addr returns the address the server is listening on, which carries the
+// actual port when the server was started on port 0
 func (s *Server) Addr() (net.Addr) {
+	if s.listener != nil {
+		return s.listener.Addr()
+	}
 	return s.serverAddr
 }
 
@@ -65,41 +170,280 @@ func (s *Server) Close() error {
 	var err error
 	if !old {
 		err = fmt.Errorf("Server is already closed")
+	} else if s.listener != nil {
+		close(s.done)
+		s.cancel()
+		err = s.listener.Close()
 	}
 	return err
 }
 
 func (s *Server) listen() {
-	s.state.Store(true)
-	
-	listener, err := net.Listen(s.serverAddr.networkType, s.serverAddr.networkAddr)
-	if err != nil {
-		s.state.Store(false)
-		s.Close()
-		return
-	}
-	s.listener = listener
-
+	backoff := time.Duration(0)
 	for s.state.Load() {
+		// blocking here leaves the clients in the listen backlog
+		if s.slots != nil && !s.options.RejectWhenFull {
+			select {
+			case s.slots <- struct{}{}:
+			case <-s.done:
+				return
+			}
+		}
+
 		conn, err := s.Accept()
 		if err != nil {
-			fmt.Println("Failed to read connection. Don't know how to respond" + err.Error())
+			if s.slots != nil && !s.options.RejectWhenFull {
+				<-s.slots
+			}
+			if !s.state.Load() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// errors such as running out of file descriptors persist for a
+			// while, retrying at once would spin
+			backoff = min(max(2*backoff, minAcceptBackoff), maxAcceptBackoff)
+			fmt.Printf("Failed to accept connection, retrying in %s: %v\n", backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-s.done:
+				return
+			}
 			continue
 		}
-		go s.handle(conn)
+		backoff = 0
+
+		if s.slots != nil && s.options.RejectWhenFull {
+			select {
+			case s.slots <- struct{}{}:
+			default:
+				s.reject(conn)
+				continue
+			}
+		}
+		ip := connIP(conn)
+		if !s.acquireIP(ip) {
+			if s.slots != nil {
+				<-s.slots
+			}
+			s.reject(conn)
+			continue
+		}
+
+		// hijacked connections give their slots back before the handler
+		// returns
+		release := sync.OnceFunc(func() {
+			s.releaseIP(ip)
+			if s.slots != nil {
+				<-s.slots
+			}
+		})
+		go func() {
+			defer release()
+			s.handle(conn, release)
+		}()
+	}
+}
+
+// acquireIP counts a connection from ip, or reports false when ip already
+// has MaxConnsPerIP connections
+func (s *Server) acquireIP(ip string) bool {
+	if s.options.MaxConnsPerIP <= 0 {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connsPerIP[ip] >= s.options.MaxConnsPerIP {
+		return false
+	}
+	s.connsPerIP[ip]++
+	return true
+}
+
+func (s *Server) releaseIP(ip string) {
+	if s.options.MaxConnsPerIP <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connsPerIP[ip]--
+	if s.connsPerIP[ip] <= 0 {
+		delete(s.connsPerIP, ip)
+	}
+}
+
+func connIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
 	}
+	return addr
 }
 
-func (s *Server) handle(conn net.Conn) {
+// reject answers a connection over the limits in the background, or closes
+// it at once when maxRejecting connections are already being answered
+func (s *Server) reject(conn net.Conn) {
+	select {
+	case s.rejecting <- struct{}{}:
+		go func() {
+			defer func() { <-s.rejecting }()
+			writeRejection(conn)
+		}()
+	default:
+		conn.Close()
+	}
+}
+
+// writeRejection answers a connection over the limits with 503 without
+// parsing its request
+func writeRejection(conn net.Conn) {
 	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(rejectTimeout))
+
+	msg := "Too many connections"
+	header := response.GetDefaultHeader(len(msg))
+	header.Put("Retry-After", "1")
+	writer := response.NewConnWriter(conn, nil)
+	writer.WriteStatusLine(response.StatusServiceUnavailable)
+	writer.WriteHeaders(header)
+	writer.WriteBody(msg)
+	writer.Flush()
+
+	// closing with unread data resets the connection, which can discard the
+	// response before the client reads it
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+	io.Copy(io.Discard, io.LimitReader(conn, maxRejectDrain))
+}
+
+// handle serves a connection, calling release when it is hijacked
+func (s *Server) handle(conn net.Conn, release func()) {
+	observer := s.options.Observer
+	var bytesRead, bytesWritten int64
+	if observer != nil {
+		observer.ConnOpened()
+		defer func() {
+			observer.ConnClosed(bytesRead, bytesWritten)
+		}()
+	}
+	h2 := &http2.Server{
+		Handler: http2.Handler(s.handler),
+		ErrorStatus: parseErrorStatus,
+		BaseContext: s.baseCtx,
+		RequestTimeout: s.options.RequestTimeout,
+		MaxDecodedBodySize: s.options.MaxDecodedBodySize,
+	}
+
+	// clients with prior knowledge start with the HTTP/2 preface
+	prefix, isHTTP2, _ := http2.SniffPreface(conn)
+	if isHTTP2 {
+		counting := &countingConn{Conn: conn}
+		h2.ServeConn(counting, prefix)
+		bytesRead, bytesWritten = int64(len(prefix))+counting.read.Load(), counting.written.Load()
+		return
+	}
 
-	writer := response.NewWriter()
-	req, err := request.RequestFromReader(conn)
+	var writer response.Writer
+	reader := &countingReader{r: io.MultiReader(bytes.NewReader(prefix), conn)}
+	readRequest := request.RequestFromReader
+	if s.options.StreamRequestBodies {
+		readRequest = request.RequestHeadFromReader
+	}
+	req, err := readRequest(reader)
+	if err == nil && !req.BodyStreamed() {
+		err = req.DecodeBody(s.options.MaxDecodedBodySize)
+	}
 	if err != nil {
-		writer.WriteResponse(400, err.Error())
+		if observer != nil {
+			observer.ParseError(err)
+		}
+		writer = response.NewConnWriter(conn, nil)
+		writer.WriteResponse(parseErrorStatus(err), err.Error())
 	} else {
-		s.handler(&writer, req);
+		writer = response.NewConnWriter(conn, req.Buffered())
+		writer.BeforeHijack(func() []byte {
+			release()
+			return nil
+		})
+		req.RemoteAddr = conn.RemoteAddr().String()
+		if http2.IsUpgradeRequest(req) {
+			h2.ServeUpgrade(&writer, req)
+		} else {
+			ctx, cancel := s.requestContext()
+			defer cancel()
+			if req.BodyStreamed() {
+				// the body is still to be read from the connection, which
+				// leaves nothing to watch it with
+				s.handler(&writer, req.WithContext(ctx));
+			} else {
+				watcher := watchConn(conn, cancel, s.options.AllowHalfClose)
+				writer.BeforeHijack(watcher.stop)
+				s.handler(&writer, req.WithContext(ctx));
+				if !writer.Hijacked() {
+					reader.n += int64(len(watcher.stop()))
+				}
+			}
+		}
 	}
 
-	conn.Write([]byte(writer.ReadBuffer()))
-}
\ No newline at end of file
+	defer func() {
+		bytesRead, bytesWritten = reader.n, writer.BytesWritten()
+	}()
+	if writer.Hijacked() {
+		return
+	}
+	writer.Finish()
+	writer.Flush()
+	conn.Close()
+}
+
+// requestContext returns the context of a new request, which the
+// connection watcher cancels when the client goes away
+func (s *Server) requestContext() (context.Context, context.CancelFunc) {
+	if s.options.RequestTimeout > 0 {
+		return context.WithTimeout(s.baseCtx, s.options.RequestTimeout)
+	}
+	return context.WithCancel(s.baseCtx)
+}
+
+// countingReader counts the bytes read from the connection by the parser
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingConn counts the bytes exchanged over HTTP/2 connections, which
+// are never hijacked
+type countingConn struct {
+	net.Conn
+	read, written atomic.Int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+// parseErrorStatus maps an error from parsing the request to the status code
+// sent to the client
+func parseErrorStatus(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, request.ErrUnsupportedContentEncoding):
+		return response.StatusUnsupportedMediaType
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.StatusContentTooLarge
+	default:
+		return response.StatusBadRequest
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T, handler Handler) *Server {
+	t.Helper()
+	s, err := Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func doRequest(t *testing.T, s *Server, raw string) *http.Response {
+	t.Helper()
+	_, port, err := net.SplitHostPort(s.Addr().String())
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func echoHandler(w *response.Writer, req *request.Request) {
+	w.WriteResponse(response.StatusOk, string(req.Body))
+}
+
+func TestParseErrorStatus(t *testing.T) {
+	s := startServer(t, echoHandler)
+
+	// Test: Malformed request
+	resp := doRequest(t, s, "get / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 400, resp.StatusCode)
+
+	// Test: Unsupported content coding
+	resp = doRequest(t, s, "POST / HTTP/1.1\r\nContent-Encoding: br\r\nContent-Length: 3\r\n\r\nabc")
+	assert.Equal(t, 415, resp.StatusCode)
+
+	// Test: Valid request reaches the handler
+	resp = doRequest(t, s, "POST / HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "abc", string(body))
+}
+
+func TestMaxDecodedBodySize(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err := zw.Write(bytes.Repeat([]byte("a"), 100))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	raw := fmt.Sprintf("POST / HTTP/1.1\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", compressed.Len(), compressed.String())
+
+	// Test: Decoded body within the default limit
+	resp := doRequest(t, startServer(t, echoHandler), raw)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, strings.Repeat("a", 100), string(body))
+
+	// Test: Decoded body over the limit of the server
+	s := startServerWithOptions(t, echoHandler, Options{MaxDecodedBodySize: 50})
+	resp = doRequest(t, s, raw)
+	assert.Equal(t, 413, resp.StatusCode)
+}
+
+func TestHijack(t *testing.T) {
+	hijacked := make(chan struct{})
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusSwitchingProtocols)
+		header := headers.NewHeaders()
+		header.Put("Upgrade", "echo")
+		header.Put("Connection", "Upgrade")
+		w.WriteHeaders(header)
+
+		conn, buffered, err := w.Hijack()
+		if err != nil {
+			return
+		}
+		_, _, err = w.Hijack()
+		if err == nil {
+			return
+		}
+		_, err = w.Write([]byte("ignored"))
+		if err == nil {
+			return
+		}
+
+		// the server must leave the connection open once the handler returns
+		go func() {
+			defer conn.Close()
+			defer close(hijacked)
+			reader := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn))
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				io.WriteString(conn, "echo: "+line)
+			}
+		}()
+	})
+
+	_, port, err := net.SplitHostPort(s.Addr().String())
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Test: Bytes sent along with the request are handed to the hijacker
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\nearly\n")
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 101, resp.StatusCode)
+
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: early\n", line)
+
+	_, err = io.WriteString(conn, "late\n")
+	require.NoError(t, err)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: late\n", line)
+
+	conn.(*net.TCPConn).CloseWrite()
+	<-hijacked
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// aLongTimeAgo is a read deadline that interrupts a blocked Read at once
+var aLongTimeAgo = time.Unix(1, 0)
+
+// connWatcher reads from a connection while its request is being handled to
+// notice the client going away, which cancels the context of the request
+type connWatcher struct {
+	conn    net.Conn
+	cancel  context.CancelFunc
+	// halfClose takes EOF for a client that only closed its sending side
+	halfClose bool
+	done    chan struct{}
+	stopped atomic.Bool
+	// buf holds the byte read, if any, once done is closed
+	buf [1]byte
+	n   int
+}
+
+func watchConn(conn net.Conn, cancel context.CancelFunc, halfClose bool) *connWatcher {
+	w := &connWatcher{conn: conn, cancel: cancel, halfClose: halfClose, done: make(chan struct{})}
+	go w.run()
+	return w
+}
+
+func (w *connWatcher) run() {
+	defer close(w.done)
+	n, err := w.conn.Read(w.buf[:])
+	w.n = n
+	// a client sending more data is still there, such as one pipelining
+	// requests, but it can no longer be watched
+	if n == 0 && err != nil && !(w.halfClose && err == io.EOF) && !w.stopped.Load() {
+		w.cancel()
+	}
+}
+
+// stop ends the watch and returns the bytes it read, which belong to the
+// next reader of the connection
+func (w *connWatcher) stop() []byte {
+	if w.stopped.Swap(true) {
+		<-w.done
+		return nil
+	}
+	w.conn.SetReadDeadline(aLongTimeAgo)
+	<-w.done
+	w.conn.SetReadDeadline(time.Time{})
+	return w.buf[:w.n]
+}
@@ -0,0 +1,122 @@
+package servertest
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// Result is a response parsed back from what a handler wrote
+type Result struct {
+	StatusCode response.StatusCode
+	// Reason is the reason phrase of the status line
+	Reason  string
+	Proto   string
+	Headers headers.Headers
+	// Body is the body without its transfer coding, still content-coded
+	Body     []byte
+	Trailers headers.Headers
+	// Interim holds the 1xx responses sent before the final one
+	Interim []*Result
+	// Raw is the response as written, interim responses included
+	Raw string
+}
+
+// Recorder captures the response a handler writes to its Writer
+type Recorder struct {
+	Writer response.Writer
+	// Method is the method of the request answered, responses to HEAD have
+	// no body
+	Method string
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{Writer: response.NewWriter(), Method: "GET"}
+}
+
+// Record runs the handler with a recorder and returns its result
+func Record(handler server.Handler, req *request.Request) (*Result, error) {
+	recorder := NewRecorder()
+	recorder.Method = req.RequestLine.Method
+	handler(&recorder.Writer, req)
+	return recorder.Result()
+}
+
+// Result parses the recorded response
+func (r *Recorder) Result() (*Result, error) {
+	r.Writer.Finish()
+	return ParseResponse(r.Writer.ReadBuffer(), r.Method)
+}
+
+// ParseResponse parses a response as written on the wire, the answer to a
+// request with the given method
+func ParseResponse(raw, method string) (*Result, error) {
+	reader := bufio.NewReader(strings.NewReader(raw))
+	var interim []*Result
+	for {
+		resp, err := http.ReadResponse(reader, &http.Request{Method: method})
+		if err != nil {
+			return nil, err
+		}
+		result, err := newResult(resp)
+		if err != nil {
+			return nil, err
+		}
+		// 101 ends the HTTP/1.1 exchange like a final response
+		if resp.StatusCode >= 200 || resp.StatusCode == 101 {
+			result.Interim = interim
+			result.Raw = raw
+			return result, nil
+		}
+		interim = append(interim, result)
+	}
+}
+
+func newResult(resp *http.Response) (*Result, error) {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{
+		StatusCode: response.StatusCode(resp.StatusCode),
+		Reason:     strings.TrimSpace(strings.TrimPrefix(resp.Status, resp.Status[:3])),
+		Proto:      resp.Proto,
+		Headers:    toHeaders(resp.Header),
+		Body:       body,
+		Trailers:   toHeaders(resp.Trailer),
+	}
+	// net/http moves these out of the header map
+	if resp.TransferEncoding != nil {
+		result.Headers.Put("Transfer-Encoding", strings.Join(resp.TransferEncoding, ", "))
+	}
+	return result, nil
+}
+
+func toHeaders(h http.Header) headers.Headers {
+	converted := headers.NewHeaders()
+	for name, values := range h {
+		for _, value := range values {
+			converted.Put(name, value)
+		}
+	}
+	return converted
+}
+
+// Header returns a field of the result, "" when it is missing
+func (r *Result) Header(name string) string {
+	value, _ := r.Headers.Get(name)
+	return value
+}
+
+// Trailer returns a field of the trailer section, "" when it is missing
+func (r *Result) Trailer(name string) string {
+	value, _ := r.Trailers.Get(name)
+	return value
+}
@@ -0,0 +1,159 @@
+// Package servertest provides utilities for testing handlers without going
+// through a network connection, and for starting throwaway servers when a
+// test does need one
+package servertest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"httpfromtcp/internal/request"
+)
+
+const (
+	// DefaultHost is the Host field of built requests that set none
+	DefaultHost = "example.com"
+	// DefaultRemoteAddr is the client address of built requests, from the
+	// TEST-NET-1 block
+	DefaultRemoteAddr = "192.0.2.1:1234"
+)
+
+// RequestBuilder builds a *request.Request by serializing it, parsing it
+// with request.RequestFromReader and decoding its body, so handlers see
+// exactly what the server would hand them
+type RequestBuilder struct {
+	method   string
+	target   string
+	version  string
+	fields   [][2]string
+	body     string
+	chunked  bool
+	trailers [][2]string
+
+	remoteAddr string
+	ctx        context.Context
+}
+
+// NewRequest starts building an HTTP/1.1 request
+func NewRequest(method, target string) *RequestBuilder {
+	return &RequestBuilder{method: method, target: target, version: "HTTP/1.1", remoteAddr: DefaultRemoteAddr}
+}
+
+// Version replaces the HTTP version of the request line, such as "HTTP/1.0"
+func (b *RequestBuilder) Version(version string) *RequestBuilder {
+	b.version = version
+	return b
+}
+
+// Header adds a field to the header section. Repeated names are sent as
+// separate lines
+func (b *RequestBuilder) Header(name, value string) *RequestBuilder {
+	b.fields = append(b.fields, [2]string{name, value})
+	return b
+}
+
+// Body sets the body, framed with Content-Length unless the request is
+// chunked or already has a Content-Length field
+func (b *RequestBuilder) Body(body string) *RequestBuilder {
+	b.body = body
+	return b
+}
+
+// Chunked frames the body with the chunked transfer coding, as one chunk
+func (b *RequestBuilder) Chunked() *RequestBuilder {
+	b.chunked = true
+	return b
+}
+
+// Trailer adds a field to the trailer section, which makes the request
+// chunked
+func (b *RequestBuilder) Trailer(name, value string) *RequestBuilder {
+	b.chunked = true
+	b.trailers = append(b.trailers, [2]string{name, value})
+	return b
+}
+
+// RemoteAddr sets the client address the server would have set
+func (b *RequestBuilder) RemoteAddr(addr string) *RequestBuilder {
+	b.remoteAddr = addr
+	return b
+}
+
+// Context sets the context of the request
+func (b *RequestBuilder) Context(ctx context.Context) *RequestBuilder {
+	b.ctx = ctx
+	return b
+}
+
+// Raw returns the request as sent on the wire
+func (b *RequestBuilder) Raw() string {
+	var raw strings.Builder
+	fmt.Fprintf(&raw, "%s %s %s\r\n", b.method, b.target, b.version)
+
+	hasHost, hasLength := false, false
+	for _, field := range b.fields {
+		hasHost = hasHost || strings.EqualFold(field[0], "Host")
+		hasLength = hasLength || strings.EqualFold(field[0], "Content-Length")
+	}
+	if !hasHost {
+		fmt.Fprintf(&raw, "Host: %s\r\n", DefaultHost)
+	}
+	for _, field := range b.fields {
+		fmt.Fprintf(&raw, "%s: %s\r\n", field[0], field[1])
+	}
+
+	switch {
+	case b.chunked:
+		raw.WriteString("Transfer-Encoding: chunked\r\n")
+		if len(b.trailers) > 0 {
+			names := make([]string, len(b.trailers))
+			for i, field := range b.trailers {
+				names[i] = field[0]
+			}
+			fmt.Fprintf(&raw, "Trailer: %s\r\n", strings.Join(names, ", "))
+		}
+		raw.WriteString("\r\n")
+		if b.body != "" {
+			fmt.Fprintf(&raw, "%x\r\n%s\r\n", len(b.body), b.body)
+		}
+		raw.WriteString("0\r\n")
+		for _, field := range b.trailers {
+			fmt.Fprintf(&raw, "%s: %s\r\n", field[0], field[1])
+		}
+		raw.WriteString("\r\n")
+	case b.body != "" && !hasLength:
+		fmt.Fprintf(&raw, "Content-Length: %d\r\n\r\n%s", len(b.body), b.body)
+	default:
+		raw.WriteString("\r\n" + b.body)
+	}
+	return raw.String()
+}
+
+// Build parses the request and decodes its body, returning the error of the
+// server for requests it rejects
+func (b *RequestBuilder) Build() (*request.Request, error) {
+	req, err := request.RequestFromReader(strings.NewReader(b.Raw()))
+	if err == nil {
+		err = req.DecodeBody(0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.RemoteAddr = b.remoteAddr
+	if b.ctx != nil {
+		req = req.WithContext(b.ctx)
+	}
+	return req, nil
+}
+
+// MustBuild is Build failing the test on error
+func (b *RequestBuilder) MustBuild(tb testing.TB) *request.Request {
+	tb.Helper()
+	req, err := b.Build()
+	if err != nil {
+		tb.Fatalf("servertest: building %s %s: %v", b.method, b.target, err)
+	}
+	return req
+}
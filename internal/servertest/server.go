@@ -0,0 +1,78 @@
+package servertest
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/server"
+)
+
+// sendTimeout bounds the exchanges of Server.Send
+const sendTimeout = 5 * time.Second
+
+// Server is a server listening on an ephemeral loopback port for the
+// duration of a test
+type Server struct {
+	*server.Server
+	// Addr is the host:port to dial and URL the base URL of the server
+	Addr string
+	URL  string
+}
+
+// NewServer starts a server closed when the test ends
+func NewServer(tb testing.TB, handler server.Handler) *Server {
+	tb.Helper()
+	return NewServerWithOptions(tb, handler, server.Options{})
+}
+
+func NewServerWithOptions(tb testing.TB, handler server.Handler, options server.Options) *Server {
+	tb.Helper()
+	s, err := server.ServeWithOptions(0, handler, options)
+	if err != nil {
+		tb.Fatalf("servertest: starting server: %v", err)
+	}
+	tb.Cleanup(func() { s.Close() })
+
+	_, port, err := net.SplitHostPort(s.Addr().String())
+	if err != nil {
+		tb.Fatalf("servertest: server address: %v", err)
+	}
+	addr := net.JoinHostPort("127.0.0.1", port)
+	return &Server{Server: s, Addr: addr, URL: "http://" + addr}
+}
+
+// Dial opens a connection to the server, closed when the test ends
+func (s *Server) Dial(tb testing.TB) net.Conn {
+	tb.Helper()
+	conn, err := net.Dial("tcp", s.Addr)
+	if err != nil {
+		tb.Fatalf("servertest: dialing %s: %v", s.Addr, err)
+	}
+	tb.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// Send writes a raw request on a new connection and returns the response,
+// read until the server closes the connection
+func (s *Server) Send(tb testing.TB, raw string) *Result {
+	tb.Helper()
+	conn := s.Dial(tb)
+	conn.SetDeadline(time.Now().Add(sendTimeout))
+	if _, err := io.WriteString(conn, raw); err != nil {
+		tb.Fatalf("servertest: sending request: %v", err)
+	}
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		tb.Fatalf("servertest: reading response: %v", err)
+	}
+
+	method, _, _ := strings.Cut(raw, " ")
+	result, err := ParseResponse(string(data), method)
+	if err != nil {
+		tb.Fatalf("servertest: parsing response %q: %v", data, err)
+	}
+	return result
+}
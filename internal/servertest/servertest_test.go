@@ -0,0 +1,113 @@
+package servertest
+
+import (
+	"context"
+	"testing"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoHandler(w *response.Writer, req *request.Request) {
+	switch req.RequestLine.RequestTarget {
+	case "/trailers":
+		w.WriteStatusLine(response.StatusOk)
+		h := headers.NewHeaders()
+		h.Put("Transfer-Encoding", "chunked")
+		h.Put("Trailer", "X-Checksum")
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Put("X-Checksum", "abc")
+		w.WriteTrailers(trailers)
+
+	case "/continue":
+		w.Write([]byte("HTTP/1.1 100 Continue\r\n\r\n"))
+		w.WriteResponse(response.StatusOk, "after")
+
+	default:
+		value, _ := req.Headers.Get("X-Value")
+		trailer, _ := req.Trailers.Get("X-Sum")
+		body := req.RequestLine.Method + " " + value + " " + string(req.Body) + " " + trailer + " " + req.RemoteAddr
+		h := response.GetDefaultHeader(len(body))
+		h.Put("Set-Cookie", "a=1")
+		h.Put("Set-Cookie", "b=2")
+		w.WriteStatusLineReason(201, "Made")
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	// Test: Request with a body
+	req := NewRequest("POST", "/echo").Header("X-Value", "v").Body("data").MustBuild(t)
+	result, err := Record(echoHandler, req)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCode(201), result.StatusCode)
+	assert.Equal(t, "Made", result.Reason)
+	assert.Equal(t, "HTTP/1.1", result.Proto)
+	assert.Equal(t, "POST v data  192.0.2.1:1234", string(result.Body))
+	assert.Equal(t, "text/plain", result.Header("Content-Type"))
+	assert.Equal(t, []string{"a=1", "b=2"}, result.Headers.Values("Set-Cookie"))
+
+	// Test: Chunked request with trailers
+	req = NewRequest("PUT", "/echo").Body("chunks").Trailer("X-Sum", "42").RemoteAddr("10.0.0.1:5").MustBuild(t)
+	result, err = Record(echoHandler, req)
+	require.NoError(t, err)
+	assert.Equal(t, "PUT  chunks 42 10.0.0.1:5", string(result.Body))
+
+	// Test: Chunked response with trailers
+	result, err = Record(echoHandler, NewRequest("GET", "/trailers").MustBuild(t))
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(result.Body))
+	assert.Equal(t, "chunked", result.Header("Transfer-Encoding"))
+	assert.Equal(t, "abc", result.Trailer("X-Checksum"))
+
+	// Test: Interim responses
+	result, err = Record(echoHandler, NewRequest("GET", "/continue").MustBuild(t))
+	require.NoError(t, err)
+	require.Len(t, result.Interim, 1)
+	assert.Equal(t, response.StatusCode(100), result.Interim[0].StatusCode)
+	assert.Equal(t, "after", string(result.Body))
+
+	// Test: HEAD response has no body
+	result, err = Record(echoHandler, NewRequest("HEAD", "/echo").MustBuild(t))
+	require.NoError(t, err)
+	assert.Empty(t, result.Body)
+	assert.NotEmpty(t, result.Header("Content-Length"))
+}
+
+func TestRequestBuilder(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "value")
+	req := NewRequest("GET", "/path?q=1").Header("Host", "api.example.com").Context(ctx).MustBuild(t)
+	assert.Equal(t, "/path?q=1", req.RequestLine.RequestTarget)
+	assert.Equal(t, "1.1", req.RequestLine.HttpVersion)
+	host, _ := req.Headers.Get("Host")
+	assert.Equal(t, "api.example.com", host)
+	assert.Equal(t, "value", req.Context().Value(key{}))
+
+	assert.Equal(t, "GET / HTTP/1.0\r\nHost: example.com\r\n\r\n", NewRequest("GET", "/").Version("HTTP/1.0").Raw())
+
+	// Test: Requests the parser rejects
+	_, err := NewRequest("GET", "/").Header("Bad Name", "x").Build()
+	assert.Error(t, err)
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer(t, echoHandler)
+	assert.Contains(t, s.URL, "http://127.0.0.1:")
+
+	result := s.Send(t, NewRequest("POST", "/echo").Header("X-Value", "v").Body("data").Raw())
+	assert.Equal(t, response.StatusCode(201), result.StatusCode)
+	assert.Contains(t, string(result.Body), "POST v data  127.0.0.1:")
+
+	result = s.Send(t, NewRequest("GET", "/trailers").Raw())
+	assert.Equal(t, "abc", result.Trailer("X-Checksum"))
+}
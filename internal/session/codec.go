@@ -0,0 +1,110 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// MinHashKeyLength is the shortest HMAC key accepted
+const MinHashKeyLength = 32
+
+var (
+	ErrInvalidKey   = errors.New("Invalid session key")
+	ErrInvalidValue = errors.New("Invalid session cookie value")
+)
+
+// KeyPair holds the keys protecting cookie values: HashKey signs them with
+// HMAC-SHA256 and BlockKey encrypts them with AES-GCM, so it must be 16, 24
+// or 32 bytes long
+type KeyPair struct {
+	HashKey  []byte
+	BlockKey []byte
+}
+
+type codecKey struct {
+	hashKey []byte
+	aead    cipher.AEAD
+}
+
+// Codec encrypts and signs cookie values. Values are always produced with
+// the first key pair, older pairs are kept to read cookies issued before a
+// key rotation
+type Codec struct {
+	keys []codecKey
+}
+
+func NewCodec(pairs ...KeyPair) (*Codec, error) {
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("%w: at least one key pair is required", ErrInvalidKey)
+	}
+
+	codec := &Codec{}
+	for i, pair := range pairs {
+		if len(pair.HashKey) < MinHashKeyLength {
+			return nil, fmt.Errorf("%w: hash key %d is shorter than %d bytes", ErrInvalidKey, i, MinHashKeyLength)
+		}
+		block, err := aes.NewCipher(pair.BlockKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w: block key %d: %v", ErrInvalidKey, i, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		codec.keys = append(codec.keys, codecKey{hashKey: pair.HashKey, aead: aead})
+	}
+	return codec, nil
+}
+
+// Encode encrypts value and signs the result together with the cookie name,
+// so a value cannot be moved to another cookie
+func (c *Codec) Encode(name string, value []byte) (string, error) {
+	key := c.keys[0]
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := key.aead.Seal(nonce, nonce, value, []byte(name))
+	sealed = append(sealed, sign(key.hashKey, name, sealed)...)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decode verifies and decrypts a value produced by Encode with any of the
+// codec's key pairs
+func (c *Codec) Decode(name, encoded string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(data) < sha256.Size {
+		return nil, ErrInvalidValue
+	}
+	sealed, mac := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+
+	for _, key := range c.keys {
+		if !hmac.Equal(mac, sign(key.hashKey, name, sealed)) {
+			continue
+		}
+		nonceSize := key.aead.NonceSize()
+		if len(sealed) < nonceSize {
+			return nil, ErrInvalidValue
+		}
+		value, err := key.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(name))
+		if err != nil {
+			return nil, ErrInvalidValue
+		}
+		return value, nil
+	}
+	return nil, ErrInvalidValue
+}
+
+func sign(hashKey []byte, name string, data []byte) []byte {
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,316 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"maps"
+	"sync"
+	"time"
+
+	"httpfromtcp/internal/cookie"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+const (
+	DefaultCookieName      = "session"
+	DefaultIdleTimeout     = 30 * time.Minute
+	DefaultAbsoluteTimeout = 24 * time.Hour
+)
+
+// idLength is the number of random bytes in a session ID
+const idLength = 32
+
+// Session is the state kept for a client between requests. It is only valid
+// while the request it was obtained from is being handled
+type Session struct {
+	ID        string
+	CreatedAt time.Time
+	LastSeen  time.Time
+
+	mu     sync.Mutex
+	values map[string]string
+	// previousID is the ID to delete from the store after Regenerate
+	previousID string
+	isNew      bool
+	destroyed  bool
+	// stale is set when the request carried an invalid or expired cookie
+	stale bool
+}
+
+func (s *Session) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, found := s.values[key]
+	return value, found
+}
+
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+}
+
+// Regenerate gives the session a new ID while keeping its values. Call it
+// whenever the privileges of the client change, such as on login, so that
+// an ID planted by an attacker before is worthless (session fixation)
+func (s *Session) Regenerate() error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.previousID == "" {
+		s.previousID = s.ID
+	}
+	s.ID = id
+	return nil
+}
+
+// Destroy removes the session from the store and expires its cookie
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = make(map[string]string)
+	s.destroyed = true
+}
+
+func (s *Session) record() Record {
+	return Record{
+		ID:        s.ID,
+		CreatedAt: s.CreatedAt,
+		LastSeen:  s.LastSeen,
+		Values:    maps.Clone(s.values),
+	}
+}
+
+func newID() (string, error) {
+	b := make([]byte, idLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Options configures a Manager. Zero values select the defaults
+type Options struct {
+	CookieName string
+	// Path defaults to "/"
+	Path     string
+	Domain   string
+	Secure   bool
+	SameSite cookie.SameSite
+	// IdleTimeout ends sessions without requests for that long
+	IdleTimeout time.Duration
+	// AbsoluteTimeout ends sessions that long after their creation, however
+	// active they are
+	AbsoluteTimeout time.Duration
+	// Store keeps the session values on the server. When nil the values are
+	// kept in the cookie itself, which limits them to about 4KB
+	Store Store
+}
+
+// Manager loads the session of every request from its cookie and saves it
+// when the response headers are written
+type Manager struct {
+	opts  Options
+	codec *Codec
+	now   func() time.Time
+}
+
+// sessionKey is the context key of the session of a request, one per
+// manager
+type sessionKey struct {
+	m *Manager
+}
+
+// NewManager returns a manager protecting the session cookies with keys. The
+// first pair issues cookies, the others are only accepted to rotate keys
+func NewManager(opts Options, keys ...KeyPair) (*Manager, error) {
+	codec, err := NewCodec(keys...)
+	if err != nil {
+		return nil, err
+	}
+	if opts.CookieName == "" {
+		opts.CookieName = DefaultCookieName
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	if opts.SameSite == cookie.SameSiteDefault {
+		opts.SameSite = cookie.SameSiteLax
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultIdleTimeout
+	}
+	if opts.AbsoluteTimeout <= 0 {
+		opts.AbsoluteTimeout = DefaultAbsoluteTimeout
+	}
+	return &Manager{opts: opts, codec: codec, now: time.Now}, nil
+}
+
+// Get returns the session of a request handled by the Middleware, or nil
+// for other requests
+func (m *Manager) Get(req *request.Request) *Session {
+	s, _ := req.Context().Value(sessionKey{m}).(*Session)
+	return s
+}
+
+// Middleware makes the session available to the handler through Get and
+// sends its cookie with the response
+func (m *Manager) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		s := m.load(req)
+		w.BeforeWriteHeaders(func(_ response.StatusCode, h headers.Headers) {
+			m.commit(s, h)
+		})
+		next(w, req.WithContext(context.WithValue(req.Context(), sessionKey{m}, s)))
+	}
+}
+
+// load returns the session referenced by the request's cookie, or a new one
+// when there is none or it has expired
+func (m *Manager) load(req *request.Request) *Session {
+	now := m.now()
+	c, found := req.Cookie(m.opts.CookieName)
+	if found {
+		record, found := m.decode(c.Value)
+		if found && m.alive(record, now) {
+			return &Session{
+				ID:        record.ID,
+				CreatedAt: record.CreatedAt,
+				LastSeen:  record.LastSeen,
+				values:    maps.Clone(record.Values),
+			}
+		}
+	}
+
+	// the ID is only drawn when the session is saved
+	return &Session{
+		CreatedAt: now,
+		LastSeen:  now,
+		values:    make(map[string]string),
+		isNew:     true,
+		stale:     found,
+	}
+}
+
+func (m *Manager) decode(value string) (Record, bool) {
+	data, err := m.codec.Decode(m.opts.CookieName, value)
+	if err != nil {
+		return Record{}, false
+	}
+	if m.opts.Store == nil {
+		var record Record
+		if json.Unmarshal(data, &record) != nil || record.ID == "" {
+			return Record{}, false
+		}
+		return record, true
+	}
+
+	record, found, err := m.opts.Store.Load(string(data))
+	if err != nil {
+		log.Printf("session: loading %s: %v", m.opts.CookieName, err)
+		return Record{}, false
+	}
+	return record, found
+}
+
+func (m *Manager) alive(record Record, now time.Time) bool {
+	return now.Sub(record.LastSeen) < m.opts.IdleTimeout &&
+		now.Sub(record.CreatedAt) < m.opts.AbsoluteTimeout
+}
+
+// commit saves the session and adds its cookie to the response headers.
+// New sessions are only sent to the client once they hold a value
+func (m *Manager) commit(s *Session, h headers.Headers) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.destroyed || (s.isNew && len(s.values) == 0) {
+		if s.destroyed && m.opts.Store != nil {
+			m.deleteFromStore(s.ID)
+			m.deleteFromStore(s.previousID)
+		}
+		if s.destroyed || s.stale {
+			m.setCookie(h, "", -1)
+		}
+		return
+	}
+
+	if s.ID == "" {
+		id, err := newID()
+		if err != nil {
+			log.Printf("session: %v", err)
+			return
+		}
+		s.ID = id
+	}
+	now := m.now()
+	s.LastSeen = now
+	ttl := min(m.opts.IdleTimeout, s.CreatedAt.Add(m.opts.AbsoluteTimeout).Sub(now))
+
+	value := []byte(s.ID)
+	if m.opts.Store != nil {
+		m.deleteFromStore(s.previousID)
+		err := m.opts.Store.Save(s.record(), ttl)
+		if err != nil {
+			log.Printf("session: saving %s: %v", m.opts.CookieName, err)
+			return
+		}
+	} else {
+		var err error
+		value, err = json.Marshal(s.record())
+		if err != nil {
+			log.Printf("session: %v", err)
+			return
+		}
+	}
+
+	encoded, err := m.codec.Encode(m.opts.CookieName, value)
+	if err != nil {
+		log.Printf("session: %v", err)
+		return
+	}
+	// the cookie outlives the idle timeout by at most a second, the server
+	// checks both timeouts anyway
+	m.setCookie(h, encoded, int(ttl.Round(time.Second)/time.Second)+1)
+}
+
+func (m *Manager) deleteFromStore(id string) {
+	if id == "" {
+		return
+	}
+	err := m.opts.Store.Delete(id)
+	if err != nil {
+		log.Printf("session: deleting %s: %v", m.opts.CookieName, err)
+	}
+}
+
+func (m *Manager) setCookie(h headers.Headers, value string, maxAge int) {
+	err := response.SetCookie(h, &cookie.Cookie{
+		Name:     m.opts.CookieName,
+		Value:    value,
+		Path:     m.opts.Path,
+		Domain:   m.opts.Domain,
+		MaxAge:   maxAge,
+		Secure:   m.opts.Secure,
+		HttpOnly: true,
+		SameSite: m.opts.SameSite,
+	})
+	if err != nil {
+		log.Printf("session: %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(b byte) KeyPair {
+	return KeyPair{
+		HashKey:  bytes.Repeat([]byte{b}, 32),
+		BlockKey: bytes.Repeat([]byte{b + 1}, 16),
+	}
+}
+
+// serve runs the handler for a GET request carrying the cookie, if any, and
+// returns the session cookie of the response
+func serve(t *testing.T, handler server.Handler, sessionCookie *http.Cookie) *http.Cookie {
+	t.Helper()
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+	if sessionCookie != nil {
+		raw += "Cookie: " + sessionCookie.Name + "=" + sessionCookie.Value + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	w := response.NewWriter()
+	handler(&w, req)
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(w.ReadBuffer())), nil)
+	require.NoError(t, err)
+	for _, c := range resp.Cookies() {
+		if c.Name == DefaultCookieName {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestCodec(t *testing.T) {
+	old, err := NewCodec(testKey(1))
+	require.NoError(t, err)
+	encoded, err := old.Encode("session", []byte("hello"))
+	require.NoError(t, err)
+
+	value, err := old.Decode("session", encoded)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(value))
+
+	// the value is bound to the cookie name
+	_, err = old.Decode("other", encoded)
+	assert.ErrorIs(t, err, ErrInvalidValue)
+
+	// tampering breaks the signature
+	tampered := []byte(encoded)
+	tampered[5] ^= 1
+	_, err = old.Decode("session", string(tampered))
+	assert.ErrorIs(t, err, ErrInvalidValue)
+
+	// rotated codecs still read values of the old key but issue new ones
+	rotated, err := NewCodec(testKey(3), testKey(1))
+	require.NoError(t, err)
+	value, err = rotated.Decode("session", encoded)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(value))
+	encoded, err = rotated.Encode("session", []byte("hello"))
+	require.NoError(t, err)
+	_, err = old.Decode("session", encoded)
+	assert.ErrorIs(t, err, ErrInvalidValue)
+
+	_, err = NewCodec(KeyPair{HashKey: []byte("short"), BlockKey: make([]byte, 16)})
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, err = NewCodec(KeyPair{HashKey: make([]byte, 32), BlockKey: make([]byte, 10)})
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestSession(t *testing.T) {
+	for name, store := range map[string]Store{"cookie": nil, "memory": NewMemoryStore()} {
+		t.Run(name, func(t *testing.T) {
+			m, err := NewManager(Options{Store: store}, testKey(1))
+			require.NoError(t, err)
+
+			var seen string
+			handler := m.Middleware(func(w *response.Writer, req *request.Request) {
+				s := m.Get(req)
+				seen, _ = s.Get("user")
+				if req.RequestLine.RequestTarget == "/" && seen == "" {
+					s.Set("user", "alice")
+				}
+				w.WriteResponse(response.StatusOk, "ok")
+			})
+
+			// empty new sessions are not sent
+			noop := m.Middleware(func(w *response.Writer, req *request.Request) {
+				w.WriteResponse(response.StatusOk, "ok")
+			})
+			assert.Nil(t, serve(t, noop, nil))
+
+			c := serve(t, handler, nil)
+			require.NotNil(t, c)
+			assert.True(t, c.HttpOnly)
+			assert.Equal(t, "/", c.Path)
+			assert.Equal(t, http.SameSiteLaxMode, c.SameSite)
+			assert.Equal(t, int(DefaultIdleTimeout/time.Second)+1, c.MaxAge)
+			assert.NotContains(t, c.Value, "alice")
+
+			next := serve(t, handler, c)
+			assert.Equal(t, "alice", seen)
+			require.NotNil(t, next)
+
+			// a forged cookie starts a new session and is replaced
+			c.Value = c.Value[:len(c.Value)-2] + "AA"
+			seen = "unset"
+			serve(t, handler, c)
+			assert.Equal(t, "", seen)
+		})
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	m, err := NewManager(Options{IdleTimeout: time.Minute, AbsoluteTimeout: time.Hour}, testKey(1))
+	require.NoError(t, err)
+	now := time.Date(2026, time.March, 1, 9, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	var found bool
+	handler := m.Middleware(func(w *response.Writer, req *request.Request) {
+		s := m.Get(req)
+		_, found = s.Get("user")
+		s.Set("user", "alice")
+		w.WriteResponse(response.StatusOk, "ok")
+	})
+
+	c := serve(t, handler, nil)
+	// activity keeps the session alive past the idle timeout
+	for range 5 {
+		now = now.Add(50 * time.Second)
+		c = serve(t, handler, c)
+		assert.True(t, found)
+	}
+
+	now = now.Add(2 * time.Minute)
+	c = serve(t, handler, c)
+	assert.False(t, found, "idle session must expire")
+
+	// the absolute timeout ends even active sessions
+	for range 61 {
+		now = now.Add(59 * time.Second)
+		c = serve(t, handler, c)
+	}
+	assert.True(t, found)
+	now = now.Add(59 * time.Second)
+	serve(t, handler, c)
+	assert.False(t, found, "session must expire after the absolute timeout")
+}
+
+func TestSessionRegenerateAndDestroy(t *testing.T) {
+	store := NewMemoryStore()
+	m, err := NewManager(Options{Store: store}, testKey(1))
+	require.NoError(t, err)
+
+	var id string
+	handler := m.Middleware(func(w *response.Writer, req *request.Request) {
+		s := m.Get(req)
+		switch req.RequestLine.RequestTarget {
+		case "/login":
+			require.NoError(t, s.Regenerate())
+			s.Set("user", "alice")
+		case "/logout":
+			s.Destroy()
+		default:
+			s.Set("visited", "yes")
+		}
+		id = s.ID
+		w.WriteResponse(response.StatusOk, "ok")
+	})
+	serveTarget := func(target string, c *http.Cookie) *http.Cookie {
+		req, err := request.RequestFromReader(strings.NewReader(
+			"GET " + target + " HTTP/1.1\r\nHost: localhost\r\nCookie: " + c.Name + "=" + c.Value + "\r\n\r\n"))
+		require.NoError(t, err)
+		w := response.NewWriter()
+		handler(&w, req)
+		resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(w.ReadBuffer())), nil)
+		require.NoError(t, err)
+		require.Len(t, resp.Cookies(), 1)
+		return resp.Cookies()[0]
+	}
+
+	c := serve(t, handler, nil)
+	anonymousID := id
+	assert.Equal(t, 1, store.Len())
+
+	c = serveTarget("/login", c)
+	assert.NotEqual(t, anonymousID, id)
+	_, found, _ := store.Load(anonymousID)
+	assert.False(t, found, "the pre-login ID must be dropped")
+	record, found, _ := store.Load(id)
+	require.True(t, found)
+	assert.Equal(t, "alice", record.Values["user"])
+
+	c = serveTarget("/logout", c)
+	assert.Equal(t, "", c.Value)
+	assert.Equal(t, -1, c.MaxAge)
+	assert.Equal(t, 0, store.Len())
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Date(2026, time.March, 1, 9, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.Save(Record{ID: "a", Values: map[string]string{"k": "v"}}, time.Minute))
+	record, found, err := store.Load("a")
+	require.NoError(t, err)
+	require.True(t, found)
+	record.Values["k"] = "changed"
+	record, _, _ = store.Load("a")
+	assert.Equal(t, "v", record.Values["k"])
+
+	now = now.Add(time.Minute)
+	_, found, _ = store.Load("a")
+	assert.False(t, found)
+
+	// expired entries are swept on save
+	require.NoError(t, store.Save(Record{ID: "b"}, time.Second))
+	now = now.Add(2 * sweepInterval)
+	require.NoError(t, store.Save(Record{ID: "c"}, time.Minute))
+	assert.Equal(t, 1, store.Len())
+}
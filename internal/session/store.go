@@ -0,0 +1,99 @@
+package session
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// Record is the persisted state of a session
+type Record struct {
+	ID        string            `json:"id"`
+	CreatedAt time.Time         `json:"created"`
+	LastSeen  time.Time         `json:"seen"`
+	Values    map[string]string `json:"values,omitempty"`
+}
+
+// Store keeps sessions on the server, the cookie then only carries the
+// session ID. Implementations must be safe for concurrent use
+type Store interface {
+	// Load returns the record of the session, or false when it does not
+	// exist or has expired
+	Load(id string) (Record, bool, error)
+	// Save stores the record until ttl has passed
+	Save(record Record, ttl time.Duration) error
+	Delete(id string) error
+}
+
+// sweepInterval is how often MemoryStore drops expired sessions
+const sweepInterval = time.Minute
+
+type memoryEntry struct {
+	record  Record
+	expires time.Time
+}
+
+// MemoryStore is a Store holding sessions in memory, which are lost on
+// restart and not shared between servers
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]memoryEntry),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Load(id string) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, found := s.entries[id]
+	if !found {
+		return Record{}, false, nil
+	}
+	if !s.now().Before(entry.expires) {
+		delete(s.entries, id)
+		return Record{}, false, nil
+	}
+	record := entry.record
+	record.Values = maps.Clone(record.Values)
+	return record, true, nil
+}
+
+func (s *MemoryStore) Save(record Record, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	record.Values = maps.Clone(record.Values)
+	s.entries[record.ID] = memoryEntry{record: record, expires: now.Add(ttl)}
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.lastSweep = now
+		for id, entry := range s.entries {
+			if !now.Before(entry.expires) {
+				delete(s.entries, id)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, id)
+	return nil
+}
+
+// Len returns the number of stored sessions, expired ones included until
+// they are swept
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
@@ -0,0 +1,171 @@
+package sse
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+var ErrInvalidField = errors.New("SSE field must not contain line breaks or NUL")
+
+// Event is a single server-sent event. Empty fields are left out, Data may
+// span several lines
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	// Retry tells the client how long to wait before reconnecting
+	Retry time.Duration
+}
+
+// Writer streams server-sent events (HTML Living Standard section 9.2) over
+// a response. Every event is flushed to the client as soon as it is written.
+// It is safe for concurrent use
+type Writer struct {
+	w           *response.Writer
+	lastEventID string
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// LastEventID returns the ID of the last event the client received before
+// reconnecting, or an empty string on a first connection
+func LastEventID(req *request.Request) string {
+	id, _ := req.Headers.Get("Last-Event-ID")
+	return strings.TrimSpace(id)
+}
+
+// NewWriter starts the event stream by writing the response head
+func NewWriter(w *response.Writer, req *request.Request) (*Writer, error) {
+	header := headers.NewHeaders()
+	header.Put("Content-Type", "text/event-stream; charset=utf-8")
+	header.Put("Cache-Control", "no-cache")
+	header.Put("Transfer-Encoding", "chunked")
+	header.Put("Connection", "close")
+	// keeps proxies such as nginx from buffering the stream
+	header.Put("X-Accel-Buffering", "no")
+
+	err := w.WriteStatusLine(response.StatusOk)
+	if err != nil {
+		return nil, err
+	}
+	err = w.WriteHeaders(header)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, lastEventID: LastEventID(req)}, nil
+}
+
+// LastEventID returns the Last-Event-ID the client sent when connecting
+func (s *Writer) LastEventID() string {
+	return s.lastEventID
+}
+
+// Send writes the event and flushes it to the client
+func (s *Writer) Send(event Event) error {
+	if !isValidField(event.ID) || !isValidField(event.Event) {
+		return ErrInvalidField
+	}
+
+	var b strings.Builder
+	if event.ID != "" {
+		b.WriteString("id: " + event.ID + "\n")
+	}
+	if event.Event != "" {
+		b.WriteString("event: " + event.Event + "\n")
+	}
+	if event.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+	if event.Data != "" || (event.ID == "" && event.Event == "" && event.Retry == 0) {
+		for _, line := range splitLines(event.Data) {
+			b.WriteString("data: " + line + "\n")
+		}
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Comment writes a comment line, which clients ignore. Comments keep idle
+// connections from being closed by intermediaries
+func (s *Writer) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range splitLines(text) {
+		b.WriteString(":" + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// StartHeartbeat sends an empty comment every interval until the returned
+// function is called or a write fails
+func (s *Writer) StartHeartbeat(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if s.Comment("") != nil {
+					return
+				}
+			}
+		}
+	}()
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// Close ends the event stream
+func (s *Writer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	_, err := s.w.WriteChunkedBodyDone()
+	if err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+func (s *Writer) write(data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("SSE stream is closed")
+	}
+	_, err := s.w.WriteChunkedBody([]byte(data))
+	if err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// splitLines splits on every line ending the event stream format accepts,
+// so a bare CR inside data cannot end the field early on the client
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	return strings.Split(text, "\n")
+}
+
+func isValidField(value string) bool {
+	return !strings.ContainsAny(value, "\r\n\x00")
+}
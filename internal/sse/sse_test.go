@@ -0,0 +1,79 @@
+package sse
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStream(t *testing.T, raw string) (*Writer, *response.Writer) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	w := response.NewWriter()
+	s, err := NewWriter(&w, req)
+	require.NoError(t, err)
+	return s, &w
+}
+
+func readStream(t *testing.T, w *response.Writer) (*http.Response, string) {
+	t.Helper()
+	require.NoError(t, w.Finish())
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(w.ReadBuffer())), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestSend(t *testing.T) {
+	s, w := newStream(t, "GET /builds HTTP/1.1\r\nLast-Event-ID: 41\r\n\r\n")
+	assert.Equal(t, "41", s.LastEventID())
+
+	require.NoError(t, s.Send(Event{ID: "42", Event: "status", Data: "building"}))
+	require.NoError(t, s.Send(Event{Data: "line one\nline two\r\nline three\rline four"}))
+	require.NoError(t, s.Send(Event{Retry: 3 * time.Second}))
+	require.NoError(t, s.Send(Event{}))
+	require.NoError(t, s.Comment("keep\nalive"))
+	assert.ErrorIs(t, s.Send(Event{ID: "4\n2", Data: "x"}), ErrInvalidField)
+	assert.ErrorIs(t, s.Send(Event{Event: "a\rb", Data: "x"}), ErrInvalidField)
+	require.NoError(t, s.Close())
+	assert.Error(t, s.Send(Event{Data: "late"}))
+
+	resp, body := readStream(t, w)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/event-stream; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	assert.Equal(t, "id: 42\nevent: status\ndata: building\n\n"+
+		"data: line one\ndata: line two\ndata: line three\ndata: line four\n\n"+
+		"retry: 3000\n\n"+
+		"data: \n\n"+
+		":keep\n:alive\n\n", body)
+}
+
+func TestLastEventIDMissing(t *testing.T) {
+	s, _ := newStream(t, "GET /builds HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "", s.LastEventID())
+}
+
+func TestHeartbeat(t *testing.T) {
+	s, w := newStream(t, "GET /builds HTTP/1.1\r\n\r\n")
+	stop := s.StartHeartbeat(5 * time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	stop()
+	stop()
+	require.NoError(t, s.Close())
+
+	_, body := readStream(t, w)
+	assert.True(t, strings.HasPrefix(body, ":\n\n"))
+	assert.Equal(t, "", strings.ReplaceAll(body, ":\n\n", ""))
+}
@@ -0,0 +1,182 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"httpfromtcp/internal/headers"
+)
+
+var ErrInvalidTraceparent = errors.New("Invalid traceparent")
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// FlagSampled is the trace flag asking for the trace to be recorded
+const FlagSampled byte = 0x01
+
+// maxTracestateMembers is the number of list-members a tracestate may have
+const maxTracestateMembers = 32
+
+// SpanContext identifies a span across services as carried by the W3C Trace
+// Context headers
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+	// TraceState holds vendor specific data, propagated unchanged
+	TraceState string
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// Traceparent formats the span context as a version 00 traceparent value
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent parses a traceparent value (W3C Trace Context section
+// 3.2). Versions after 00 are parsed as 00, ignoring the fields they add
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	value = strings.TrimSpace(value)
+	if len(value) < 55 || (len(value) > 55 && value[55] != '-') {
+		return sc, fmt.Errorf("%w: %q", ErrInvalidTraceparent, value)
+	}
+	parts := strings.Split(value[:55], "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 ||
+		!isLowerHex(value[:55], 52) {
+		return sc, fmt.Errorf("%w: %q", ErrInvalidTraceparent, value)
+	}
+	version := parts[0]
+	if version == "ff" || (version == "00" && len(value) != 55) {
+		return sc, fmt.Errorf("%w: version %s", ErrInvalidTraceparent, version)
+	}
+
+	hex.Decode(sc.TraceID[:], []byte(parts[1]))
+	hex.Decode(sc.SpanID[:], []byte(parts[2]))
+	var flags [1]byte
+	hex.Decode(flags[:], []byte(parts[3]))
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("%w: all-zero trace or parent ID", ErrInvalidTraceparent)
+	}
+	return sc, nil
+}
+
+// isLowerHex checks that s only has lowercase hex digits and dashes, and
+// exactly digits of them
+func isLowerHex(s string, digits int) bool {
+	count := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '-':
+		case (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f'):
+			count++
+		default:
+			return false
+		}
+	}
+	return count == digits
+}
+
+// validTracestate checks the list-members of a tracestate value, which is
+// dropped when invalid (W3C Trace Context section 3.3)
+func validTracestate(value string) bool {
+	members := 0
+	for _, member := range strings.Split(value, ",") {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+		key, val, found := strings.Cut(member, "=")
+		if !found || key == "" || val == "" || len(key) > 256 || len(val) > 256 {
+			return false
+		}
+		for i := 0; i < len(key); i++ {
+			c := key[i]
+			if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && !strings.ContainsRune("_-*/@", rune(c)) {
+				return false
+			}
+		}
+		for i := 0; i < len(val); i++ {
+			if val[i] < 0x20 || val[i] > 0x7E || val[i] == ',' || val[i] == '=' {
+				return false
+			}
+		}
+		members++
+	}
+	return members <= maxTracestateMembers
+}
+
+// Extract reads the span context from the traceparent and tracestate
+// fields
+func Extract(h headers.Headers) (SpanContext, bool) {
+	traceparent, isPresent := h.Get("traceparent")
+	if !isPresent {
+		return SpanContext{}, false
+	}
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		return SpanContext{}, false
+	}
+	if tracestate, isPresent := h.Get("tracestate"); isPresent && validTracestate(tracestate) {
+		sc.TraceState = tracestate
+	}
+	return sc, true
+}
+
+// Inject sets the traceparent and tracestate fields for the span context,
+// replacing those already present
+func Inject(h headers.Headers, sc SpanContext) {
+	h.Remove("traceparent")
+	h.Remove("tracestate")
+	if !sc.IsValid() {
+		return
+	}
+	h.Put("traceparent", sc.Traceparent())
+	if sc.TraceState != "" {
+		h.Put("tracestate", sc.TraceState)
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
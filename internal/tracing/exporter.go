@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// spanRecord is the JSON form of a span written by JSONExporter
+type spanRecord struct {
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Name       string            `json:"name"`
+	Kind       string            `json:"kind"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	DurationUS int64             `json:"duration_us"`
+	TraceState string            `json:"tracestate,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// JSONExporter writes every span as a line of JSON
+type JSONExporter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{encoder: json.NewEncoder(w)}
+}
+
+// NewFileExporter appends the spans to the file at path, creating it when
+// needed
+func NewFileExporter(path string) (*JSONExporter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	exporter := NewJSONExporter(file)
+	exporter.closer = file
+	return exporter, nil
+}
+
+func (e *JSONExporter) Export(span *Span) error {
+	// the span is held while encoding, its attributes are not copied
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	record := spanRecord{
+		TraceID:    span.Context.TraceID.String(),
+		SpanID:     span.Context.SpanID.String(),
+		Name:       span.Name,
+		Kind:       span.Kind,
+		Start:      span.Start,
+		End:        span.End,
+		DurationUS: span.End.Sub(span.Start).Microseconds(),
+		TraceState: span.Context.TraceState,
+		Attributes: span.Attributes,
+	}
+	if span.Parent.IsValid() {
+		record.ParentID = span.Parent.String()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.encoder.Encode(record)
+}
+
+// Close closes the file of exporters created with NewFileExporter
+func (e *JSONExporter) Close() error {
+	if e.closer == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.closer.Close()
+}
@@ -0,0 +1,200 @@
+package tracing
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// Span is a timed operation of a trace
+type Span struct {
+	Name    string
+	Context SpanContext
+	// Parent is invalid for the root span of a trace
+	Parent     SpanID
+	Kind       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+
+	tracer *Tracer
+	mu     sync.Mutex
+	ended  bool
+}
+
+const (
+	KindServer   = "server"
+	KindClient   = "client"
+	KindInternal = "internal"
+)
+
+// SetAttribute records a key/value pair on the span
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]string)
+	}
+	s.Attributes[key] = value
+}
+
+// Finish ends the span at the current time and exports it when the trace is
+// sampled. Further calls do nothing
+func (s *Span) Finish() {
+	s.FinishAt(time.Now())
+}
+
+func (s *Span) FinishAt(end time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = end
+	s.mu.Unlock()
+
+	if s.Context.Sampled() && s.tracer.Exporter != nil {
+		err := s.tracer.Exporter.Export(s)
+		if err != nil {
+			log.Printf("tracing: exporting span %s: %v", s.Name, err)
+		}
+	}
+}
+
+// Exporter sends finished spans to a tracing backend. It is called
+// concurrently from the goroutines handling the requests
+type Exporter interface {
+	Export(span *Span) error
+}
+
+// Tracer creates the spans of the requests handled behind its Middleware
+type Tracer struct {
+	Exporter Exporter
+	// Sample decides whether new traces are recorded, all of them when nil.
+	// Traces started upstream keep their sampled flag
+	Sample func(req *request.Request) bool
+}
+
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{Exporter: exporter}
+}
+
+// spanKey is the context key of the server span of a request
+type spanKey struct{}
+
+// SpanOf returns the server span of a request handled behind the
+// Middleware, or nil
+func SpanOf(req *request.Request) *Span {
+	return SpanFromContext(req.Context())
+}
+
+// SpanFromContext returns the server span stored in the context of a traced
+// request, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// StartSpan starts a span. It is a child of parent when parent is valid,
+// or else the root of a new trace
+func (t *Tracer) StartSpan(name, kind string, parent SpanContext, start time.Time) *Span {
+	span := &Span{Name: name, Kind: kind, Start: start, tracer: t}
+	span.Context = SpanContext{SpanID: newSpanID(), Flags: FlagSampled}
+	if parent.IsValid() {
+		span.Context.TraceID = parent.TraceID
+		span.Context.Flags = parent.Flags
+		span.Context.TraceState = parent.TraceState
+		span.Parent = parent.SpanID
+	} else {
+		span.Context.TraceID = newTraceID()
+	}
+	return span
+}
+
+// StartChild starts a span under the server span of req, or returns nil
+// when the request is not traced. Methods of a nil *Span do nothing, so the
+// result can be used unchecked
+func StartChild(req *request.Request, name, kind string) *Span {
+	parent := SpanOf(req)
+	if parent == nil {
+		return nil
+	}
+	return parent.tracer.StartSpan(name, kind, parent.Context, time.Now())
+}
+
+// SpanContext returns the context of the span, or an invalid context for a
+// nil span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+// Middleware traces the requests passing through it. Each request gets a
+// server span, continuing the trace of its traceparent header when valid,
+// with child spans for the phases of the exchange:
+//   - parse, from the first bytes read to the end of the header section
+//   - handler, until the response headers are written
+//   - write, from the headers until the handler returns
+func (t *Tracer) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		start := time.Now()
+		received, headersParsed := req.Timing()
+		if !received.IsZero() {
+			start = received
+		}
+
+		incoming, found := Extract(req.Headers)
+		span := t.StartSpan(req.RequestLine.Method, KindServer, incoming, start)
+		if !found && t.Sample != nil && !t.Sample(req) {
+			span.Context.Flags = 0
+		}
+		span.SetAttribute("http.method", req.RequestLine.Method)
+		span.SetAttribute("http.target", req.RequestLine.RequestTarget)
+		if req.RemoteAddr != "" {
+			span.SetAttribute("net.peer", req.RemoteAddr)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), spanKey{}, span))
+
+		if !headersParsed.IsZero() {
+			t.phase(span, "parse", start, headersParsed)
+		}
+
+		handlerStart := time.Now()
+		var headersWritten time.Time
+		w.BeforeWriteHeaders(func(status response.StatusCode, _ headers.Headers) {
+			headersWritten = time.Now()
+			span.SetAttribute("http.status_code", strconv.Itoa(int(status)))
+		})
+		next(w, req)
+		end := time.Now()
+
+		if headersWritten.IsZero() {
+			t.phase(span, "handler", handlerStart, end)
+		} else {
+			t.phase(span, "handler", handlerStart, headersWritten)
+			t.phase(span, "write", headersWritten, end)
+		}
+		span.FinishAt(end)
+	}
+}
+
+func (t *Tracer) phase(parent *Span, name string, start, end time.Time) {
+	span := t.StartSpan(name, KindInternal, parent.Context, start)
+	span.FinishAt(end)
+}
@@ -0,0 +1,216 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	// Test: Future versions are parsed as 00 and may add fields
+	sc, err = ParseTraceparent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-what-the-future-holds")
+	require.NoError(t, err)
+	assert.False(t, sc.Sampled())
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-600f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0g",
+		"cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.extra",
+	}
+	for _, value := range invalid {
+		_, err := ParseTraceparent(value)
+		assert.ErrorIs(t, err, ErrInvalidTraceparent, value)
+	}
+}
+
+func TestExtractInject(t *testing.T) {
+	h := headers.NewHeaders()
+	h.Put("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.Put("Tracestate", "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7")
+	sc, found := Extract(h)
+	require.True(t, found)
+	assert.Equal(t, "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7", sc.TraceState)
+
+	// Test: Invalid tracestate is dropped
+	h.Replace("tracestate", "Upper=case")
+	sc, found = Extract(h)
+	require.True(t, found)
+	assert.Empty(t, sc.TraceState)
+
+	// Test: Invalid traceparent
+	h.Replace("traceparent", "00-nope")
+	_, found = Extract(h)
+	assert.False(t, found)
+
+	out := headers.NewHeaders()
+	out.Put("Traceparent", "stale")
+	Inject(out, SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}, Flags: FlagSampled, TraceState: "rojo=1"})
+	traceparent, _ := out.Get("traceparent")
+	assert.Equal(t, "00-01000000000000000000000000000000-0200000000000000-01", traceparent)
+	tracestate, _ := out.Get("tracestate")
+	assert.Equal(t, "rojo=1", tracestate)
+}
+
+// memoryExporter keeps the exported spans
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *memoryExporter) Export(span *Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+	return nil
+}
+
+func (e *memoryExporter) byName(name string) *Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, span := range e.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func serve(t *testing.T, handler func(*response.Writer, *request.Request), raw string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	w := response.NewWriter()
+	handler(&w, req)
+}
+
+func TestMiddleware(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer(exporter)
+	var inner *Span
+	handler := tracer.Middleware(func(w *response.Writer, req *request.Request) {
+		inner = StartChild(req, "lookup", KindInternal)
+		inner.SetAttribute("table", "users")
+		inner.Finish()
+		w.WriteResponse(response.StatusOk, "ok")
+	})
+
+	serve(t, handler, "GET /users?id=1 HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n"+
+		"\r\n")
+
+	require.Len(t, exporter.spans, 5)
+	root := exporter.byName("GET")
+	require.NotNil(t, root)
+	assert.Equal(t, KindServer, root.Kind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", root.Context.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", root.Parent.String())
+	assert.Equal(t, "/users?id=1", root.Attributes["http.target"])
+	assert.Equal(t, "200", root.Attributes["http.status_code"])
+
+	for _, name := range []string{"parse", "handler", "write", "lookup"} {
+		span := exporter.byName(name)
+		require.NotNil(t, span, name)
+		assert.Equal(t, root.Context.TraceID, span.Context.TraceID, name)
+		assert.Equal(t, root.Context.SpanID, span.Parent, name)
+		assert.False(t, span.End.Before(span.Start), name)
+	}
+	assert.Equal(t, "users", inner.Attributes["table"])
+	assert.False(t, exporter.byName("parse").Start.Before(root.Start))
+	assert.False(t, exporter.byName("write").Start.Before(exporter.byName("handler").End))
+}
+
+func TestMiddlewareSampling(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer(exporter)
+	tracer.Sample = func(req *request.Request) bool { return false }
+	var child *Span
+	handler := tracer.Middleware(func(w *response.Writer, req *request.Request) {
+		child = StartChild(req, "lookup", KindInternal)
+		child.Finish()
+		w.WriteResponse(response.StatusOk, "ok")
+	})
+
+	// Test: New traces are not sampled
+	serve(t, handler, "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Empty(t, exporter.spans)
+	require.NotNil(t, child)
+	assert.False(t, child.SpanContext().Sampled())
+
+	// Test: Sampled upstream traces are kept
+	serve(t, handler, "GET / HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n"+
+		"\r\n")
+	assert.Len(t, exporter.spans, 5)
+
+	// Test: Untraced requests have no span
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Nil(t, SpanOf(req))
+	span := StartChild(req, "lookup", KindInternal)
+	assert.Nil(t, span)
+	span.SetAttribute("ignored", "yes")
+	span.Finish()
+	assert.False(t, span.SpanContext().IsValid())
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	exporter, err := NewFileExporter(path)
+	require.NoError(t, err)
+	handler := NewTracer(exporter).Middleware(func(w *response.Writer, req *request.Request) {
+		w.WriteResponse(response.StatusNotFound, "nope")
+	})
+	serve(t, handler, "GET /missing HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	require.NoError(t, exporter.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var records []map[string]any
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 4)
+
+	root := records[len(records)-1]
+	assert.Equal(t, "GET", root["name"])
+	assert.Equal(t, "server", root["kind"])
+	assert.Len(t, root["trace_id"], 32)
+	assert.Len(t, root["span_id"], 16)
+	assert.NotContains(t, root, "parent_id")
+	assert.Equal(t, "404", root["attributes"].(map[string]any)["http.status_code"])
+	for _, record := range records[:len(records)-1] {
+		assert.Equal(t, root["trace_id"], record["trace_id"])
+		assert.Equal(t, root["span_id"], record["parent_id"])
+	}
+}
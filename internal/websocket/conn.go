@@ -0,0 +1,264 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Close codes from RFC 6455 section 7.4.1
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+var ErrMessageTooLarge = errors.New("WebSocket message is too large")
+
+// closeTimeout bounds how long Close waits to send its close frame
+const closeTimeout = 5 * time.Second
+
+type Conn struct {
+	conn           net.Conn
+	reader         io.Reader
+	subprotocol    string
+	compress       bool
+	maxMessageSize int64
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+// Subprotocol returns the subprotocol selected during the handshake
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// Compressed reports whether permessage-deflate was negotiated
+func (c *Conn) Compressed() bool {
+	return c.compress
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage returns the next text or binary message, reassembling
+// fragmented messages. Pings are answered and pongs dropped along the way.
+// When the client closes the connection the close frame is echoed and a
+// *CloseError is returned. Protocol violations close the connection with the
+// matching close code
+func (c *Conn) ReadMessage() (Opcode, []byte, error) {
+	var messageType Opcode
+	var message []byte
+	compressed := false
+	inMessage := false
+
+	for {
+		frame, err := ReadFrame(c.reader, c.maxMessageSize-int64(len(message)))
+		if errors.Is(err, ErrFrameTooLarge) {
+			return 0, nil, c.fail(CloseMessageTooBig, "Message too big")
+		}
+		if err != nil {
+			if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+				return 0, nil, err
+			}
+			return 0, nil, c.fail(CloseProtocolError, err.Error())
+		}
+
+		if !frame.Masked {
+			return 0, nil, c.fail(CloseProtocolError, "Client frames must be masked")
+		}
+		if frame.Rsv2 || frame.Rsv3 {
+			return 0, nil, c.fail(CloseProtocolError, "Reserved bits set")
+		}
+		if frame.Rsv1 && (!c.compress || frame.Opcode.IsControl() || frame.Opcode == OpContinuation) {
+			return 0, nil, c.fail(CloseProtocolError, "Unexpected compressed frame")
+		}
+
+		switch frame.Opcode {
+		case OpPing:
+			err = c.writeFrame(Frame{Fin: true, Opcode: OpPong, Payload: frame.Payload})
+			// once our close frame is out, keep reading for the client's one
+			if err != nil && !errors.Is(err, net.ErrClosed) {
+				return 0, nil, err
+			}
+			continue
+
+		case OpPong:
+			continue
+
+		case OpClose:
+			return 0, nil, c.handleClose(frame.Payload)
+
+		case OpContinuation:
+			if !inMessage {
+				return 0, nil, c.fail(CloseProtocolError, "Continuation frame without a message")
+			}
+
+		default:
+			if inMessage {
+				return 0, nil, c.fail(CloseProtocolError, "New message before the previous one ended")
+			}
+			inMessage = true
+			messageType = frame.Opcode
+			compressed = frame.Rsv1
+		}
+
+		message = append(message, frame.Payload...)
+		if !frame.Fin {
+			continue
+		}
+
+		if compressed {
+			message, err = decompressMessage(message, c.maxMessageSize)
+			if errors.Is(err, ErrMessageTooLarge) {
+				return 0, nil, c.fail(CloseMessageTooBig, "Message too big")
+			}
+			if err != nil {
+				return 0, nil, c.fail(CloseInvalidPayload, "Invalid compressed message")
+			}
+		}
+		if messageType == OpText && !utf8.Valid(message) {
+			return 0, nil, c.fail(CloseInvalidPayload, "Text message is not valid UTF-8")
+		}
+		return messageType, message, nil
+	}
+}
+
+// WriteMessage sends a text or binary message in a single frame, compressed
+// when permessage-deflate was negotiated
+func (c *Conn) WriteMessage(messageType Opcode, data []byte) error {
+	if messageType != OpText && messageType != OpBinary {
+		return errors.New("WriteMessage only sends text and binary messages")
+	}
+
+	frame := Frame{Fin: true, Opcode: messageType, Payload: data}
+	if c.compress {
+		compressed, err := compressMessage(data)
+		if err != nil {
+			return err
+		}
+		frame.Payload = compressed
+		frame.Rsv1 = true
+	}
+	return c.writeFrame(frame)
+}
+
+// WriteFragments sends a message split into one frame per fragment
+func (c *Conn) WriteFragments(messageType Opcode, fragments ...[]byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+
+	for i, fragment := range fragments {
+		frame := Frame{Fin: i == len(fragments)-1, Opcode: OpContinuation, Payload: fragment}
+		if i == 0 {
+			frame.Opcode = messageType
+		}
+		if err := WriteFrame(c.conn, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Conn) Ping(data []byte) error {
+	return c.writeFrame(Frame{Fin: true, Opcode: OpPing, Payload: data})
+}
+
+// WriteClose starts the closing handshake. ReadMessage returns a
+// *CloseError once the client answers
+func (c *Conn) WriteClose(code int, reason string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeCloseLocked(code, reason)
+}
+
+// Close sends a normal close frame if none was sent yet and closes the
+// underlying connection without waiting for the client's answer
+func (c *Conn) Close() error {
+	c.writeMu.Lock()
+	c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	c.writeCloseLocked(CloseNormal, "")
+	c.writeMu.Unlock()
+	return c.conn.Close()
+}
+
+func (c *Conn) writeFrame(frame Frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	return WriteFrame(c.conn, frame)
+}
+
+func (c *Conn) writeCloseLocked(code int, reason string) error {
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+
+	var payload []byte
+	if code != CloseNoStatus {
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason...)
+		if len(payload) > maxControlPayload {
+			payload = payload[:maxControlPayload]
+		}
+	}
+	return WriteFrame(c.conn, Frame{Fin: true, Opcode: OpClose, Payload: payload})
+}
+
+// handleClose answers a close frame from the client
+func (c *Conn) handleClose(payload []byte) error {
+	if len(payload) == 0 {
+		c.WriteClose(CloseNoStatus, "")
+		return &CloseError{Code: CloseNoStatus}
+	}
+	if len(payload) == 1 {
+		return c.fail(CloseProtocolError, "Invalid close frame")
+	}
+
+	code := int(binary.BigEndian.Uint16(payload))
+	reason := payload[2:]
+	if !isValidCloseCode(code) {
+		return c.fail(CloseProtocolError, "Invalid close code")
+	}
+	if !utf8.Valid(reason) {
+		return c.fail(CloseInvalidPayload, "Close reason is not valid UTF-8")
+	}
+
+	c.WriteClose(code, "")
+	return &CloseError{Code: code, Reason: string(reason)}
+}
+
+// fail closes the connection after a protocol violation
+func (c *Conn) fail(code int, reason string) error {
+	c.WriteClose(code, reason)
+	c.conn.Close()
+	return &CloseError{Code: code, Reason: reason}
+}
+
+func isValidCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
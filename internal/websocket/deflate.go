@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+)
+
+const extensionPermessageDeflate = "permessage-deflate"
+
+// deflateTail is the empty stored block a deflate flush ends with, which
+// permessage-deflate strips from every message (RFC 7692 section 7.2.1)
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// negotiateDeflate picks the first permessage-deflate offer from the
+// Sec-WebSocket-Extensions header that can be accepted and returns the
+// extension response. Context takeover is disabled in both directions, so
+// every message is compressed on its own
+func negotiateDeflate(extensions string) (string, bool) {
+	for _, offer := range strings.Split(extensions, ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != extensionPermessageDeflate {
+			continue
+		}
+
+		acceptable := true
+		seen := make(map[string]bool)
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			name = strings.TrimSpace(name)
+			value = strings.Trim(strings.TrimSpace(value), "\"")
+			if seen[name] {
+				acceptable = false
+				break
+			}
+			seen[name] = true
+
+			switch name {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				// the compressor always uses a 32KB window
+				if value != "15" {
+					acceptable = false
+				}
+			default:
+				acceptable = false
+			}
+		}
+		if acceptable {
+			return extensionPermessageDeflate + "; server_no_context_takeover; client_no_context_takeover", true
+		}
+	}
+	return "", false
+}
+
+func compressMessage(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail), nil
+}
+
+// decompressMessage inflates a message, failing with ErrMessageTooLarge when
+// it expands past maxSize
+func decompressMessage(data []byte, maxSize int64) ([]byte, error) {
+	// restore the stripped tail and add a final empty block so the reader
+	// sees the end of the stream
+	stream := io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail), bytes.NewReader([]byte{0x01, 0x00, 0x00, 0xff, 0xff}))
+	fr := flate.NewReader(stream)
+	defer fr.Close()
+
+	decoded, err := io.ReadAll(io.LimitReader(fr, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decoded)) > maxSize {
+		return nil, ErrMessageTooLarge
+	}
+	return decoded, nil
+}
@@ -0,0 +1,178 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type Opcode byte
+
+const (
+	OpContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xA
+)
+
+// maxControlPayload is the largest payload a control frame may carry
+const maxControlPayload = 125
+
+var ErrFrameTooLarge = errors.New("WebSocket frame is too large")
+
+func (op Opcode) IsControl() bool {
+	return op&0x8 != 0
+}
+
+func (op Opcode) isValid() bool {
+	switch op {
+	case OpContinuation, OpText, OpBinary, OpClose, OpPing, OpPong:
+		return true
+	}
+	return false
+}
+
+// Frame is a single WebSocket frame (RFC 6455 section 5.2). Payload is
+// always unmasked, Masked and MaskKey describe how it is sent on the wire
+type Frame struct {
+	Fin     bool
+	Rsv1    bool
+	Rsv2    bool
+	Rsv3    bool
+	Opcode  Opcode
+	Masked  bool
+	MaskKey [4]byte
+	Payload []byte
+}
+
+// ReadFrame reads one frame from r and unmasks its payload. Frames with a
+// payload longer than maxPayload are rejected with ErrFrameTooLarge before
+// the payload is read
+func ReadFrame(r io.Reader, maxPayload int64) (Frame, error) {
+	var frame Frame
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return frame, err
+	}
+
+	frame.Fin = head[0]&0x80 != 0
+	frame.Rsv1 = head[0]&0x40 != 0
+	frame.Rsv2 = head[0]&0x20 != 0
+	frame.Rsv3 = head[0]&0x10 != 0
+	frame.Opcode = Opcode(head[0] & 0x0F)
+	frame.Masked = head[1]&0x80 != 0
+
+	if !frame.Opcode.isValid() {
+		return frame, fmt.Errorf("Invalid WebSocket opcode %#x", byte(frame.Opcode))
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return frame, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+		if length < 126 {
+			return frame, fmt.Errorf("Frame length is not minimally encoded")
+		}
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return frame, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length>>63 != 0 || length <= 0xFFFF {
+			return frame, fmt.Errorf("Frame length is not minimally encoded")
+		}
+	}
+
+	if frame.Opcode.IsControl() {
+		if !frame.Fin {
+			return frame, fmt.Errorf("Control frames must not be fragmented")
+		}
+		if length > maxControlPayload {
+			return frame, fmt.Errorf("Control frame payload is longer than %d bytes", maxControlPayload)
+		}
+	} else if length > uint64(max(maxPayload, 0)) {
+		return frame, ErrFrameTooLarge
+	}
+
+	if frame.Masked {
+		if _, err := io.ReadFull(r, frame.MaskKey[:]); err != nil {
+			return frame, err
+		}
+	}
+
+	frame.Payload = make([]byte, length)
+	if _, err := io.ReadFull(r, frame.Payload); err != nil {
+		return frame, err
+	}
+	if frame.Masked {
+		maskBytes(frame.MaskKey, frame.Payload)
+	}
+	return frame, nil
+}
+
+// WriteFrame writes the frame to w, masking the payload with MaskKey when
+// Masked is set. The frame's payload is left untouched
+func WriteFrame(w io.Writer, frame Frame) error {
+	header := make([]byte, 0, 14)
+
+	first := byte(frame.Opcode) & 0x0F
+	if frame.Fin {
+		first |= 0x80
+	}
+	if frame.Rsv1 {
+		first |= 0x40
+	}
+	if frame.Rsv2 {
+		first |= 0x20
+	}
+	if frame.Rsv3 {
+		first |= 0x10
+	}
+	header = append(header, first)
+
+	var maskBit byte
+	if frame.Masked {
+		maskBit = 0x80
+	}
+	length := len(frame.Payload)
+	switch {
+	case length <= 125:
+		header = append(header, maskBit|byte(length))
+	case length <= 0xFFFF:
+		header = append(header, maskBit|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header = append(header, maskBit|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	payload := frame.Payload
+	if frame.Masked {
+		header = append(header, frame.MaskKey[:]...)
+		payload = make([]byte, length)
+		copy(payload, frame.Payload)
+		maskBytes(frame.MaskKey, payload)
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// maskBytes applies the masking algorithm of RFC 6455 section 5.3, which is
+// its own inverse
+func maskBytes(key [4]byte, data []byte) {
+	for i := range data {
+		data[i] ^= key[i%4]
+	}
+}
@@ -0,0 +1,176 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// acceptGUID is appended to the client key to compute Sec-WebSocket-Accept
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const DefaultMaxMessageSize = 1 << 20
+
+var ErrBadHandshake = errors.New("Invalid WebSocket handshake")
+
+type Options struct {
+	// Subprotocols lists the supported subprotocols in order of preference
+	Subprotocols []string
+	// EnableCompression accepts the permessage-deflate extension when the
+	// client offers it
+	EnableCompression bool
+	// MaxMessageSize bounds the size of a received message after
+	// decompression, DefaultMaxMessageSize is used when zero
+	MaxMessageSize int64
+}
+
+// IsUpgradeRequest reports whether the request asks to switch the
+// connection to the WebSocket protocol
+func IsUpgradeRequest(req *request.Request) bool {
+	return headerHasToken(req.Headers, "Connection", "upgrade") && headerHasToken(req.Headers, "Upgrade", "websocket")
+}
+
+// AcceptKey computes the Sec-WebSocket-Accept value for a client key
+func AcceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// Upgrade validates the opening handshake (RFC 6455 section 4.2), answers it
+// with 101 Switching Protocols and takes over the connection. On failure an
+// error response has already been written to the client
+func Upgrade(w *response.Writer, req *request.Request, opts Options) (*Conn, error) {
+	if req.RequestLine.Method != "GET" || !IsUpgradeRequest(req) {
+		w.WriteResponse(response.StatusBadRequest, "Not a WebSocket handshake")
+		return nil, ErrBadHandshake
+	}
+
+	if version, _ := req.Headers.Get("Sec-WebSocket-Version"); strings.TrimSpace(version) != "13" {
+		msg := "Unsupported WebSocket version"
+		header := response.GetDefaultHeader(len(msg))
+		header.Put("Sec-WebSocket-Version", "13")
+		w.WriteStatusLine(response.StatusUpgradeRequired)
+		w.WriteHeaders(header)
+		w.WriteBody(msg)
+		return nil, ErrBadHandshake
+	}
+
+	key, _ := req.Headers.Get("Sec-WebSocket-Key")
+	key = strings.TrimSpace(key)
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		w.WriteResponse(response.StatusBadRequest, "Invalid Sec-WebSocket-Key")
+		return nil, ErrBadHandshake
+	}
+
+	header := headers.NewHeaders()
+	header.Put("Upgrade", "websocket")
+	header.Put("Connection", "Upgrade")
+	header.Put("Sec-WebSocket-Accept", AcceptKey(key))
+
+	subprotocol := ""
+	if offered, isPresent := req.Headers.Get("Sec-WebSocket-Protocol"); isPresent {
+		subprotocol = selectSubprotocol(offered, opts.Subprotocols)
+		if subprotocol != "" {
+			header.Put("Sec-WebSocket-Protocol", subprotocol)
+		}
+	}
+
+	compress := false
+	if extensions, isPresent := req.Headers.Get("Sec-WebSocket-Extensions"); isPresent && opts.EnableCompression {
+		var extension string
+		extension, compress = negotiateDeflate(extensions)
+		if compress {
+			header.Put("Sec-WebSocket-Extensions", extension)
+		}
+	}
+
+	if err := w.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(header); err != nil {
+		return nil, err
+	}
+	netConn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	maxMessageSize := opts.MaxMessageSize
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultMaxMessageSize
+	}
+	reader := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), netConn))
+	return newConn(netConn, reader, subprotocol, compress, maxMessageSize), nil
+}
+
+// Handler returns a server.Handler that upgrades every request and passes the
+// WebSocket connection to handle. The connection is closed when handle
+// returns
+func Handler(handle func(*Conn, *request.Request), opts Options) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		conn, err := Upgrade(w, req, opts)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn, req)
+	}
+}
+
+func selectSubprotocol(offered string, supported []string) string {
+	for _, protocol := range supported {
+		for _, candidate := range strings.Split(offered, ",") {
+			if strings.TrimSpace(candidate) == protocol {
+				return protocol
+			}
+		}
+	}
+	return ""
+}
+
+func headerHasToken(h headers.Headers, name, token string) bool {
+	value, isPresent := h.Get(name)
+	if !isPresent {
+		return false
+	}
+	for _, candidate := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(candidate), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// newConn wraps a hijacked connection. Reads go through r, which may hold
+// bytes the client sent right after the handshake
+func newConn(netConn net.Conn, r io.Reader, subprotocol string, compress bool, maxMessageSize int64) *Conn {
+	return &Conn{
+		conn:           netConn,
+		reader:         r,
+		subprotocol:    subprotocol,
+		compress:       compress,
+		maxMessageSize: maxMessageSize,
+	}
+}
+
+// CloseError is returned by ReadMessage once the connection is closed with
+// a close frame
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("WebSocket closed with code %d %s", e.Code, e.Reason)
+}
@@ -0,0 +1,253 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const handshake = "GET /chat HTTP/1.1\r\n" +
+	"Host: localhost:8080\r\n" +
+	"Upgrade: websocket\r\n" +
+	"Connection: keep-alive, Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+	"Sec-WebSocket-Version: 13\r\n"
+
+type testClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func echoServer(t *testing.T, opts Options) *server.Server {
+	t.Helper()
+	s, err := server.Serve(0, Handler(func(conn *Conn, req *request.Request) {
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(messageType, data)
+		}
+	}, opts))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func dial(t *testing.T, s *server.Server, extraHeaders string) (*testClient, *http.Response) {
+	t.Helper()
+	_, port, err := net.SplitHostPort(s.Addr().String())
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = io.WriteString(conn, handshake+extraHeaders+"\r\n")
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	return &testClient{conn: conn, reader: reader}, resp
+}
+
+func (c *testClient) send(t *testing.T, frame Frame) {
+	t.Helper()
+	frame.Masked = true
+	frame.MaskKey = [4]byte{0x12, 0x34, 0x56, 0x78}
+	require.NoError(t, WriteFrame(c.conn, frame))
+}
+
+func (c *testClient) receive(t *testing.T) Frame {
+	t.Helper()
+	frame, err := ReadFrame(c.reader, 1<<20)
+	require.NoError(t, err)
+	assert.False(t, frame.Masked)
+	return frame
+}
+
+func closeCode(frame Frame) int {
+	if len(frame.Payload) < 2 {
+		return CloseNoStatus
+	}
+	return int(binary.BigEndian.Uint16(frame.Payload))
+}
+
+func TestAcceptKey(t *testing.T) {
+	// example from RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestHandshake(t *testing.T) {
+	s := echoServer(t, Options{Subprotocols: []string{"chat", "superchat"}})
+
+	_, resp := dial(t, s, "Sec-WebSocket-Protocol: superchat, chat\r\n")
+	assert.Equal(t, 101, resp.StatusCode)
+	assert.Equal(t, "websocket", resp.Header.Get("Upgrade"))
+	assert.Equal(t, "Upgrade", resp.Header.Get("Connection"))
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	assert.Equal(t, "chat", resp.Header.Get("Sec-WebSocket-Protocol"))
+	assert.Empty(t, resp.Header.Get("Sec-WebSocket-Extensions"))
+
+	// Test: Wrong version
+	_, port, err := net.SplitHostPort(s.Addr().String())
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	defer conn.Close()
+	io.WriteString(conn, "GET / HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 8\r\n\r\n")
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 426, resp.StatusCode)
+	assert.Equal(t, "13", resp.Header.Get("Sec-WebSocket-Version"))
+
+	// Test: Missing key
+	conn2, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	defer conn2.Close()
+	io.WriteString(conn2, "GET / HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	resp, err = http.ReadResponse(bufio.NewReader(conn2), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestEcho(t *testing.T) {
+	s := echoServer(t, Options{})
+	client, _ := dial(t, s, "")
+
+	// Test: Text message
+	client.send(t, Frame{Fin: true, Opcode: OpText, Payload: []byte("hello")})
+	frame := client.receive(t)
+	assert.Equal(t, OpText, frame.Opcode)
+	assert.Equal(t, "hello", string(frame.Payload))
+
+	// Test: Fragmented binary message with an interleaved ping
+	client.send(t, Frame{Opcode: OpBinary, Payload: []byte{1, 2}})
+	client.send(t, Frame{Fin: true, Opcode: OpPing, Payload: []byte("ping")})
+	client.send(t, Frame{Opcode: OpContinuation, Payload: []byte{3}})
+	client.send(t, Frame{Fin: true, Opcode: OpContinuation, Payload: []byte{4, 5}})
+	frame = client.receive(t)
+	assert.Equal(t, OpPong, frame.Opcode)
+	assert.Equal(t, "ping", string(frame.Payload))
+	frame = client.receive(t)
+	assert.Equal(t, OpBinary, frame.Opcode)
+	assert.Equal(t, []byte{1, 2, 3, 4, 5}, frame.Payload)
+
+	// Test: Large message uses the extended length
+	large := bytes.Repeat([]byte("x"), 70000)
+	client.send(t, Frame{Fin: true, Opcode: OpBinary, Payload: large})
+	frame = client.receive(t)
+	assert.Equal(t, large, frame.Payload)
+
+	// Test: Closing handshake is echoed
+	client.send(t, Frame{Fin: true, Opcode: OpClose, Payload: []byte{0x03, 0xE8, 'b', 'y', 'e'}})
+	frame = client.receive(t)
+	assert.Equal(t, OpClose, frame.Opcode)
+	assert.Equal(t, CloseNormal, closeCode(frame))
+}
+
+func TestProtocolErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		frames []Frame
+		masked bool
+		code   int
+	}{
+		{name: "unmasked frame", frames: []Frame{{Fin: true, Opcode: OpText, Payload: []byte("hi")}}, code: CloseProtocolError},
+		{name: "continuation without message", frames: []Frame{{Fin: true, Opcode: OpContinuation, Payload: []byte("hi")}}, masked: true, code: CloseProtocolError},
+		{name: "interleaved data message", frames: []Frame{{Opcode: OpText, Payload: []byte("a")}, {Fin: true, Opcode: OpText, Payload: []byte("b")}}, masked: true, code: CloseProtocolError},
+		{name: "invalid utf8", frames: []Frame{{Fin: true, Opcode: OpText, Payload: []byte{0xff, 0xfe}}}, masked: true, code: CloseInvalidPayload},
+		{name: "compressed without extension", frames: []Frame{{Fin: true, Rsv1: true, Opcode: OpText, Payload: []byte("hi")}}, masked: true, code: CloseProtocolError},
+		{name: "fragmented control frame", frames: []Frame{{Opcode: OpPing, Payload: []byte("hi")}}, masked: true, code: CloseProtocolError},
+		{name: "invalid close code", frames: []Frame{{Fin: true, Opcode: OpClose, Payload: []byte{0x03, 0xED}}}, masked: true, code: CloseProtocolError},
+		{name: "message too big", frames: []Frame{{Fin: true, Opcode: OpBinary, Payload: make([]byte, 2048)}}, masked: true, code: CloseMessageTooBig},
+	}
+
+	s := echoServer(t, Options{MaxMessageSize: 1024})
+	for _, c := range cases {
+		client, _ := dial(t, s, "")
+		for _, frame := range c.frames {
+			if c.masked {
+				client.send(t, frame)
+			} else {
+				require.NoError(t, WriteFrame(client.conn, frame))
+			}
+		}
+		frame := client.receive(t)
+		assert.Equal(t, OpClose, frame.Opcode, c.name)
+		assert.Equal(t, c.code, closeCode(frame), c.name)
+	}
+}
+
+func TestPermessageDeflate(t *testing.T) {
+	s := echoServer(t, Options{EnableCompression: true})
+
+	// Test: Offer with unsupported window size is declined, the next one accepted
+	client, resp := dial(t, s, "Sec-WebSocket-Extensions: permessage-deflate; server_max_window_bits=10, permessage-deflate; client_max_window_bits\r\n")
+	assert.Equal(t, "permessage-deflate; server_no_context_takeover; client_no_context_takeover", resp.Header.Get("Sec-WebSocket-Extensions"))
+
+	message := bytes.Repeat([]byte("compress me please "), 100)
+	compressed, err := compressMessage(message)
+	require.NoError(t, err)
+	require.Less(t, len(compressed), len(message))
+
+	// Test: Compressed message split over two frames
+	client.send(t, Frame{Rsv1: true, Opcode: OpText, Payload: compressed[:10]})
+	client.send(t, Frame{Fin: true, Opcode: OpContinuation, Payload: compressed[10:]})
+	frame := client.receive(t)
+	assert.True(t, frame.Rsv1)
+	decoded, err := decompressMessage(frame.Payload, 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, message, decoded)
+
+	// Test: Uncompressed messages are still accepted
+	client.send(t, Frame{Fin: true, Opcode: OpText, Payload: []byte("plain")})
+	frame = client.receive(t)
+	decoded, err = decompressMessage(frame.Payload, 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(decoded))
+
+	// Test: Compression bomb is rejected
+	bomb, err := compressMessage(make([]byte, 4<<20))
+	require.NoError(t, err)
+	client.send(t, Frame{Fin: true, Rsv1: true, Opcode: OpBinary, Payload: bomb})
+	frame = client.receive(t)
+	assert.Equal(t, OpClose, frame.Opcode)
+	assert.Equal(t, CloseMessageTooBig, closeCode(frame))
+
+	// Test: Extension is not negotiated when disabled
+	_, resp = dial(t, echoServer(t, Options{}), "Sec-WebSocket-Extensions: permessage-deflate\r\n")
+	assert.Empty(t, resp.Header.Get("Sec-WebSocket-Extensions"))
+}
+
+func TestReadMessageClose(t *testing.T) {
+	received := make(chan error, 1)
+	s, err := server.Serve(0, Handler(func(conn *Conn, req *request.Request) {
+		_, _, err := conn.ReadMessage()
+		received <- err
+	}, Options{}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	client, _ := dial(t, s, "")
+	client.send(t, Frame{Fin: true, Opcode: OpClose, Payload: []byte{0x0F, 0xA0, 'o', 'k'}})
+
+	err = <-received
+	var closeErr *CloseError
+	require.True(t, errors.As(err, &closeErr))
+	assert.Equal(t, 4000, closeErr.Code)
+	assert.Equal(t, "ok", closeErr.Reason)
+}